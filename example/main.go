@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -87,6 +88,12 @@ func main() {
 
 	waitClose()
 
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := client.Shutdown(shutdownCtx); err != nil {
+		logger.Error("shutdown client error", zap.Error(err))
+	}
+	shutdownCancel()
+
 	cf()
 
 	client.Wait()
@@ -6,6 +6,7 @@ require (
 	github.com/eoscanada/eos-go v0.10.0
 	github.com/pkg/errors v0.9.1
 	go.etcd.io/bbolt v1.3.6
+	go.uber.org/multierr v1.7.0
 	go.uber.org/zap v1.19.1
 )
 
@@ -22,7 +23,6 @@ require (
 	github.com/tidwall/pretty v1.2.0 // indirect
 	go.opencensus.io v0.22.1 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
-	go.uber.org/multierr v1.7.0 // indirect
 	golang.org/x/crypto v0.0.0-20200406173513-056763e48d71 // indirect
 	golang.org/x/sys v0.0.0-20210510120138-977fb7262007 // indirect
 )
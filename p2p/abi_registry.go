@@ -0,0 +1,277 @@
+package p2p
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/store"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// abiVersion a abi of account from the position in chain, abi is nil if cleared
+type abiVersion struct {
+	blockNum uint32
+	trxIndex uint32
+	blockID  Checksum256
+	abi      *ABI
+}
+
+func (v *abiVersion) isBefore(blockNum, trxIndex uint32) bool {
+	return v.blockNum < blockNum || (v.blockNum == blockNum && v.trxIndex <= trxIndex)
+}
+
+// ABIRegistry the abis of contracts, learned from setabi actions in blocks or preloaded,
+// the abi used to decode an action is the one set before the action in chain, it is safe for concurrent use
+type ABIRegistry struct {
+	logger *zap.Logger
+	storer store.ABIStorer
+
+	mutex    sync.RWMutex
+	versions map[AccountName][]*abiVersion
+}
+
+// NewABIRegistry create abi registry, the abis learned will be persisted in storer if it is not nil
+func NewABIRegistry(logger *zap.Logger, storer store.ABIStorer) (*ABIRegistry, error) {
+	res := &ABIRegistry{
+		logger:   logger,
+		storer:   storer,
+		versions: make(map[AccountName][]*abiVersion, 16),
+	}
+
+	if storer == nil {
+		return res, nil
+	}
+
+	records, err := storer.LoadABIs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range records {
+		v, err := newABIVersion(r.BlockNum, r.TrxIndex, r.BlockID, r.ABI)
+		if err != nil {
+			logger.Warn("load abi error", zap.String("account", r.Account), zap.Error(err))
+			continue
+		}
+		res.add(AccountName(r.Account), v)
+	}
+
+	return res, nil
+}
+
+func newABIVersion(blockNum, trxIndex uint32, blockID Checksum256, data []byte) (*abiVersion, error) {
+	res := &abiVersion{
+		blockNum: blockNum,
+		trxIndex: trxIndex,
+		blockID:  blockID,
+	}
+
+	if len(data) == 0 {
+		return res, nil
+	}
+
+	abi, err := types.DecodeABI(data)
+	if err != nil {
+		return nil, err
+	}
+	res.abi = abi
+
+	return res, nil
+}
+
+// add add the version to account, keep versions ordered by position
+func (r *ABIRegistry) add(account AccountName, v *abiVersion) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	vs := r.versions[account]
+	idx := sort.Search(len(vs), func(i int) bool {
+		return !vs[i].isBefore(v.blockNum, v.trxIndex)
+	})
+
+	// replace the version in same position, such as the block replayed
+	if idx > 0 && vs[idx-1].blockNum == v.blockNum && vs[idx-1].trxIndex == v.trxIndex {
+		vs[idx-1] = v
+		return
+	}
+
+	vs = append(vs, nil)
+	copy(vs[idx+1:], vs[idx:])
+	vs[idx] = v
+	r.versions[account] = vs
+}
+
+// SetABI preload the abi of account, it is used for the actions before any setabi learned
+func (r *ABIRegistry) SetABI(account AccountName, abi *ABI) {
+	r.add(account, &abiVersion{abi: abi})
+}
+
+// LoadABIFile preload the abi of account from a json file
+func (r *ABIRegistry) LoadABIFile(account AccountName, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "open abi file %s", path)
+	}
+	defer f.Close()
+
+	abi, err := types.NewABIFromJSON(f)
+	if err != nil {
+		return errors.Wrapf(err, "read abi file %s", path)
+	}
+
+	r.SetABI(account, abi)
+	return nil
+}
+
+// ABIAt get the abi of account for the action in the trx of block
+func (r *ABIRegistry) ABIAt(account AccountName, blockNum, trxIndex uint32) (*ABI, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	vs := r.versions[account]
+	for i := len(vs) - 1; i >= 0; i-- {
+		if vs[i].isBefore(blockNum, trxIndex) {
+			return vs[i].abi, vs[i].abi != nil
+		}
+	}
+
+	return nil, false
+}
+
+// DecodeAction decode the data of action in the trx of block to json by abi
+func (r *ABIRegistry) DecodeAction(act *Action, blockNum, trxIndex uint32) ([]byte, error) {
+	abi, ok := r.ABIAt(act.Account, blockNum, trxIndex)
+	if !ok {
+		return nil, errors.Errorf("no abi for %s at %d", act.Account, blockNum)
+	}
+
+	res, err := abi.DecodeAction(act.ActionData.HexData, act.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode %s::%s", act.Account, act.Name)
+	}
+
+	return res, nil
+}
+
+// DecodeActionToMap decode the data of action in the trx of block to a map by abi
+func (r *ABIRegistry) DecodeActionToMap(act *Action, blockNum, trxIndex uint32) (map[string]interface{}, error) {
+	data, err := r.DecodeAction(act, blockNum, trxIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]interface{}, 8)
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, errors.Wrap(err, "unmarshal action json")
+	}
+
+	return res, nil
+}
+
+// Name implements BlockHandler interface
+func (r *ABIRegistry) Name() string {
+	return "abiRegistry"
+}
+
+// OnBlockEvent implements BlockHandler interface, learn abis from the new blocks, drop the ones undone
+func (r *ABIRegistry) OnBlockEvent(ev *BlockEvent) {
+	switch ev.Type {
+	case BlockEventNew:
+		r.learn(ev.BlockNum, ev.BlockID, ev.Block)
+	case BlockEventUndo:
+		r.undo(ev.BlockNum, ev.BlockID)
+	}
+}
+
+// learn learn abis by the setabi actions in block
+func (r *ABIRegistry) learn(blockNum uint32, blockID Checksum256, blk *SignedBlock) {
+	for trxIdx, receipt := range blk.Transactions {
+		if receipt.Transaction.Packed == nil || receipt.Status != types.TransactionStatusExecuted {
+			continue
+		}
+
+		trx, err := types.DecodeTransaction(receipt.Transaction.Packed)
+		if err != nil {
+			r.logger.Debug("decode trx error", zap.Uint32("block", blockNum), zap.Error(err))
+			continue
+		}
+
+		for _, act := range trx.Actions {
+			setABI, ok, err := types.DecodeSetABI(act)
+			if !ok {
+				continue
+			}
+
+			if err != nil {
+				r.logger.Warn("decode setabi error", zap.Uint32("block", blockNum), zap.Error(err))
+				continue
+			}
+
+			r.onSetABI(setABI, blockNum, uint32(trxIdx), blockID)
+		}
+	}
+}
+
+func (r *ABIRegistry) onSetABI(setABI *types.SetABI, blockNum, trxIndex uint32, blockID Checksum256) {
+	v, err := newABIVersion(blockNum, trxIndex, blockID, setABI.ABI)
+	if err != nil {
+		r.logger.Warn("decode abi in setabi error",
+			zap.String("account", string(setABI.Account)), zap.Uint32("block", blockNum), zap.Error(err))
+		return
+	}
+
+	r.logger.Info("learn abi",
+		zap.String("account", string(setABI.Account)), zap.Uint32("block", blockNum))
+	r.add(setABI.Account, v)
+
+	if r.storer == nil {
+		return
+	}
+
+	if err := r.storer.PutABI(&store.ABIRecord{
+		Account:  string(setABI.Account),
+		BlockNum: blockNum,
+		TrxIndex: trxIndex,
+		BlockID:  blockID,
+		ABI:      setABI.ABI,
+	}); err != nil {
+		r.logger.Warn("persist abi error", zap.String("account", string(setABI.Account)), zap.Error(err))
+	}
+}
+
+// undo drop the abis set in the block
+func (r *ABIRegistry) undo(blockNum uint32, blockID Checksum256) {
+	isRemoved := false
+
+	r.mutex.Lock()
+	for account, vs := range r.versions {
+		kept := vs[:0]
+		for _, v := range vs {
+			if v.blockNum == blockNum && types.IsChecksumEq(v.blockID, blockID) {
+				isRemoved = true
+				continue
+			}
+			kept = append(kept, v)
+		}
+		r.versions[account] = kept
+	}
+	r.mutex.Unlock()
+
+	if !isRemoved || r.storer == nil {
+		return
+	}
+
+	if err := r.storer.DeleteABIs(blockNum, blockID); err != nil {
+		r.logger.Warn("delete abis error", zap.Uint32("block", blockNum), zap.Error(err))
+	}
+}
+
+// ABIRegistry the abi registry set by WithABIRegistry, nil if not set
+func (c *Client) ABIRegistry() *ABIRegistry {
+	return c.abis
+}
@@ -0,0 +1,180 @@
+package p2p_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	eos "github.com/eoscanada/eos-go"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// newABIJSONForTest a abi with a action `hi` which data has field `user` and fields in extra
+func newABIJSONForTest(extra ...string) string {
+	fields := `{"name":"user","type":"name"}`
+	for _, f := range extra {
+		fields += `,{"name":"` + f + `","type":"uint32"}`
+	}
+
+	return `{"version":"eosio::abi/1.1","structs":[{"name":"hi","base":"","fields":[` + fields + `]}],` +
+		`"actions":[{"name":"hi","type":"hi","ricardian_contract":""}]}`
+}
+
+func newSetABIForTest(t *testing.T, abiJSON string) []*types.Action {
+	abi, err := types.NewABIFromJSON(bytes.NewBufferString(abiJSON))
+	if err != nil {
+		t.Fatalf("new abi error %s", err.Error())
+	}
+
+	abiData, err := eos.MarshalBinary(abi)
+	if err != nil {
+		t.Fatalf("encode abi error %s", err.Error())
+	}
+
+	data, err := eos.MarshalBinary(&types.SetABI{Account: types.AccountName("hello"), ABI: abiData})
+	if err != nil {
+		t.Fatalf("encode setabi error %s", err.Error())
+	}
+
+	return []*types.Action{{
+		Account:       types.SystemAccount,
+		Name:          types.SetABIAction,
+		Authorization: []types.PermissionLevel{{Actor: types.AccountName("hello"), Permission: eos.PermissionName("active")}},
+		ActionData:    eos.NewActionDataFromHexData(data),
+	}}
+}
+
+func newHiActionForTest(t *testing.T, extra uint32) *types.Action {
+	data, err := eos.MarshalBinary(&struct {
+		User  types.AccountName
+		Extra uint32
+	}{User: types.AccountName("alice"), Extra: extra})
+	if err != nil {
+		t.Fatalf("encode hi error %s", err.Error())
+	}
+
+	return &types.Action{
+		Account:    types.AccountName("hello"),
+		Name:       types.ActionName("hi"),
+		ActionData: eos.NewActionDataFromHexData(data),
+	}
+}
+
+func blockEventForTest(typ p2p.BlockEventType, blk *types.SignedBlock) *p2p.BlockEvent {
+	id, _ := blk.BlockID()
+	return &p2p.BlockEvent{
+		Type:     typ,
+		BlockNum: blk.BlockNumber(),
+		BlockID:  id,
+		Block:    blk,
+	}
+}
+
+// TestABIRegistry test abis learned from setabi in blocks, reloaded from storer and undone
+func TestABIRegistry(t *testing.T) {
+	logger := zap.NewNop()
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForTest),
+		types.WithGenTransactions(func(blockNum uint32) [][]*types.Action {
+			switch blockNum {
+			case 3:
+				return [][]*types.Action{newSetABIForTest(t, newABIJSONForTest())}
+			case 6:
+				return [][]*types.Action{newSetABIForTest(t, newABIJSONForTest("extra"))}
+			}
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	blocks, err := gen.GenerateTo(8)
+	if err != nil {
+		t.Fatalf("generate error %s", err.Error())
+	}
+
+	storer := newStorerForTest(t, logger)
+	reg, err := p2p.NewABIRegistry(logger, storer)
+	if err != nil {
+		t.Fatalf("new registry error %s", err.Error())
+	}
+
+	for _, blk := range blocks {
+		reg.OnBlockEvent(blockEventForTest(p2p.BlockEventNew, blk))
+	}
+
+	act := newHiActionForTest(t, 7)
+	decodeAt := func(r *p2p.ABIRegistry, blockNum uint32) map[string]interface{} {
+		res, err := r.DecodeActionToMap(act, blockNum, 0)
+		if err != nil {
+			t.Fatalf("decode at %d error %s", blockNum, err.Error())
+		}
+		return res
+	}
+
+	if _, ok := reg.ABIAt(types.AccountName("hello"), 2, 0); ok {
+		t.Errorf("abi should not exist before set")
+	}
+
+	if res := decodeAt(reg, 5); res["user"] != "alice" || res["extra"] != nil {
+		t.Errorf("decode by abi v1 diff %v", res)
+	}
+
+	if res := decodeAt(reg, 7); res["user"] != "alice" || res["extra"] != float64(7) {
+		t.Errorf("decode by abi v2 diff %v", res)
+	}
+
+	// the abis should be loaded from storer
+	reloaded, err := p2p.NewABIRegistry(logger, storer)
+	if err != nil {
+		t.Fatalf("reload registry error %s", err.Error())
+	}
+
+	if res := decodeAt(reloaded, 7); res["extra"] != float64(7) {
+		t.Errorf("decode by reloaded abi v2 diff %v", res)
+	}
+
+	// undo block 6 should drop abi v2 in registry and storer
+	reloaded.OnBlockEvent(blockEventForTest(p2p.BlockEventUndo, blocks[5]))
+	if res := decodeAt(reloaded, 7); res["extra"] != nil {
+		t.Errorf("decode after undo diff %v", res)
+	}
+
+	reloaded, err = p2p.NewABIRegistry(logger, storer)
+	if err != nil {
+		t.Fatalf("reload registry error %s", err.Error())
+	}
+
+	if res := decodeAt(reloaded, 7); res["extra"] != nil {
+		t.Errorf("decode after undo and reload diff %v", res)
+	}
+}
+
+// TestABIRegistryLoadFile test preload abi from json file
+func TestABIRegistryLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hello.abi")
+	if err := ioutil.WriteFile(path, []byte(newABIJSONForTest("extra")), 0644); err != nil {
+		t.Fatalf("write abi file error %s", err.Error())
+	}
+
+	reg, err := p2p.NewABIRegistry(zap.NewNop(), nil)
+	if err != nil {
+		t.Fatalf("new registry error %s", err.Error())
+	}
+
+	if err := reg.LoadABIFile(types.AccountName("hello"), path); err != nil {
+		t.Fatalf("load abi file error %s", err.Error())
+	}
+
+	res, err := reg.DecodeActionToMap(newHiActionForTest(t, 3), 1, 0)
+	if err != nil {
+		t.Fatalf("decode error %s", err.Error())
+	}
+
+	if res["user"] != "alice" || res["extra"] != float64(3) {
+		t.Errorf("decode by preloaded abi diff %v", res)
+	}
+}
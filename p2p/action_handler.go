@@ -0,0 +1,217 @@
+package p2p
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// ActionFilter filter for the actions to subscribe, the empty fields match any action
+type ActionFilter struct {
+	// Account the contract of action
+	Account AccountName
+	// Name the name of action
+	Name ActionName
+	// Actor one of the actors in authorization of action
+	Actor AccountName
+	// Data the fields of action data decoded by abi, the value is matched by its string form,
+	// actions cannot be decoded not match if Data is not empty
+	Data map[string]string
+}
+
+// ActionContext an action matched in block, with the position of it in chain
+type ActionContext struct {
+	Type        BlockEventType
+	BlockNum    uint32
+	BlockID     Checksum256
+	TrxID       Checksum256
+	TrxIndex    uint32
+	ActionIndex uint32
+	Action      *Action
+	// Data the action data decoded by abi, nil if there is no abi registry or no abi for the action
+	Data map[string]interface{}
+}
+
+// ActionCallback callback for the actions matched, called IN peerLoop
+type ActionCallback func(ctx *ActionContext)
+
+type actionSubscription struct {
+	filter   ActionFilter
+	callback ActionCallback
+}
+
+func (s *actionSubscription) matchAction(act *Action) bool {
+	if s.filter.Account != "" && s.filter.Account != act.Account {
+		return false
+	}
+
+	if s.filter.Name != "" && s.filter.Name != act.Name {
+		return false
+	}
+
+	if s.filter.Actor == "" {
+		return true
+	}
+
+	for _, auth := range act.Authorization {
+		if auth.Actor == s.filter.Actor {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *actionSubscription) matchData(data map[string]interface{}) bool {
+	if len(s.filter.Data) == 0 {
+		return true
+	}
+
+	if data == nil {
+		return false
+	}
+
+	for field, expect := range s.filter.Data {
+		v, ok := data[field]
+		if !ok || dataFieldString(v) != expect {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dataFieldString the string form of field in action data decoded from json
+func dataFieldString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// ActionHandler block handler which unpacks the executed trxs in blocks of a event type,
+// and calls the callbacks for the actions matched, context free actions are not included
+type ActionHandler struct {
+	name      string
+	eventType BlockEventType
+	logger    *zap.Logger
+	abis      *ABIRegistry
+
+	mutex sync.RWMutex
+	subs  []*actionSubscription
+}
+
+// NewActionHandler create action handler for the blocks of event type, the action data is decoded by abis if not nil
+func NewActionHandler(logger *zap.Logger, name string, eventType BlockEventType, abis *ABIRegistry) *ActionHandler {
+	return &ActionHandler{
+		name:      name,
+		eventType: eventType,
+		logger:    logger,
+		abis:      abis,
+		subs:      make([]*actionSubscription, 0, 8),
+	}
+}
+
+// Subscribe add a callback for the actions matched filter
+func (h *ActionHandler) Subscribe(filter ActionFilter, callback ActionCallback) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.subs = append(h.subs, &actionSubscription{
+		filter:   filter,
+		callback: callback,
+	})
+}
+
+// Name implements BlockHandler interface
+func (h *ActionHandler) Name() string {
+	return h.name
+}
+
+// OnBlockEvent implements BlockHandler interface
+func (h *ActionHandler) OnBlockEvent(ev *BlockEvent) {
+	if ev.Type != h.eventType {
+		return
+	}
+
+	// callbacks are called without lock, so they can subscribe
+	h.mutex.RLock()
+	subs := make([]*actionSubscription, len(h.subs))
+	copy(subs, h.subs)
+	h.mutex.RUnlock()
+
+	if len(subs) == 0 {
+		return
+	}
+
+	for trxIdx, receipt := range ev.Block.Transactions {
+		if receipt.Transaction.Packed == nil || receipt.Status != types.TransactionStatusExecuted {
+			continue
+		}
+
+		trx, err := types.DecodeTransaction(receipt.Transaction.Packed)
+		if err != nil {
+			h.logger.Debug("decode trx error", zap.Uint32("block", ev.BlockNum), zap.Error(err))
+			continue
+		}
+
+		trxID := receipt.Transaction.ID
+		for actIdx, act := range trx.Actions {
+			h.onAction(subs, &ActionContext{
+				Type:        ev.Type,
+				BlockNum:    ev.BlockNum,
+				BlockID:     ev.BlockID,
+				TrxID:       trxID,
+				TrxIndex:    uint32(trxIdx),
+				ActionIndex: uint32(actIdx),
+				Action:      act,
+			})
+		}
+	}
+}
+
+// onAction call the callbacks matched, the data is decoded once when first matched
+func (h *ActionHandler) onAction(subs []*actionSubscription, ctx *ActionContext) {
+	isDecoded := false
+	for _, sub := range subs {
+		if !sub.matchAction(ctx.Action) {
+			continue
+		}
+
+		if !isDecoded {
+			isDecoded = true
+			ctx.Data = h.decode(ctx)
+		}
+
+		if sub.matchData(ctx.Data) {
+			sub.callback(ctx)
+		}
+	}
+}
+
+func (h *ActionHandler) decode(ctx *ActionContext) map[string]interface{} {
+	if h.abis == nil {
+		return nil
+	}
+
+	if _, ok := h.abis.ABIAt(ctx.Action.Account, ctx.BlockNum, ctx.TrxIndex); !ok {
+		return nil
+	}
+
+	res, err := h.abis.DecodeActionToMap(ctx.Action, ctx.BlockNum, ctx.TrxIndex)
+	if err != nil {
+		h.logger.Debug("decode action error",
+			zap.Uint32("block", ctx.BlockNum), zap.String("trx", ctx.TrxID.String()), zap.Error(err))
+		return nil
+	}
+
+	return res
+}
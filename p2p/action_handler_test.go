@@ -0,0 +1,182 @@
+package p2p_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	eos "github.com/eoscanada/eos-go"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+const transferABIForTest = `{"version":"eosio::abi/1.1","structs":[{"name":"transfer","base":"","fields":[` +
+	`{"name":"from","type":"name"},{"name":"to","type":"name"},` +
+	`{"name":"quantity","type":"asset"},{"name":"memo","type":"string"}]}],` +
+	`"actions":[{"name":"transfer","type":"transfer","ricardian_contract":""}]}`
+
+func newTokenTransferForTest(t *testing.T, from, to string, amount int64) *types.Action {
+	data, err := eos.MarshalBinary(&struct {
+		From     types.AccountName
+		To       types.AccountName
+		Quantity eos.Asset
+		Memo     string
+	}{
+		From:     types.AccountName(from),
+		To:       types.AccountName(to),
+		Quantity: eos.Asset{Amount: eos.Int64(amount), Symbol: eos.Symbol{Precision: 4, Symbol: "EOS"}},
+	})
+	if err != nil {
+		t.Fatalf("encode transfer error %s", err.Error())
+	}
+
+	return &types.Action{
+		Account:       types.AccountName("eosio.token"),
+		Name:          types.ActionName("transfer"),
+		Authorization: []types.PermissionLevel{{Actor: types.AccountName(from), Permission: eos.PermissionName("active")}},
+		ActionData:    eos.NewActionDataFromHexData(data),
+	}
+}
+
+type actionsForTest struct {
+	mutex sync.Mutex
+	ctxs  []p2p.ActionContext
+}
+
+func (a *actionsForTest) add(ctx *p2p.ActionContext) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.ctxs = append(a.ctxs, *ctx)
+}
+
+func (a *actionsForTest) get() []p2p.ActionContext {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]p2p.ActionContext(nil), a.ctxs...)
+}
+
+// TestActionHandler test subscribe actions by account, name, actor and data fields, and subscribe in callbacks
+func TestActionHandler(t *testing.T) {
+	logger := zap.NewNop()
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForTest),
+		types.WithGenTransactions(func(blockNum uint32) [][]*types.Action {
+			switch blockNum {
+			case 3:
+				return [][]*types.Action{
+					newTransferForTest(1),
+					{newTokenTransferForTest(t, "alice", "bob", 10000), newTokenTransferForTest(t, "bob", "carol", 20000)},
+				}
+			case 5:
+				return [][]*types.Action{{newTokenTransferForTest(t, "carol", "bob", 30000)}}
+			}
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	blocks, err := gen.GenerateTo(10)
+	if err != nil {
+		t.Fatalf("generate error %s", err.Error())
+	}
+
+	chain, err := p2ptest.NewChain(blocks)
+	if err != nil {
+		t.Fatalf("new chain error %s", err.Error())
+	}
+
+	abis, err := p2p.NewABIRegistry(logger, nil)
+	if err != nil {
+		t.Fatalf("new registry error %s", err.Error())
+	}
+
+	abi, err := types.NewABIFromJSON(bytes.NewBufferString(transferABIForTest))
+	if err != nil {
+		t.Fatalf("new abi error %s", err.Error())
+	}
+	abis.SetABI(types.AccountName("eosio.token"), abi)
+
+	toBob, byBob, undone := &actionsForTest{}, &actionsForTest{}, &actionsForTest{}
+
+	handler := p2p.NewActionHandler(logger, "actions", p2p.BlockEventNew, abis)
+	handler.Subscribe(p2p.ActionFilter{
+		Account: types.AccountName("eosio.token"),
+		Name:    types.ActionName("transfer"),
+		Data:    map[string]string{"to": "bob"},
+	}, toBob.add)
+	handler.Subscribe(p2p.ActionFilter{Actor: types.AccountName("bob")}, byBob.add)
+
+	// subscribe in the callback, the new one gets the actions of blocks after
+	byCarol := &actionsForTest{}
+	var once sync.Once
+	handler.Subscribe(p2p.ActionFilter{Actor: types.AccountName("alice")}, func(ctx *p2p.ActionContext) {
+		once.Do(func() {
+			handler.Subscribe(p2p.ActionFilter{Actor: types.AccountName("carol")}, byCarol.add)
+		})
+	})
+
+	undoHandler := p2p.NewActionHandler(logger, "undoActions", p2p.BlockEventUndo, abis)
+	undoHandler.Subscribe(p2p.ActionFilter{}, undone.add)
+
+	srv := newServerForTest(t, chain)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(newStorerForTest(t, logger)),
+		p2p.WithABIRegistry(abis),
+		p2p.WithBlockHandler(handler),
+		p2p.WithBlockHandler(undoHandler))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return len(toBob.get()) >= 2
+	})
+
+	got := toBob.get()
+	if len(got) != 2 {
+		t.Fatalf("transfers to bob should be 2, got %d", len(got))
+	}
+
+	id3, _ := blocks[2].BlockID()
+	first := got[0]
+	if first.BlockNum != 3 || !types.IsChecksumEq(first.BlockID, id3) ||
+		first.TrxIndex != 1 || first.ActionIndex != 0 ||
+		!types.IsChecksumEq(first.TrxID, blocks[2].Transactions[1].Transaction.ID) {
+		t.Errorf("transfer context diff %d %d %d", first.BlockNum, first.TrxIndex, first.ActionIndex)
+	}
+
+	if first.Data["from"] != "alice" || first.Data["quantity"] != "1.0000 EOS" {
+		t.Errorf("transfer data diff %v", first.Data)
+	}
+
+	if got[1].BlockNum != 5 || got[1].Data["from"] != "carol" {
+		t.Errorf("transfer in block 5 diff %d %v", got[1].BlockNum, got[1].Data)
+	}
+
+	byBobGot := byBob.get()
+	if len(byBobGot) != 1 || byBobGot[0].BlockNum != 3 || byBobGot[0].ActionIndex != 1 {
+		t.Errorf("actions authorized by bob diff %v", byBobGot)
+	}
+
+	byCarolGot := byCarol.get()
+	if len(byCarolGot) != 1 || byCarolGot[0].BlockNum != 5 {
+		t.Errorf("actions authorized by carol diff %v", byCarolGot)
+	}
+
+	if len(undone.get()) != 0 {
+		t.Errorf("undo handler should not be called without fork")
+	}
+
+	cancel()
+	client.Wait()
+}
@@ -0,0 +1,140 @@
+package p2p
+
+import (
+	"sort"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// maxDposTracked max blocks of the longest chain tracked to estimate the lib, more than 2 rounds of 21 producers
+const maxDposTracked = 1024
+
+// dposBlock a block in the longest chain for dposLIB
+type dposBlock struct {
+	num      uint32
+	producer AccountName
+	version  uint32
+	// proposed the proposed lib after the block
+	proposed uint32
+}
+
+// dposLIB estimate the lib by the producers of blocks in the longest chain like nodeos:
+// a block is proposed irreversible when 2/3+1 producers produced on it, and it is irreversible
+// when 2/3+1 producers produced after a proposed lib on it, the producers in schedule are not
+// in blocks, so the number of them is counted after a round of producers seen, before that lib is 0
+type dposLIB struct {
+	blocks []dposBlock
+}
+
+// reset clear the blocks tracked
+func (d *dposLIB) reset() {
+	d.blocks = d.blocks[:0]
+}
+
+// push the new head block, the blocks tracked should be continuous
+func (d *dposLIB) push(blk *SignedBlock) {
+	num := blk.BlockNumber()
+	if len(d.blocks) > 0 && d.blocks[len(d.blocks)-1].num+1 != num {
+		d.reset()
+	}
+
+	if len(d.blocks) >= maxDposTracked {
+		d.blocks = append(d.blocks[:0], d.blocks[len(d.blocks)-maxDposTracked/2:]...)
+	}
+
+	d.blocks = append(d.blocks, dposBlock{
+		num:      num,
+		producer: blk.Producer,
+		version:  blk.ScheduleVersion,
+	})
+	d.blocks[len(d.blocks)-1].proposed = d.proposedLIB()
+}
+
+// pop the head block undone by fork switching
+func (d *dposLIB) pop(num uint32) {
+	if len(d.blocks) == 0 || d.blocks[len(d.blocks)-1].num != num {
+		d.reset()
+		return
+	}
+
+	d.blocks = d.blocks[:len(d.blocks)-1]
+}
+
+// producerNum the number of producers in active schedule, 0 if a round of them not seen
+func (d *dposLIB) producerNum() int {
+	if len(d.blocks) == 0 {
+		return 0
+	}
+
+	version := d.blocks[len(d.blocks)-1].version
+	seen := make(map[AccountName]bool, 32)
+	isRound := false
+	run := 0
+	for idx := len(d.blocks) - 1; idx >= 0 && d.blocks[idx].version == version; idx-- {
+		curr := d.blocks[idx].producer
+		if idx == len(d.blocks)-1 || d.blocks[idx+1].producer != curr {
+			// a new turn of producer, if seen before all producers in schedule had produced
+			isRound = isRound || seen[curr]
+			run = 0
+		}
+
+		seen[curr] = true
+		run++
+		if run > types.ProducerRepetitions {
+			// more blocks than a turn, the producer produced again
+			isRound = true
+		}
+	}
+
+	if !isRound {
+		return 0
+	}
+	return len(seen)
+}
+
+// proposedLIB the last block produced on by 2/3+1 producers
+func (d *dposLIB) proposedLIB() uint32 {
+	n := d.producerNum()
+	if n == 0 {
+		return 0
+	}
+
+	required := n*2/3 + 1
+	seen := make(map[AccountName]bool, required)
+	for idx := len(d.blocks) - 1; idx >= 0; idx-- {
+		seen[d.blocks[idx].producer] = true
+		if len(seen) >= required {
+			return d.blocks[idx].num
+		}
+	}
+
+	return 0
+}
+
+// lib the lib estimated, each producer implies the proposed lib before its last block irreversible,
+// the lib is implied by 2/3+1 producers, 0 if not known
+func (d *dposLIB) lib() uint32 {
+	n := d.producerNum()
+	if n == 0 {
+		return 0
+	}
+
+	implied := make(map[AccountName]uint32, n)
+	for idx := len(d.blocks) - 1; idx > 0 && len(implied) < n; idx-- {
+		producer := d.blocks[idx].producer
+		if _, ok := implied[producer]; !ok {
+			implied[producer] = d.blocks[idx-1].proposed
+		}
+	}
+
+	// the producers not seen imply nothing
+	nums := make([]uint32, n)
+	idx := 0
+	for _, num := range implied {
+		nums[idx] = num
+		idx++
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	return nums[(n-1)/3]
+}
@@ -0,0 +1,81 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+func newDposForTest(t *testing.T, headNum uint32, producers ...string) (*dposLIB, []*SignedBlock) {
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForStreamTest),
+		types.WithGenProducers(producers...))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	blocks, err := gen.GenerateTo(headNum)
+	if err != nil {
+		t.Fatalf("generate error %s", err.Error())
+	}
+
+	return &dposLIB{}, blocks
+}
+
+// TestDposLIB test the lib estimated by producers, 4 producers make 12 blocks each in turn
+func TestDposLIB(t *testing.T) {
+	dpos, blocks := newDposForTest(t, 100, "prod.a", "prod.b", "prod.c", "prod.d")
+
+	libs := make([]uint32, 0, len(blocks))
+	for _, blk := range blocks {
+		dpos.push(blk)
+		libs = append(libs, dpos.lib())
+	}
+
+	// no round of producers seen, unknown
+	if libs[47] != 0 {
+		t.Errorf("lib should be unknown before a round, got %d", libs[47])
+	}
+
+	// block 36 proposed by a, d, c at 49, then implied by a, b, c at 73
+	if libs[71] != 0 || libs[72] != 36 {
+		t.Errorf("lib should be 36 after block 73, got %d %d", libs[71], libs[72])
+	}
+
+	for idx := 1; idx < len(libs); idx++ {
+		if libs[idx] < libs[idx-1] || libs[idx] >= uint32(idx+1) {
+			t.Fatalf("lib %d at %d should not go back or reach head", libs[idx], idx+1)
+		}
+	}
+
+	// undo by fork
+	for num := uint32(100); num >= 73; num-- {
+		dpos.pop(num)
+	}
+	if lib := dpos.lib(); lib != 0 {
+		t.Errorf("lib should be 0 after undo, got %d", lib)
+	}
+
+	// not continuous, reset
+	dpos.push(blocks[90])
+	if lib := dpos.lib(); lib != 0 {
+		t.Errorf("lib should be 0 after reset, got %d", lib)
+	}
+}
+
+// TestDposLIBOneProducer test the lib of one producer is the block before head
+func TestDposLIBOneProducer(t *testing.T) {
+	dpos, blocks := newDposForTest(t, 20, "eosio")
+
+	for idx, blk := range blocks {
+		dpos.push(blk)
+
+		expected := uint32(0)
+		if idx >= 13 {
+			expected = uint32(idx)
+		}
+
+		if lib := dpos.lib(); lib != expected {
+			t.Errorf("lib at %d should be %d, got %d", idx+1, expected, lib)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package p2p
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/store"
+)
+
+// libFlushInterval min interval to flush storer state when lib advanced, the lib itself is persisted
+// by the storer before the irreversible events emitted
+const libFlushInterval = time.Second
+
+// IrreversibleHandler handler for the blocks become irreversible, called IN peerLoop by the order of block num,
+// each block is notified once even if client restarted by the same storer
+type IrreversibleHandler interface {
+	OnIrreversibleBlock(blockID Checksum256, blk *SignedBlock)
+}
+
+// irreversibleHandlerImp BlockHandler for IrreversibleHandler
+type irreversibleHandlerImp struct {
+	handler IrreversibleHandler
+	name    string
+}
+
+// NewIrreversibleHandler create a block handler by IrreversibleHandler
+func NewIrreversibleHandler(name string, handler IrreversibleHandler) BlockHandler {
+	return &irreversibleHandlerImp{
+		handler: handler,
+		name:    name,
+	}
+}
+
+// Name implements BlockHandler interface
+func (h *irreversibleHandlerImp) Name() string {
+	return h.name
+}
+
+// OnBlockEvent implements BlockHandler interface
+func (h *irreversibleHandlerImp) OnBlockEvent(ev *BlockEvent) {
+	if ev.Type == BlockEventIrreversible {
+		h.handler.OnIrreversibleBlock(ev.BlockID, ev.Block)
+	}
+}
+
+// loadReversible load the blocks after the lib of storer to stream, so they will be irreversible
+// after restarted, if some blocks not found the stream starts from the head
+func (s *BlockStream) loadReversible(storer store.BlockStorer) {
+	stat := storer.State()
+	libNum, headNum := stat.LastIrreversibleBlockNum, stat.HeadBlockNum
+	if libNum == 0 || libNum >= headNum {
+		return
+	}
+
+	loaded := make([]*streamBlock, 0, headNum-libNum)
+	for num := libNum + 1; num <= headNum; num++ {
+		blk, ok := storer.GetBlockByNum(num)
+		if !ok {
+			s.logger.Debug("reversible block not in storer, start from head",
+				zap.Uint32("lib", libNum), zap.Uint32("num", num))
+			return
+		}
+
+		id, err := blk.BlockID()
+		if err != nil {
+			s.logger.Warn("reversible block id error", zap.Uint32("num", num), zap.Error(err))
+			return
+		}
+
+		loaded = append(loaded, &streamBlock{
+			id:   id,
+			num:  num,
+			prev: string(blk.Previous),
+			blk:  blk,
+		})
+	}
+
+	// the lib id may be unknown if started from a checkpoint without id
+	s.rootID = loaded[0].prev
+	s.rootNum = libNum
+	for _, b := range loaded {
+		key := string(b.id)
+		s.markSeen(key)
+		s.blocks[key] = b
+		s.head = b
+		s.dpos.push(b.blk)
+	}
+
+	s.logger.Info("block stream load reversible blocks",
+		zap.Uint32("lib", libNum), zap.Uint32("head", headNum))
+}
+
+// resetStream (IN peerLoop) reset stream to start from the storer
+func (c *Client) resetStream() {
+	stat := c.blkStorer.State()
+	c.stream.reset(&stat)
+	c.stream.loadReversible(c.blkStorer)
+}
+
+// onStreamLIB (IN peerLoop) record the lib to storer before the irreversible events emitted,
+// so the blocks will not be irreversible again after restarted, flush storer at most once per libFlushInterval
+func (c *Client) onStreamLIB(blockNum uint32, blockID Checksum256) {
+	setter, ok := c.blkStorer.(store.IrreversibleSetter)
+	if !ok {
+		return
+	}
+
+	if err := setter.SetLastIrreversible(blockNum, blockID); err != nil {
+		c.logger.Warn("set lib to storer error", zap.Uint32("num", blockNum), zap.Error(err))
+		return
+	}
+
+	if time.Since(c.lastLIBFlush) < libFlushInterval {
+		return
+	}
+	c.lastLIBFlush = time.Now()
+
+	if f, ok := c.blkStorer.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			c.logger.Warn("flush storer error", zap.Error(err))
+		}
+	}
+}
+
+// LastIrreversibleBlockNum the lib recorded by storer
+func (c *Client) LastIrreversibleBlockNum() uint32 {
+	return c.blkStorer.State().LastIrreversibleBlockNum
+}
@@ -0,0 +1,309 @@
+package p2p_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+	"github.com/fanyang1988/eos-p2p/store"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+type irreversibleRecorder struct {
+	mutex sync.Mutex
+	nums  []uint32
+	isErr bool
+}
+
+func (r *irreversibleRecorder) OnIrreversibleBlock(blockID p2p.Checksum256, blk *p2p.SignedBlock) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	id, _ := blk.BlockID()
+	if !types.IsChecksumEq(id, blockID) {
+		r.isErr = true
+	}
+	r.nums = append(r.nums, blk.BlockNumber())
+}
+
+func (r *irreversibleRecorder) last() uint32 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.nums) == 0 {
+		return 0
+	}
+	return r.nums[len(r.nums)-1]
+}
+
+func (r *irreversibleRecorder) check(t *testing.T, from, to uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isErr {
+		t.Errorf("block id diff to the block")
+	}
+
+	if len(r.nums) != int(to-from+1) {
+		t.Fatalf("irreversible blocks should be [%d, %d], got %d blocks", from, to, len(r.nums))
+	}
+
+	for idx, num := range r.nums {
+		if num != from+uint32(idx) {
+			t.Fatalf("irreversible block %d should be %d, got %d", idx, from+uint32(idx), num)
+		}
+	}
+}
+
+func runIrreversibleForTest(t *testing.T, dbPath string, srv *p2ptest.Server, libNum uint32) *irreversibleRecorder {
+	logger := zap.NewNop()
+	storer, err := store.NewBBoltStorer(logger, chainIDForTest, dbPath, false)
+	if err != nil {
+		t.Fatalf("new storer error %s", err.Error())
+	}
+	defer storer.Close()
+
+	recorder := &irreversibleRecorder{}
+	client, err := p2p.NewClient(context.Background(), chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer),
+		p2p.WithBlockHandler(p2p.NewIrreversibleHandler("irreversible", recorder)))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return recorder.last() >= libNum
+	})
+
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error %s", err.Error())
+	}
+
+	if client.LastIrreversibleBlockNum() != libNum {
+		t.Errorf("lib in storer should be %d, got %d", libNum, client.LastIrreversibleBlockNum())
+	}
+
+	return recorder
+}
+
+// TestIrreversibleAfterRestart test each block is notified irreversible once when client restarted
+func TestIrreversibleAfterRestart(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "blocks.db")
+
+	chain := newChainForTest(t, 120)
+	srv := newServerForTest(t, chain, p2ptest.WithLIBLag(20))
+	runIrreversibleForTest(t, dbPath, srv, 100).check(t, 1, 100)
+
+	// the blocks in (100, 120] were reversible when stopped
+	longer, err := chain.Extend(150)
+	if err != nil {
+		t.Fatalf("extend chain error %s", err.Error())
+	}
+
+	srv = newServerForTest(t, longer, p2ptest.WithLIBLag(20))
+	runIrreversibleForTest(t, dbPath, srv, 130).check(t, 101, 130)
+}
+
+// noFlushStorer storer never flush state, as the process crashed before flushed
+type noFlushStorer struct {
+	*store.BBoltStorer
+}
+
+func (s noFlushStorer) Flush() error {
+	return nil
+}
+
+// TestIrreversibleAfterCrash test the blocks notified irreversible are not notified again
+// when the process crashed before the state flushed
+func TestIrreversibleAfterCrash(t *testing.T) {
+	logger := zap.NewNop()
+	dir := t.TempDir()
+	dbPath, crashedPath := filepath.Join(dir, "blocks.db"), filepath.Join(dir, "crashed.db")
+
+	chain := newChainForTest(t, 120)
+	storer, err := store.NewBBoltStorer(logger, chainIDForTest, dbPath, false)
+	if err != nil {
+		t.Fatalf("new storer error %s", err.Error())
+	}
+	defer storer.Close()
+
+	recorder := &irreversibleRecorder{}
+	client, err := p2p.NewClient(context.Background(), chainIDForTest,
+		[]*p2p.PeerCfg{{Address: newServerForTest(t, chain, p2ptest.WithLIBLag(20)).Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(noFlushStorer{storer}),
+		p2p.WithBlockHandler(p2p.NewIrreversibleHandler("irreversible", recorder)))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return recorder.last() >= 100
+	})
+	recorder.check(t, 1, 100)
+
+	// the db file when crashed, the state is not flushed after irreversible notified
+	data, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("read db error %s", err.Error())
+	}
+
+	if err := ioutil.WriteFile(crashedPath, data, 0600); err != nil {
+		t.Fatalf("write db error %s", err.Error())
+	}
+
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error %s", err.Error())
+	}
+
+	longer, err := chain.Extend(150)
+	if err != nil {
+		t.Fatalf("extend chain error %s", err.Error())
+	}
+
+	srv := newServerForTest(t, longer, p2ptest.WithLIBLag(20))
+	runIrreversibleForTest(t, crashedPath, srv, 130).check(t, 101, 130)
+}
+
+// TestLIBFromSyncPeerOnly test the lib from a peer not syncing from is not trusted
+func TestLIBFromSyncPeerOnly(t *testing.T) {
+	logger := zap.NewNop()
+
+	chain := newChainForTest(t, 120)
+	srv := newServerForTest(t, chain, p2ptest.WithLIBLag(20))
+
+	recorder := &irreversibleRecorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(newStorerForTest(t, logger)),
+		p2p.WithBlockHandler(p2p.NewIrreversibleHandler("irreversible", recorder)))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.SyncStatus().Phase == "live" && recorder.last() >= 100
+	})
+
+	// a peer advertises all blocks irreversible
+	bogus := newServerForTest(t, chain)
+	if err := client.NewPeer(&p2p.PeerCfg{Address: bogus.Addr()}); err != nil {
+		t.Fatalf("new peer error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		for _, st := range client.PeerStats() {
+			if st.Address == bogus.Addr() && st.LastIrreversibleBlockNum == 120 {
+				return true
+			}
+		}
+		return false
+	})
+	time.Sleep(200 * time.Millisecond)
+
+	if last := recorder.last(); last != 100 {
+		t.Fatalf("lib should not be advanced by other peer, got %d", last)
+	}
+
+	// the sync peer handshake again with new lib
+	longer, err := chain.Extend(130)
+	if err != nil {
+		t.Fatalf("extend chain error %s", err.Error())
+	}
+
+	srv.SetChain(longer)
+	if err := srv.Broadcast(srv.Handshake()); err != nil {
+		t.Fatalf("broadcast handshake error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return recorder.last() >= 110
+	})
+	recorder.check(t, 1, 110)
+
+	cancel()
+	client.Wait()
+}
+
+// TestIrreversibleInLive test the irreversible events keep advancing when following new blocks,
+// by the lib in notices of sync peer and the lib estimated by the producers of blocks
+func TestIrreversibleInLive(t *testing.T) {
+	logger := zap.NewNop()
+
+	chain := newChainForTest(t, 120)
+	srv := newServerForTest(t, chain, p2ptest.WithLIBLag(20))
+
+	recorder := &irreversibleRecorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(newStorerForTest(t, logger)),
+		p2p.WithBlockHandler(p2p.NewIrreversibleHandler("irreversible", recorder)))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.SyncStatus().Phase == "live" && recorder.last() >= 100
+	})
+
+	following := func(headNum uint32) {
+		longer, err := srv.Chain().Extend(headNum)
+		if err != nil {
+			t.Fatalf("extend chain error %s", err.Error())
+		}
+
+		for num := srv.Chain().HeadBlockNum() + 1; num <= headNum; num++ {
+			blk, _ := longer.GetBlockByNum(num)
+			if err := srv.Broadcast(blk); err != nil {
+				t.Fatalf("broadcast block error %s", err.Error())
+			}
+		}
+		srv.SetChain(longer)
+
+		waitFor(t, 5*time.Second, func() bool {
+			return client.HeadBlockNum() == headNum
+		})
+	}
+
+	// the lib of sync peer in notice
+	following(130)
+	if err := srv.Broadcast(types.NewLastIrrCatchupNotice(115, 130)); err != nil {
+		t.Fatalf("broadcast notice error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return recorder.last() >= 115
+	})
+	recorder.check(t, 1, 115)
+
+	// no notice, the blocks produced by more than 2/3 producers become irreversible
+	following(800)
+	waitFor(t, 5*time.Second, func() bool {
+		return recorder.last() > 400
+	})
+
+	if last := recorder.last(); last >= 800-21*12*2/3 {
+		t.Errorf("lib %d should be behind head by 2/3 producers", last)
+	}
+	recorder.check(t, 1, recorder.last())
+
+	cancel()
+	client.Wait()
+}
@@ -0,0 +1,499 @@
+package p2p
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/store"
+)
+
+const (
+	// maxStreamOrphans max blocks can not link to the stream waiting for the previous block
+	maxStreamOrphans = 1024
+	// maxStreamSeen the ids of blocks passed to remember for dedup
+	maxStreamSeen = 4096
+	// maxReversibleBlocks blocks behind head more than it are seen as irreversible even if lib not known
+	maxReversibleBlocks = 3600
+)
+
+// BlockEventType type of block event
+type BlockEventType uint8
+
+const (
+	// BlockEventNew a block is appended to the head of chain
+	BlockEventNew = BlockEventType(iota)
+	// BlockEventUndo the head block is removed by switching to a fork
+	BlockEventUndo
+	// BlockEventIrreversible a block in chain become irreversible
+	BlockEventIrreversible
+)
+
+var blockEventTypeNames = map[BlockEventType]string{
+	BlockEventNew:          "new",
+	BlockEventUndo:         "undo",
+	BlockEventIrreversible: "irreversible",
+}
+
+func (t BlockEventType) String() string {
+	if name, ok := blockEventTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(t))
+}
+
+// BlockEvent a event from BlockStream
+type BlockEvent struct {
+	Type     BlockEventType
+	BlockNum uint32
+	BlockID  Checksum256
+	Block    *SignedBlock
+	// Peer the peer sent the block first
+	Peer *Peer
+}
+
+// BlockHandler handler for block events, called IN peerLoop by the order of chain
+type BlockHandler interface {
+	Name() string
+	OnBlockEvent(ev *BlockEvent)
+}
+
+// blockHandlerFunc a func for BlockHandler with a name
+type blockHandlerFunc struct {
+	f    func(ev *BlockEvent)
+	name string
+}
+
+// NewBlockHandlerFunc create a block handler by func with name
+func NewBlockHandlerFunc(name string, f func(ev *BlockEvent)) BlockHandler {
+	return &blockHandlerFunc{
+		f:    f,
+		name: name,
+	}
+}
+
+// Name implements BlockHandler interface
+func (h *blockHandlerFunc) Name() string {
+	return h.name
+}
+
+// OnBlockEvent implements BlockHandler interface
+func (h *blockHandlerFunc) OnBlockEvent(ev *BlockEvent) {
+	h.f(ev)
+}
+
+// streamBlock a block in the fork tree of stream
+type streamBlock struct {
+	id   Checksum256
+	num  uint32
+	prev string
+	blk  *SignedBlock
+	peer *Peer
+}
+
+// BlockStream dedups blocks from all peers, links them to a fork tree, emits events by the longest chain,
+// all funcs are called IN peerLoop
+type BlockStream struct {
+	logger   *zap.Logger
+	handlers []BlockHandler
+	// onLIB called before the irreversible events emitted when lib advanced, to persist the lib first
+	onLIB func(blockNum uint32, blockID Checksum256)
+	// onNew called before the new event emitted for a block
+	onNew func(blk *SignedBlock)
+	// onUndo called after the undo event emitted for a block
+	onUndo func(blk *SignedBlock)
+
+	// rootID rootNum the last irreversible block, all blocks in tree are descendant of it
+	rootID  string
+	rootNum uint32
+	libNum  uint32
+	// irrNum the last block emitted irreversible, the blocks <= it are not emitted again, such as after restarted
+	irrNum uint32
+
+	blocks  map[string]*streamBlock
+	orphans map[string][]*streamBlock
+	orphanN int
+	head    *streamBlock
+
+	seen     map[string]struct{}
+	seenRing []string
+	seenIdx  int
+
+	// dpos the lib estimated by the producers of the longest chain
+	dpos dposLIB
+}
+
+func newBlockStream(logger *zap.Logger) *BlockStream {
+	return &BlockStream{
+		logger:   logger,
+		blocks:   make(map[string]*streamBlock, 1024),
+		orphans:  make(map[string][]*streamBlock, 64),
+		seen:     make(map[string]struct{}, maxStreamSeen),
+		seenRing: make([]string, maxStreamSeen),
+	}
+}
+
+// reset reset the stream to start from the head of storer state
+func (s *BlockStream) reset(stat *store.BlockDBState) {
+	s.rootID = string(stat.HeadBlockID)
+	s.rootNum = stat.HeadBlockNum
+	if s.libNum < stat.LastIrreversibleBlockNum {
+		s.libNum = stat.LastIrreversibleBlockNum
+	}
+	if s.irrNum < stat.LastIrreversibleBlockNum {
+		s.irrNum = stat.LastIrreversibleBlockNum
+	}
+
+	s.blocks = make(map[string]*streamBlock, 1024)
+	s.orphans = make(map[string][]*streamBlock, 64)
+	s.orphanN = 0
+	s.head = nil
+	s.dpos.reset()
+}
+
+// headNum the num of head in stream
+func (s *BlockStream) headNum() uint32 {
+	if s.head == nil {
+		return s.rootNum
+	}
+	return s.head.num
+}
+
+// addHandler add or replace handler by name
+func (s *BlockStream) addHandler(h BlockHandler) {
+	for idx, hh := range s.handlers {
+		if hh.Name() == h.Name() {
+			s.handlers[idx] = h
+			return
+		}
+	}
+	s.handlers = append(s.handlers, h)
+}
+
+// findHandler find handler by name, nil if not found
+func (s *BlockStream) findHandler(name string) BlockHandler {
+	for _, h := range s.handlers {
+		if h.Name() == name {
+			return h
+		}
+	}
+	return nil
+}
+
+func (s *BlockStream) emit(typ BlockEventType, b *streamBlock) {
+	switch typ {
+	case BlockEventNew:
+		s.dpos.push(b.blk)
+		if s.onNew != nil {
+			s.onNew(b.blk)
+		}
+	case BlockEventUndo:
+		s.dpos.pop(b.num)
+	}
+
+	ev := &BlockEvent{
+		Type:     typ,
+		BlockNum: b.num,
+		BlockID:  b.id,
+		Block:    b.blk,
+		Peer:     b.peer,
+	}
+
+	for _, h := range s.handlers {
+		h.OnBlockEvent(ev)
+	}
+}
+
+// markSeen mark the block id seen, return false if it had been seen
+func (s *BlockStream) markSeen(key string) bool {
+	if _, ok := s.seen[key]; ok {
+		return false
+	}
+
+	if old := s.seenRing[s.seenIdx]; old != "" {
+		delete(s.seen, old)
+	}
+	s.seenRing[s.seenIdx] = key
+	s.seenIdx = (s.seenIdx + 1) % len(s.seenRing)
+	s.seen[key] = struct{}{}
+
+	return true
+}
+
+// onBlock process a block from peer, return false if the block is a duplicate
+func (s *BlockStream) onBlock(peer *Peer, blk *SignedBlock) bool {
+	id, err := blk.BlockID()
+	if err != nil {
+		s.logger.Warn("block id error", zap.Error(err))
+		return false
+	}
+
+	key := string(id)
+	if _, ok := s.seen[key]; ok {
+		return false
+	}
+
+	b := &streamBlock{
+		id:   id,
+		num:  blk.BlockNumber(),
+		prev: string(blk.Previous),
+		blk:  blk,
+		peer: peer,
+	}
+
+	isFirst := s.rootID == "" && len(s.blocks) == 0
+	if b.num < s.rootNum || (b.num == s.rootNum && !isFirst) {
+		// irreversible had passed
+		s.markSeen(key)
+		return true
+	}
+
+	if !s.isLinkable(b) {
+		// the block dropped is not marked seen, so it can be received again
+		if s.addOrphan(b) {
+			s.markSeen(key)
+		}
+		return true
+	}
+
+	s.markSeen(key)
+	s.link(b)
+	s.advanceLIB()
+
+	return true
+}
+
+// isLinkable can the block link to the tree
+func (s *BlockStream) isLinkable(b *streamBlock) bool {
+	if b.prev == s.rootID {
+		return true
+	}
+
+	if _, ok := s.blocks[b.prev]; ok {
+		return true
+	}
+
+	// no root id known, such as started from a block num without id
+	return s.rootID == "" && len(s.blocks) == 0 && b.num >= s.rootNum && b.num <= s.rootNum+1
+}
+
+// isLinked is the block linked in the tree of stream
+func (s *BlockStream) isLinked(id Checksum256) bool {
+	_, ok := s.blocks[string(id)]
+	return ok
+}
+
+// addOrphan add the block waiting for its previous, return false if dropped by too many orphans
+func (s *BlockStream) addOrphan(b *streamBlock) bool {
+	if s.orphanN >= maxStreamOrphans {
+		s.logger.Debug("too many orphan blocks, drop", zap.Uint32("num", b.num))
+		return false
+	}
+
+	s.orphans[b.prev] = append(s.orphans[b.prev], b)
+	s.orphanN++
+	return true
+}
+
+// link add the block and the orphans waiting for it to tree
+func (s *BlockStream) link(b *streamBlock) {
+	waiting := []*streamBlock{b}
+	for len(waiting) > 0 {
+		curr := waiting[0]
+		waiting = waiting[1:]
+
+		if s.rootID == "" && len(s.blocks) == 0 {
+			// the first block started from, as the child of root
+			s.rootID = curr.prev
+			s.rootNum = curr.num - 1
+		}
+
+		key := string(curr.id)
+		s.blocks[key] = curr
+		s.updateHead(curr)
+
+		if children, ok := s.orphans[key]; ok {
+			delete(s.orphans, key)
+			s.orphanN -= len(children)
+			waiting = append(waiting, children...)
+		}
+	}
+}
+
+// updateHead switch head to the block if it is in a longer chain
+func (s *BlockStream) updateHead(b *streamBlock) {
+	if s.head != nil && b.num <= s.head.num {
+		return
+	}
+
+	parent := s.rootID
+	if s.head != nil {
+		parent = string(s.head.id)
+	}
+
+	if b.prev == parent {
+		s.head = b
+		s.emit(BlockEventNew, b)
+		return
+	}
+
+	s.switchTo(b)
+}
+
+// branch the blocks from the block to root, the first is the block
+func (s *BlockStream) branch(b *streamBlock) []*streamBlock {
+	res := make([]*streamBlock, 0, 8)
+	for curr := b; curr != nil; curr = s.blocks[curr.prev] {
+		res = append(res, curr)
+	}
+	return res
+}
+
+// switchTo undo the blocks of head not in the branch of b, then new the blocks of b
+func (s *BlockStream) switchTo(b *streamBlock) {
+	newBranch := s.branch(b)
+	inNew := make(map[string]bool, len(newBranch))
+	for _, nb := range newBranch {
+		inNew[string(nb.id)] = true
+	}
+
+	// undo from head to the fork point
+	var forkKey string
+	if s.head != nil {
+		for _, ob := range s.branch(s.head) {
+			if inNew[string(ob.id)] {
+				forkKey = string(ob.id)
+				break
+			}
+			s.emit(BlockEventUndo, ob)
+			if s.onUndo != nil {
+				s.onUndo(ob.blk)
+			}
+		}
+	}
+
+	s.logger.Info("block stream switch fork",
+		zap.Uint32("from", s.headNum()), zap.Uint32("to", b.num))
+
+	// new from the fork point to b
+	for i := len(newBranch) - 1; i >= 0; i-- {
+		nb := newBranch[i]
+		if forkKey != "" && string(nb.id) == forkKey {
+			forkKey = ""
+			continue
+		}
+		if forkKey != "" {
+			// blocks before fork point had been emitted
+			continue
+		}
+		s.emit(BlockEventNew, nb)
+	}
+
+	s.head = b
+}
+
+// setLIB set the lib known, the blocks in chain <= lib will become irreversible
+func (s *BlockStream) setLIB(libNum uint32) {
+	if libNum <= s.libNum {
+		return
+	}
+
+	s.libNum = libNum
+	s.advanceLIB()
+}
+
+// advanceLIB emit irreversible for the blocks in chain <= lib, and prune the tree
+func (s *BlockStream) advanceLIB() {
+	if s.head == nil {
+		return
+	}
+
+	libNum := s.libNum
+	if dposNum := s.dpos.lib(); dposNum > libNum {
+		libNum = dposNum
+	}
+	if s.head.num > maxReversibleBlocks && s.head.num-maxReversibleBlocks > libNum {
+		libNum = s.head.num - maxReversibleBlocks
+	}
+
+	if libNum <= s.rootNum {
+		return
+	}
+
+	branch := s.branch(s.head)
+	irreversible := make([]*streamBlock, 0, len(branch))
+	for i := len(branch) - 1; i >= 0 && branch[i].num <= libNum; i-- {
+		irreversible = append(irreversible, branch[i])
+	}
+
+	if len(irreversible) == 0 {
+		return
+	}
+	newRoot := irreversible[len(irreversible)-1]
+
+	if s.onLIB != nil {
+		s.onLIB(newRoot.num, newRoot.id)
+	}
+
+	for _, b := range irreversible {
+		if b.num <= s.irrNum {
+			continue
+		}
+
+		s.emit(BlockEventIrreversible, b)
+		s.irrNum = b.num
+	}
+
+	s.rootID = string(newRoot.id)
+	s.rootNum = newRoot.num
+	if newRoot == s.head {
+		s.head = nil
+	}
+
+	s.prune()
+}
+
+// prune remove blocks not descendant of root
+func (s *BlockStream) prune() {
+	isKept := make(map[string]bool, len(s.blocks))
+	var check func(b *streamBlock) bool
+	check = func(b *streamBlock) bool {
+		key := string(b.id)
+		if kept, ok := isKept[key]; ok {
+			return kept
+		}
+
+		var kept bool
+		switch {
+		case b.num <= s.rootNum:
+			kept = false
+		case b.prev == s.rootID:
+			kept = true
+		default:
+			prev, ok := s.blocks[b.prev]
+			kept = ok && check(prev)
+		}
+
+		isKept[key] = kept
+		return kept
+	}
+
+	for key, b := range s.blocks {
+		if !check(b) {
+			delete(s.blocks, key)
+		}
+	}
+
+	for prev, children := range s.orphans {
+		if len(children) > 0 && children[0].num <= s.rootNum {
+			delete(s.orphans, prev)
+			s.orphanN -= len(children)
+		}
+	}
+}
+
+// isBlockMsg is the packet a block
+func isBlockMsg(packet *Packet) (*SignedBlock, bool) {
+	blk, ok := packet.P2PMessage.(*SignedBlock)
+	return blk, ok && blk != nil
+}
@@ -0,0 +1,131 @@
+package p2p
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+	"github.com/fanyang1988/eos-p2p/store"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+const chainIDForStreamTest = "76eab2b704733e933d0e4eb6cc24d260d9fbbe5d93d760392e97398f4e301448"
+
+// newStreamForTest a stream started from empty storer, events are recorded as "type:num" with a mark for fork
+func newStreamForTest(t *testing.T, fork *p2ptest.Chain, forkNum uint32) (*BlockStream, *[]string) {
+	stream := newBlockStream(zap.NewNop())
+	stat := store.NewBlockDBState(types.MustNewChecksum256(chainIDForStreamTest))
+	stream.reset(stat)
+
+	events := make([]string, 0, 64)
+	stream.addHandler(NewBlockHandlerFunc("recorder", func(ev *BlockEvent) {
+		mark := ""
+		if fork != nil {
+			if _, ok := fork.GetBlockByID(ev.BlockID); ok && ev.BlockNum >= forkNum {
+				mark = "'"
+			}
+		}
+		events = append(events, fmt.Sprintf("%s:%d%s", ev.Type, ev.BlockNum, mark))
+	}))
+
+	return stream, &events
+}
+
+func takeEvents(events *[]string) string {
+	res := strings.Join(*events, " ")
+	*events = (*events)[:0]
+	return res
+}
+
+// TestBlockStreamFork test blocks are deduplicated and events for fork switch and lib
+func TestBlockStreamFork(t *testing.T) {
+	chain, err := p2ptest.GenerateChain(types.MustNewChecksum256(chainIDForStreamTest), 10)
+	if err != nil {
+		t.Fatalf("generate chain error %s", err.Error())
+	}
+
+	fork, err := chain.Fork(8, 13)
+	if err != nil {
+		t.Fatalf("fork chain error %s", err.Error())
+	}
+
+	stream, events := newStreamForTest(t, fork, 8)
+	feed := func(c *p2ptest.Chain, nums ...uint32) {
+		for _, num := range nums {
+			blk, _ := c.GetBlockByNum(num)
+			stream.onBlock(nil, blk)
+		}
+	}
+
+	feed(chain, 1, 2, 3, 2, 4, 5, 6, 7, 8, 9, 10, 10)
+	if got := takeEvents(events); got != "new:1 new:2 new:3 new:4 new:5 new:6 new:7 new:8 new:9 new:10" {
+		t.Errorf("events error: %s", got)
+	}
+
+	// no switch for a shorter fork
+	feed(fork, 8, 9, 10)
+	if got := takeEvents(events); got != "" {
+		t.Errorf("should no events for shorter fork: %s", got)
+	}
+
+	feed(fork, 11)
+	if got := takeEvents(events); got != "undo:10 undo:9 undo:8 new:8' new:9' new:10' new:11'" {
+		t.Errorf("switch fork events error: %s", got)
+	}
+
+	// orphan linked when previous arrived
+	feed(fork, 13)
+	if got := takeEvents(events); got != "" {
+		t.Errorf("should no events for orphan: %s", got)
+	}
+
+	feed(fork, 12)
+	if got := takeEvents(events); got != "new:12' new:13'" {
+		t.Errorf("link orphan events error: %s", got)
+	}
+
+	stream.setLIB(9)
+	if got := takeEvents(events); got != "irreversible:1 irreversible:2 irreversible:3 irreversible:4 "+
+		"irreversible:5 irreversible:6 irreversible:7 irreversible:8' irreversible:9'" {
+		t.Errorf("irreversible events error: %s", got)
+	}
+
+	// the blocks before lib are dropped
+	if len(stream.blocks) != 4 {
+		t.Errorf("should only 4 blocks after lib in stream, got %d", len(stream.blocks))
+	}
+
+	feed(chain, 10)
+	if got := takeEvents(events); got != "" {
+		t.Errorf("should no events for block on pruned fork: %s", got)
+	}
+}
+
+// TestBlockStreamOrphanDropped test the orphan dropped by limit can be received again
+func TestBlockStreamOrphanDropped(t *testing.T) {
+	chain, err := p2ptest.GenerateChain(types.MustNewChecksum256(chainIDForStreamTest), 5)
+	if err != nil {
+		t.Fatalf("generate chain error %s", err.Error())
+	}
+
+	stream, events := newStreamForTest(t, nil, 0)
+	feed := func(nums ...uint32) {
+		for _, num := range nums {
+			blk, _ := chain.GetBlockByNum(num)
+			stream.onBlock(nil, blk)
+		}
+	}
+
+	feed(1)
+	stream.orphanN = maxStreamOrphans
+	feed(3)
+	stream.orphanN = 0
+
+	feed(2, 3)
+	if got := takeEvents(events); got != "new:1 new:2 new:3" {
+		t.Errorf("events error: %s", got)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"encoding/hex"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
 	"github.com/fanyang1988/eos-p2p/store"
+	"github.com/fanyang1988/eos-p2p/types"
 )
 
 type peerStatusTyp uint8
@@ -18,12 +20,17 @@ const (
 	peerStatInit
 	peerStatError
 	peerStatClosed
+	// peerStatWaiting peer not reconnected until its ban expired and score recovered
+	peerStatWaiting
 )
 
 type peerStatus struct {
-	peer   *Peer
-	cfg    *PeerCfg
-	status peerStatusTyp
+	peer         *Peer
+	cfg          *PeerCfg
+	status       peerStatusTyp
+	isDiscovered bool
+	// isDialing the peer is dialing in a goroutine
+	isDialing bool
 }
 
 // Client a p2p Client for eos chain
@@ -35,6 +42,7 @@ type Client struct {
 	syncHandler     Handler
 	sync            *syncManager
 	currentSyncPeer *Peer // will changed by peerMng loop
+	syncPeerSelect  *syncPeerSelector
 	needSync        bool
 
 	chainID Checksum256
@@ -44,24 +52,55 @@ type Client struct {
 
 	blkStorer store.BlockStorer
 
+	stream       *BlockStream
+	lastLIBFlush time.Time // time flushed storer for lib (IN peerLoop)
+	trxWaits     *trxWaits
+	abis         *ABIRegistry
+
+	discovery *discovery
+	scorer    *peerScorer
+	heartbeat HeartbeatCfg
+	dialer    Dialer
+
 	logger *zap.Logger
 
-	wg sync.WaitGroup
+	cancel       context.CancelFunc
+	mngDone      chan struct{}
+	loopDone     chan struct{}
+	loopStop     chan struct{} // closed to stop peerLoop at once when shutdown timeout
+	shutdownChan chan struct{}
+	shutdownDone chan struct{}
+	shutdownOnce sync.Once
+	shutdownErr  error
+
+	// dials the peers dialing by peerMngLoop
+	dials sync.WaitGroup
+	wg    sync.WaitGroup
 }
 
 // Options options for new client
 type Options struct {
 	needSync      bool
 	startBlockNum uint32
+	checkpoint    *store.Checkpoint
 	handlers      []Handler
+	blkHandlers   []BlockHandler
+	abis          *ABIRegistry
 	blkStorer     store.BlockStorer
+	discovery     *DiscoveryCfg
+	scoreCfg      ScoreCfg
+	heartbeat     HeartbeatCfg
+	dialer        Dialer
+	stallTimeout  time.Duration
+	syncPeerCfg   SyncPeerCfg
 	logger        *zap.Logger
 }
 
 // OptionFunc func for new client
 type OptionFunc func(*Options) error
 
-// WithNeedSync set client with needSync
+// WithNeedSync set client with needSync, if startBlockNum > 1 and storer is behind it,
+// client will start sync from startBlockNum without checking the blocks before
 func WithNeedSync(startBlockNum uint32) OptionFunc {
 	return func(o *Options) error {
 		o.needSync = true
@@ -70,6 +109,34 @@ func WithNeedSync(startBlockNum uint32) OptionFunc {
 	}
 }
 
+// WithSyncStallTimeout set the window to check sync progress, if no progress in it, client will
+// request blocks from another peer
+func WithSyncStallTimeout(timeout time.Duration) OptionFunc {
+	return func(o *Options) error {
+		o.stallTimeout = timeout
+		return nil
+	}
+}
+
+// WithSyncPeerCfg set config for selecting the peer to sync
+func WithSyncPeerCfg(cfg SyncPeerCfg) OptionFunc {
+	return func(o *Options) error {
+		o.syncPeerCfg = cfg
+		return nil
+	}
+}
+
+// WithCheckpoint set a trusted checkpoint, client will sync from it if storer is behind it
+func WithCheckpoint(cp store.Checkpoint) OptionFunc {
+	return func(o *Options) error {
+		if err := cp.Validate(); err != nil {
+			return errors.Wrap(err, "invalid checkpoint")
+		}
+		o.checkpoint = &cp
+		return nil
+	}
+}
+
 // WithHandler set client with a handler
 func WithHandler(h Handler) OptionFunc {
 	return func(o *Options) error {
@@ -78,6 +145,22 @@ func WithHandler(h Handler) OptionFunc {
 	}
 }
 
+// WithBlockHandler set client with a handler for block events, which are deduplicated and ordered by chain
+func WithBlockHandler(h BlockHandler) OptionFunc {
+	return func(o *Options) error {
+		o.blkHandlers = append(o.blkHandlers, h)
+		return nil
+	}
+}
+
+// WithABIRegistry set client learn abis from blocks to the registry, the abis are learned before block handlers
+func WithABIRegistry(r *ABIRegistry) OptionFunc {
+	return func(o *Options) error {
+		o.abis = r
+		return nil
+	}
+}
+
 // WithStorer set storer for blocks and state
 func WithStorer(blk store.BlockStorer) OptionFunc {
 	return func(o *Options) error {
@@ -86,6 +169,44 @@ func WithStorer(blk store.BlockStorer) OptionFunc {
 	}
 }
 
+// WithDiscovery enable discovery peers from handshakes and seeds
+func WithDiscovery(cfg DiscoveryCfg) OptionFunc {
+	return func(o *Options) error {
+		o.discovery = &cfg
+		return nil
+	}
+}
+
+// WithScoreCfg set config for peer scoring and bans
+func WithScoreCfg(cfg ScoreCfg) OptionFunc {
+	return func(o *Options) error {
+		o.scoreCfg = cfg
+		return nil
+	}
+}
+
+// WithHeartbeat set interval to send TimeMessage and the timeout to close idle peers,
+// the fields which are 0 keep the defaults
+func WithHeartbeat(cfg HeartbeatCfg) OptionFunc {
+	return func(o *Options) error {
+		if cfg.Interval != 0 {
+			o.heartbeat.Interval = cfg.Interval
+		}
+		if cfg.IdleTimeout != 0 {
+			o.heartbeat.IdleTimeout = cfg.IdleTimeout
+		}
+		return nil
+	}
+}
+
+// WithDialer set the dialer for all peers which not set dialer in PeerCfg
+func WithDialer(dialer Dialer) OptionFunc {
+	return func(o *Options) error {
+		o.dialer = dialer
+		return nil
+	}
+}
+
 // WithLogger set logger for log
 func WithLogger(l *zap.Logger) OptionFunc {
 	return func(o *Options) error {
@@ -102,6 +223,13 @@ func NewClient(ctx context.Context, chainID string, peers []*PeerCfg, opts ...Op
 
 	defaultOpts := Options{
 		handlers: make([]Handler, 0, 8),
+		scoreCfg: DefaultScoreCfg(),
+		heartbeat: HeartbeatCfg{
+			Interval:    defaultHeartbeatInterval,
+			IdleTimeout: defaultIdleTimeout,
+		},
+		stallTimeout: defaultSyncStallTimeout,
+		syncPeerCfg:  DefaultSyncPeerCfg(),
 	}
 
 	for _, o := range opts {
@@ -125,19 +253,63 @@ func NewClient(ctx context.Context, chainID string, peers []*PeerCfg, opts ...Op
 		needSync:   defaultOpts.needSync,
 		blkStorer:  defaultOpts.blkStorer,
 		logger:     defaultOpts.logger,
+		scorer:     newPeerScorer(defaultOpts.scoreCfg),
+		heartbeat:  defaultOpts.heartbeat,
+		dialer:     defaultOpts.dialer,
+		mngDone:    make(chan struct{}),
+		syncPeerSelect: &syncPeerSelector{
+			cfg: defaultOpts.syncPeerCfg,
+		},
+
+		loopDone:     make(chan struct{}),
+		loopStop:     make(chan struct{}),
+		shutdownChan: make(chan struct{}),
+		shutdownDone: make(chan struct{}),
+	}
+
+	if err := client.seedCheckpoint(&defaultOpts); err != nil {
+		return nil, errors.Wrapf(err, "seed checkpoint error")
 	}
 
 	// create sync manager
 	client.sync = &syncManager{
-		cli: client,
+		cli:      client,
+		watchdog: newSyncWatchdog(defaultOpts.stallTimeout),
+		progress: newSyncProgress(),
 	}
 	client.sync.init(defaultOpts.needSync)
 
+	if defaultOpts.discovery != nil {
+		client.discovery, err = newDiscovery(client, *defaultOpts.discovery)
+		if err != nil {
+			return nil, errors.Wrapf(err, "new discovery error")
+		}
+	}
+
 	// init handlers
 	for _, h := range defaultOpts.handlers {
 		client.handlers = append(client.handlers, h)
 	}
 
+	if err := client.checkHandlers(); err != nil {
+		return nil, errors.Wrapf(err, "check handlers error")
+	}
+
+	client.stream = newBlockStream(client.logger)
+	client.stream.onLIB = client.onStreamLIB
+	client.stream.onNew = client.onStreamNew
+	client.stream.onUndo = client.onStreamUndo
+	client.trxWaits = newTrxWaits(client)
+	client.stream.addHandler(client.trxWaits)
+	if defaultOpts.abis != nil {
+		client.abis = defaultOpts.abis
+		client.stream.addHandler(client.abis)
+	}
+	client.resetStream()
+	for _, h := range defaultOpts.blkHandlers {
+		client.stream.addHandler(h)
+	}
+
 	err = client.Start(ctx)
 	if err != nil {
 		return nil, errors.Wrapf(err, "start client error")
@@ -150,30 +322,31 @@ func NewClient(ctx context.Context, chainID string, peers []*PeerCfg, opts ...Op
 	return client, nil
 }
 
-func (c *Client) closeAllPeer() {
-	for _, p := range c.ps {
-		p.peer.ClosePeer()
-	}
-
-	for _, p := range c.ps {
-		p.peer.Wait()
-	}
-}
-
-// Start start client process goroutine
+// Start start client process goroutine, the client will shutdown when ctx done
 func (c *Client) Start(ctx context.Context) error {
 	c.logger.Info("Starting client")
 
+	mngCtx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
-		c.peerLoop(ctx)
+		defer close(c.loopDone)
+		c.peerLoop()
 	}()
 
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
-		c.peerMngLoop(ctx)
+		defer close(c.mngDone)
+		c.peerMngLoop(mngCtx)
+	}()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.waitShutdown(ctx)
 	}()
 
 	return nil
@@ -194,6 +367,66 @@ func (c *Client) HeadBlockNum() uint32 {
 	return c.blkStorer.HeadBlockNum()
 }
 
+// seedCheckpoint seed storer by the checkpoint or start block num in options
+func (c *Client) seedCheckpoint(opts *Options) error {
+	cp := opts.checkpoint
+	if cp == nil && opts.needSync && opts.startBlockNum > 1 {
+		// no block id, so the first block synced will be trusted
+		cp = &store.Checkpoint{
+			BlockNum: opts.startBlockNum - 1,
+		}
+	}
+
+	if cp == nil || c.blkStorer.HeadBlockNum() >= cp.BlockNum {
+		return nil
+	}
+
+	seeder, ok := c.blkStorer.(store.CheckpointSeeder)
+	if !ok {
+		return errors.New("storer not support start from checkpoint")
+	}
+
+	return seeder.SeedCheckpoint(*cp)
+}
+
+// checkBlockLinkable check the block can link to head if it is the next block,
+// or it is the head block, blocks after the next block cannot link
+func (c *Client) checkBlockLinkable(blk *SignedBlock) error {
+	stat := c.blkStorer.State()
+	if len(stat.HeadBlockID) == 0 {
+		return nil
+	}
+
+	if blk.BlockNumber() == stat.HeadBlockNum {
+		id, err := blk.BlockID()
+		if err != nil {
+			return errors.Wrap(err, "block id")
+		}
+
+		if !types.IsChecksumEq(id, stat.HeadBlockID) {
+			return errors.Errorf("block %d id %s diff from head %s",
+				blk.BlockNumber(), id.String(), stat.HeadBlockID.String())
+		}
+		return nil
+	}
+
+	if blk.BlockNumber() < stat.HeadBlockNum {
+		return nil
+	}
+
+	if blk.BlockNumber() > stat.HeadBlockNum+1 {
+		return errors.Errorf("block %d cannot link to head %d",
+			blk.BlockNumber(), stat.HeadBlockNum)
+	}
+
+	if !types.IsChecksumEq(blk.Previous, stat.HeadBlockID) {
+		return errors.Errorf("block %d previous %s not link to head %s",
+			blk.BlockNumber(), blk.Previous.String(), stat.HeadBlockID.String())
+	}
+
+	return nil
+}
+
 // SetHeadBlock set head block number current
 func (c *Client) SetHeadBlock(blk *SignedBlock) error {
 	err := c.blkStorer.CommitBlock(blk)
@@ -0,0 +1,100 @@
+package p2p_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+)
+
+// TestBlockStreamDedup test each block only emitted once when it is from several peers
+func TestBlockStreamDedup(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 120)
+	srv1 := newServerForTest(t, chain)
+	srv2 := newServerForTest(t, chain)
+	storer := newStorerForTest(t, logger)
+
+	var (
+		mutex  sync.Mutex
+		news   = make(map[uint32]int, 128)
+		blocks = make(map[uint32]int, 128)
+		libNum uint32
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv1.Addr()}, {Address: srv2.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer),
+		p2p.WithHandler(p2p.NewHandlerFunc("blocks", func(envelope *p2p.Envelope) {
+			if blk, ok := envelope.Packet.P2PMessage.(*p2p.SignedBlock); ok {
+				mutex.Lock()
+				blocks[blk.BlockNumber()]++
+				mutex.Unlock()
+			}
+		})),
+		p2p.WithBlockHandler(p2p.NewBlockHandlerFunc("events", func(ev *p2p.BlockEvent) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch ev.Type {
+			case p2p.BlockEventNew:
+				news[ev.BlockNum]++
+			case p2p.BlockEventIrreversible:
+				libNum = ev.BlockNum
+			}
+		})))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.SyncStatus().Phase == "live"
+	})
+
+	next, err := chain.Extend(121)
+	if err != nil {
+		t.Fatalf("extend chain error %s", err.Error())
+	}
+
+	for _, srv := range []*p2ptest.Server{srv1, srv2} {
+		if err := srv.Broadcast(next.HeadBlock()); err != nil {
+			t.Fatalf("broadcast error %s", err.Error())
+		}
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return news[121] > 0
+	})
+
+	// wait the block from the other peer processed
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	client.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for num := uint32(1); num <= 121; num++ {
+		if news[num] != 1 {
+			t.Errorf("block %d should new once, got %d", num, news[num])
+		}
+	}
+
+	if blocks[121] != 1 {
+		t.Errorf("handlers should get block 121 once, got %d", blocks[121])
+	}
+
+	if libNum != 120 {
+		t.Errorf("lib should be 120 from handshake, got %d", libNum)
+	}
+}
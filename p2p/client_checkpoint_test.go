@@ -0,0 +1,125 @@
+package p2p_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/store"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// TestSyncFromCheckpoint test client sync from a trusted checkpoint, not from the first block
+func TestSyncFromCheckpoint(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 120))
+	storer := newStorerForTest(t, logger)
+
+	cpBlk, _ := srv.Chain().GetBlockByNum(80)
+	cpID, _ := cpBlk.BlockID()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithCheckpoint(store.Checkpoint{
+			BlockNum: 80,
+			BlockID:  cpID,
+		}),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 120
+	})
+
+	headID, _ := srv.Chain().HeadBlock().BlockID()
+	if !types.IsChecksumEq(storer.HeadBlockID(), headID) {
+		t.Errorf("head id diff %s %s", storer.HeadBlockID(), headID)
+	}
+
+	for _, msg := range srv.Received() {
+		if req, ok := msg.(*types.SyncRequestMessage); ok && req.StartBlock < 80 {
+			t.Errorf("should not request blocks before checkpoint, got %d", req.StartBlock)
+		}
+	}
+
+	cancel()
+	client.Wait()
+}
+
+// TestSyncFromWrongCheckpoint test client will not accept blocks not matched the checkpoint
+func TestSyncFromWrongCheckpoint(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 120))
+	storer := newStorerForTest(t, logger)
+
+	wrongChain, err := srv.Chain().Fork(70, 90)
+	if err != nil {
+		t.Fatalf("fork chain error %s", err.Error())
+	}
+
+	cpBlk, _ := wrongChain.GetBlockByNum(80)
+	cpID, _ := cpBlk.BlockID()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithCheckpoint(store.Checkpoint{
+			BlockNum: 80,
+			BlockID:  cpID,
+			Header:   &cpBlk.BlockHeader,
+		}),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return client.PeerScore(srv.Addr()) < 0
+	})
+
+	if client.HeadBlockNum() != 80 {
+		t.Errorf("should not sync blocks not linked to checkpoint, head %d", client.HeadBlockNum())
+	}
+
+	cancel()
+	client.Wait()
+}
+
+// TestSyncFromStartBlockNum test client sync from the start block num in WithNeedSync
+func TestSyncFromStartBlockNum(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 120))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(100),
+		p2p.WithStorer(newStorerForTest(t, logger)))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 120
+	})
+
+	for _, msg := range srv.Received() {
+		if req, ok := msg.(*types.SyncRequestMessage); ok && req.StartBlock < 99 {
+			t.Errorf("should not request blocks before start block, got %d", req.StartBlock)
+		}
+	}
+
+	cancel()
+	client.Wait()
+}
@@ -0,0 +1,32 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+// TestWithHeartbeat test the zero fields of heartbeat config keep the defaults
+func TestWithHeartbeat(t *testing.T) {
+	o := Options{
+		heartbeat: HeartbeatCfg{
+			Interval:    defaultHeartbeatInterval,
+			IdleTimeout: defaultIdleTimeout,
+		},
+	}
+
+	if err := WithHeartbeat(HeartbeatCfg{IdleTimeout: time.Second})(&o); err != nil {
+		t.Fatalf("with heartbeat error %s", err.Error())
+	}
+
+	if o.heartbeat.Interval != defaultHeartbeatInterval || o.heartbeat.IdleTimeout != time.Second {
+		t.Errorf("heartbeat cfg should merge with defaults, got %v", o.heartbeat)
+	}
+
+	if err := WithHeartbeat(HeartbeatCfg{Interval: -1})(&o); err != nil {
+		t.Fatalf("with heartbeat error %s", err.Error())
+	}
+
+	if o.heartbeat.Interval >= 0 || o.heartbeat.IdleTimeout != time.Second {
+		t.Errorf("heartbeat should be disabled by negative interval, got %v", o.heartbeat)
+	}
+}
@@ -1,11 +1,13 @@
 package p2p
 
 import (
-	"context"
 	"io"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
 )
 
 type envelopMsgTyp uint8
@@ -18,6 +20,13 @@ const (
 	envelopMsgPacket
 	envelopMsgStartSync
 	envelopMsgSyncSuccess
+	envelopMsgShutdown
+	envelopMsgSwitchSyncPeer
+	envelopMsgSyncRange
+	envelopMsgSyncRangeCancel
+	envelopMsgResetStream
+	envelopMsgReplay
+	envelopMsgWaitTrx
 )
 
 type envelopMsg struct {
@@ -26,6 +35,13 @@ type envelopMsg struct {
 	Packet  *Packet
 	handler Handler
 	err     error
+
+	// handlerDone the result of resuming the handler added
+	handlerDone chan error
+
+	rangeJob  *syncRangeJob
+	replayJob *replayJob
+	trxWaiter *trxWaiter
 }
 
 func newEnvelopMsgWithError(sender *Peer, err error) envelopMsg {
@@ -58,40 +74,72 @@ func newHandlerDelMsg(h Handler) envelopMsg {
 	}
 }
 
-// peerLoop all packet from peers will process by there
-func (c *Client) peerLoop(ctx context.Context) {
-	isStopped := false
+// peerLoop all packet from peers will process by there, it stops by the shutdown msg,
+// or at once if shutdown timeout, the msgs not processed are dropped
+func (c *Client) peerLoop() {
+	defer c.sync.progress.close()
+
+	c.resumeHandlers()
+
+	var watchdogTick <-chan time.Time
+	if c.sync.watchdog.timeout > 0 {
+		ticker := time.NewTicker(c.sync.watchdog.checkInterval())
+		defer ticker.Stop()
+		watchdogTick = ticker.C
+	}
+
 	for {
+		var r envelopMsg
 		select {
-		case r, ok := <-c.packetChan:
-			if !ok {
-				c.logger.Info("client peerLoop stop")
-				return
+		case r = <-c.packetChan:
+		case <-c.loopStop:
+			c.logger.Warn("client peerLoop stopped by shutdown timeout")
+			return
+		case <-watchdogTick:
+			if !c.isShuttingDown() {
+				c.onWatchdogTick()
 			}
+			continue
+		}
 
-			switch r.typ {
-			case envelopMsgAddHandler:
-				c.onAddHandlerMsg(&r)
-			case envelopMsgDelHandler:
-				c.onDelHandlerMsg(&r)
-			case envelopMsgStartSync:
+		switch r.typ {
+		case envelopMsgAddHandler:
+			c.onAddHandlerMsg(&r)
+		case envelopMsgDelHandler:
+			c.onDelHandlerMsg(&r)
+		case envelopMsgStartSync:
+			if !c.isShuttingDown() {
 				c.onStartSyncIrreversible(r.Sender)
-			case envelopMsgError:
-				if !isStopped {
-					c.onPeerErrorMsg(&r)
-				}
-			case envelopMsgPacket:
-				c.onPacketMsg(&r)
 			}
-
-		case <-ctx.Done():
-			if !isStopped {
-				isStopped = true
-				c.logger.Info("close p2p client")
-				c.closeAllPeer()
-				c.logger.Info("all peer is closed, to close client peerLoop")
-				close(c.packetChan)
+		case envelopMsgError:
+			if !c.isShuttingDown() {
+				c.onPeerErrorMsg(&r)
 			}
+		case envelopMsgSwitchSyncPeer:
+			if !c.isShuttingDown() {
+				c.switchSyncPeer(r.Sender)
+			}
+		case envelopMsgSyncRange:
+			if !c.isShuttingDown() {
+				c.onSyncRange(r.rangeJob)
+			}
+		case envelopMsgSyncRangeCancel:
+			c.onSyncRangeCancel(r.rangeJob)
+		case envelopMsgResetStream:
+			c.resetStream()
+		case envelopMsgReplay:
+			if !c.isShuttingDown() {
+				c.onReplay(r.replayJob)
+			} else {
+				r.replayJob.done <- errors.New("client is shutting down")
+			}
+		case envelopMsgWaitTrx:
+			c.trxWaits.add(r.trxWaiter)
+		case envelopMsgPacket:
+			c.onPacketMsg(&r)
+		case envelopMsgShutdown:
+			c.logger.Info("client peerLoop stop")
+			return
 		}
 	}
 }
@@ -99,6 +147,19 @@ func (c *Client) peerLoop(ctx context.Context) {
 func (c *Client) onAddHandlerMsg(r *envelopMsg) {
 	handlerName := r.handler.Name()
 	c.logger.Info("new handler", zap.String("name", handlerName))
+
+	resumed, err := c.resumeHandler(r.handler)
+	if r.handlerDone != nil {
+		r.handlerDone <- err
+	}
+
+	if err != nil {
+		c.logger.Error("resume handler error, handler not added",
+			zap.String("name", handlerName), zap.Error(err))
+		return
+	}
+	r.handler = resumed
+
 	for idx, h := range c.handlers {
 		if h.Name() == handlerName {
 			c.logger.Info("replace handler", zap.String("name", handlerName))
@@ -127,7 +188,24 @@ func (c *Client) onDelHandlerMsg(r *envelopMsg) {
 
 func (c *Client) onPacketMsg(r *envelopMsg) {
 	envelope := newEnvelope(r.Sender, r.Packet)
+
+	// link the block to stream first, so the sync handler can follow the fork switched by it
+	isNewBlock := true
+	if blk, ok := isBlockMsg(r.Packet); ok {
+		isNewBlock = c.stream.onBlock(r.Sender, blk)
+	}
+
 	c.syncHandler.Handle(envelope)
+
+	if trx, ok := r.Packet.P2PMessage.(*PackedTransactionMessage); ok && trx != nil {
+		c.trxWaits.onPackedTrx(trx)
+	}
+
+	if !isNewBlock {
+		// same block from other peers, handlers only need once
+		return
+	}
+
 	for _, handle := range c.handlers {
 		handle.Handle(envelope)
 	}
@@ -142,16 +220,43 @@ func (c *Client) onPeerErrorMsg(r *envelopMsg) {
 		c.logger.Info("client res error", zap.Error(r.err))
 	} else {
 		c.logger.Info("conn closed")
-		c.peerChan <- peerMsg{
-			err:    r.err,
-			peer:   r.Sender,
-			msgTyp: peerMsgErrPeer,
-		}
 	}
 
+	if types.IsPacketDecodeError(r.err) {
+		c.reportPeer(r.Sender, PeerEventDecodeFailure)
+	}
+
+	if isTimeoutErr(r.err) {
+		c.logger.Info("peer idle timeout", zap.String("peer", r.Sender.Address))
+		c.reportPeer(r.Sender, PeerEventTimeout)
+	}
+
+	c.postPeerMsg(peerMsg{
+		err:    r.err,
+		peer:   r.Sender,
+		msgTyp: peerMsgErrPeer,
+	})
 }
 
-// RegisterHandler reg handler to client
+// RegisterHandler reg handler to client, the handler is not added if it cannot be resumed from its cursor,
+// use AddHandler to get the error
 func (c *Client) RegisterHandler(handler Handler) {
-	c.packetChan <- newHandlerAddMsg(handler)
+	c.postEnvelopMsg(newHandlerAddMsg(handler))
+}
+
+// AddHandler add handler to client and wait the blocks after its cursor replayed to it,
+// return the error if it cannot be resumed from its cursor, it should not be called in handlers
+func (c *Client) AddHandler(handler Handler) error {
+	msg := newHandlerAddMsg(handler)
+	msg.handlerDone = make(chan error, 1)
+	if !c.postEnvelopMsg(msg) {
+		return errors.New("client stopped")
+	}
+
+	select {
+	case err := <-msg.handlerDone:
+		return err
+	case <-c.loopDone:
+		return errors.New("client stopped")
+	}
 }
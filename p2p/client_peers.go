@@ -14,6 +14,12 @@ type peerMsg struct {
 	peer   *Peer
 	cfg    *PeerCfg
 	err    error
+
+	// blockNum the block num need for sync stalled
+	blockNum uint32
+
+	statsResp chan []PeerStats
+	peerResp  chan *Peer
 }
 
 type peerMsgTyp uint8
@@ -23,9 +29,48 @@ const (
 	peerMsgDelPeer
 	peerMsgErrPeer
 	peerSyncFinished
+	peerMsgBanPeer
+	peerMsgStats
+	peerMsgSyncStalled
+	peerMsgSelectRangePeer
+	peerMsgDialed
 )
 
+// reconnectDelay delay to redial a static peer failed to connect
+const reconnectDelay = 3 * time.Second
+
 func (c *Client) peerMngLoop(ctx context.Context) {
+	var discoveryTick <-chan time.Time
+	if c.discovery != nil {
+		ticker := time.NewTicker(c.discovery.cfg.DialInterval)
+		defer ticker.Stop()
+		discoveryTick = ticker.C
+
+		defer func() {
+			if err := c.discovery.book.Save(); err != nil {
+				c.logger.Error("save address book error", zap.Error(err))
+			}
+		}()
+	}
+
+	var syncPeerTick <-chan time.Time
+	if c.needSync {
+		ticker := time.NewTicker(syncPeerCheckInterval)
+		defer ticker.Stop()
+		syncPeerTick = ticker.C
+	}
+
+	var heartbeatTick <-chan time.Time
+	if c.heartbeat.Interval > 0 {
+		ticker := time.NewTicker(c.heartbeat.Interval)
+		defer ticker.Stop()
+		heartbeatTick = ticker.C
+	}
+
+	// the score changes by decay interval, so check the waiting peers by it
+	reconnectTick := time.NewTicker(c.scorer.cfg.DecayInterval)
+	defer reconnectTick.Stop()
+
 	for {
 		select {
 		case p := <-c.peerChan:
@@ -46,8 +91,30 @@ func (c *Client) peerMngLoop(ctx context.Context) {
 				c.onErrPeer(ctx, &p)
 			case peerSyncFinished:
 				c.onSyncFinished(ctx, &p)
+			case peerMsgBanPeer:
+				c.onBanPeer(&p)
+			case peerMsgStats:
+				c.onPeerStats(&p)
+			case peerMsgSyncStalled:
+				c.onSyncStalled(&p)
+			case peerMsgSelectRangePeer:
+				c.onSelectRangePeer(&p)
+			case peerMsgDialed:
+				c.onPeerDialed(ctx, &p)
 			}
 
+		case <-discoveryTick:
+			c.onDiscoveryTick(ctx)
+
+		case <-heartbeatTick:
+			c.onHeartbeatTick()
+
+		case <-syncPeerTick:
+			c.onSyncPeerTick()
+
+		case <-reconnectTick.C:
+			c.onReconnectTick(ctx)
+
 		case <-ctx.Done():
 			// no need wait all msg in chan processed
 			c.logger.Info("close peer chan mng")
@@ -64,10 +131,16 @@ func (c *Client) onNewPeer(ctx context.Context, msg *peerMsg) {
 		return
 	}
 
+	if c.scorer.isBanned(msg.cfg.Address) {
+		c.logger.Info("peer is banned, no connect", zap.String("addr", msg.cfg.Address))
+		return
+	}
+
 	peer, err := NewPeer(msg.cfg, c, c.HeadBlockNum(), c.ChainID())
 
 	if err != nil {
-		c.logger.Error("new peer failed", zap.String("addr", msg.peer.Address), zap.Error(err))
+		c.logger.Error("new peer failed", zap.String("addr", msg.cfg.Address), zap.Error(err))
+		return
 	}
 
 	c.ps[msg.cfg.Address] = &peerStatus{
@@ -89,9 +162,19 @@ func (c *Client) onDelPeer(ctx context.Context, msg *peerMsg) {
 	c.logger.Info("del peer", zap.String("addr", msg.cfg.Address))
 
 	ps.status = peerStatClosed
+	if ps.isDialing {
+		// closed when dialed
+		return
+	}
+
 	ps.peer.ClosePeer()
 	ps.peer.Wait()
 
+	if c.currentSyncPeer == ps.peer {
+		c.currentSyncPeer = nil
+		c.changeSyncPeer(ps.peer, c.HeadBlockNum()+1)
+	}
+
 	c.logger.Info("peer closed", zap.String("addr", msg.cfg.Address))
 }
 
@@ -111,14 +194,59 @@ func (c *Client) onErrPeer(ctx context.Context, msg *peerMsg) {
 		return
 	}
 
-	c.logger.Info("reconnect peer", zap.String("addr", msg.peer.Address))
-	if err := c.StartPeer(ctx, msg.peer); err != nil {
-		time.Sleep(3 * time.Second)
+	// redial at once if the connection lost, or wait a while if failed to connect
+	delay := time.Duration(0)
+	if ps.status != peerStatNormal {
+		delay = reconnectDelay
+	}
+
+	ps.status = peerStatError
+	if c.currentSyncPeer == msg.peer {
+		c.logger.Info("sync peer disconnected", zap.String("addr", msg.peer.Address))
+		c.currentSyncPeer = nil
+		c.changeSyncPeer(msg.peer, c.HeadBlockNum()+1)
 	}
 
+	// discovered peers are redialed by discovery ticks with backoff
+	if ps.isDiscovered {
+		if c.discovery.isGiveUp(msg.peer.Address) {
+			c.logger.Info("discovered peer failed too many times, retry it later", zap.String("addr", msg.peer.Address))
+		}
+		ps.status = peerStatClosed
+		ps.peer.ClosePeer()
+		delete(c.ps, msg.peer.Address)
+		return
+	}
+
+	if !c.canReconnect(msg.peer) {
+		c.logger.Info("peer is banned or score too low, wait to reconnect", zap.String("addr", msg.peer.Address))
+		ps.status = peerStatWaiting
+		return
+	}
+
+	c.logger.Info("reconnect peer", zap.String("addr", msg.peer.Address), zap.Duration("delay", delay))
+	c.dialPeer(ctx, ps, delay)
+}
+
+// canReconnect the peer is not banned and its score is higher than the disconnect threshold
+func (c *Client) canReconnect(peer *Peer) bool {
+	return !c.isPeerBanned(peer) && !c.scorer.isUnderDisconnect(peer.Address)
+}
+
+// onReconnectTick (IN peerMngLoop) reconnect the waiting peers which ban expired and score recovered
+func (c *Client) onReconnectTick(ctx context.Context) {
+	for _, ps := range c.ps {
+		if ps.status != peerStatWaiting || !c.canReconnect(ps.peer) {
+			continue
+		}
+
+		c.logger.Info("reconnect waiting peer", zap.String("addr", ps.peer.Address))
+		ps.status = peerStatError
+		c.dialPeer(ctx, ps, 0)
+	}
 }
 
-// StartPeer start a peer r/w
+// StartPeer start a peer r/w, the peer is dialed in a goroutine, so peerMngLoop not blocked by it
 func (c *Client) StartPeer(ctx context.Context, p *Peer) error {
 	c.logger.Info("Start Connect Peer", zap.String("peer", p.Address))
 
@@ -128,43 +256,122 @@ func (c *Client) StartPeer(ctx context.Context, p *Peer) error {
 		return nil // no process
 	}
 
-	err := p.Start(ctx)
-	if err != nil {
-		c.peerChan <- peerMsg{
-			err:    errors.Wrap(err, "connect error"),
+	c.dialPeer(ctx, ps, 0)
+	return nil
+}
+
+// dialPeer (IN peerMngLoop) dial the peer after delay in a goroutine, the result is posted back by peerMsgDialed,
+// peerMngLoop should not touch the peer until the result received
+func (c *Client) dialPeer(ctx context.Context, ps *peerStatus, delay time.Duration) {
+	if ps.isDialing {
+		return
+	}
+
+	ps.isDialing = true
+	c.dials.Add(1)
+	go func(p *Peer) {
+		defer c.dials.Done()
+
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if c.discovery != nil {
+			c.discovery.book.MarkAttempt(p.Address)
+		}
+
+		err := p.Start(ctx)
+
+		// if peerMngLoop had stopped, the peer is closed with all peers
+		c.postPeerMsg(peerMsg{
+			msgTyp: peerMsgDialed,
 			peer:   p,
-			msgTyp: peerMsgErrPeer,
+			err:    err,
+		})
+	}(ps.peer)
+}
+
+// onPeerDialed (IN peerMngLoop) the peer dialed, make it normal or process the error
+func (c *Client) onPeerDialed(ctx context.Context, msg *peerMsg) {
+	p := msg.peer
+	ps, ok := c.ps[p.Address]
+	if !ok || ps.peer != p {
+		c.logger.Error("no status for peer dialed", zap.String("peer", p.Address))
+		p.ClosePeer()
+		return
+	}
+
+	ps.isDialing = false
+	if ps.status == peerStatClosed {
+		// deleted when dialing
+		p.ClosePeer()
+		return
+	}
+
+	if msg.err != nil {
+		if c.discovery != nil {
+			c.discovery.book.MarkFailure(p.Address)
 		}
-		return err
+
+		c.onErrPeer(ctx, &peerMsg{
+			err:    errors.Wrap(msg.err, "connect error"),
+			peer:   p,
+			msgTyp: peerMsgErrPeer,
+		})
+		return
 	}
 
-	ps.status = peerStatNormal
+	if c.isPeerBanned(p) {
+		// banned when dialing, wait the read loop stopped by the error
+		p.ClosePeer()
+		return
+	}
 
-	// use first peer connected to sync
-	if c.needSync && c.currentSyncPeer == nil {
-		c.startSyncIrr(p)
-		c.currentSyncPeer = p
+	ps.status = peerStatNormal
+	if c.discovery != nil {
+		c.discovery.book.MarkConnected(p.Address)
 	}
 
-	return nil
+	if c.needSync {
+		// handshake to know the head of peer, so the best peer can be selected to sync
+		stat := c.blkStorer.State()
+		if err := p.SendHandshake(stat.ToHandshakeInfo()); err != nil {
+			c.logger.Warn("send handshake error", zap.String("peer", p.Address), zap.Error(err))
+		}
+
+		if c.currentSyncPeer == nil {
+			c.waitSyncPeer()
+		}
+	}
 }
 
 // NewPeer new peer to connect
 func (c *Client) NewPeer(cfg *PeerCfg) error {
-	c.peerChan <- peerMsg{
+	if !c.postPeerMsg(peerMsg{
 		msgTyp: peerMsgNewPeer,
 		cfg:    cfg,
+	}) {
+		return errors.New("client stopped")
 	}
 	return nil
 }
 
 // DelPeerByAddress delete peer and close
 func (c *Client) DelPeerByAddress(address string) error {
-	c.peerChan <- peerMsg{
+	if !c.postPeerMsg(peerMsg{
 		msgTyp: peerMsgDelPeer,
 		cfg: &PeerCfg{
 			Address: address,
 		},
+	}) {
+		return errors.New("client stopped")
 	}
 	return nil
 }
@@ -0,0 +1,113 @@
+package p2p
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// replayJob a job to replay the blocks in [from, to] from storer to handlers
+type replayJob struct {
+	ctx   context.Context
+	from  uint32
+	to    uint32
+	names []string
+	done  chan error
+}
+
+// replayHandlers the handlers by names, all handlers if no names, the block handlers of
+// the block stream cannot be replayed, as the events of them are made by the chain of peers
+func (c *Client) replayHandlers(names []string) ([]Handler, error) {
+	res := make([]Handler, 0, len(c.handlers))
+	if len(names) == 0 {
+		res = append(res, c.handlers...)
+	} else {
+		for _, name := range names {
+			h := c.findHandler(name)
+			if h == nil {
+				if c.stream.findHandler(name) != nil {
+					return nil, errors.Errorf("block handler %s cannot be replayed", name)
+				}
+				return nil, errors.Errorf("no found handler %s", name)
+			}
+			res = append(res, h)
+		}
+	}
+
+	// replay is by the call of user, so the blocks before cursor are not skipped
+	for idx, h := range res {
+		if ch, ok := h.(*cursorHandler); ok {
+			res[idx] = ch.Handler
+		}
+	}
+
+	return res, nil
+}
+
+// findHandler find handler by name, nil if not found
+func (c *Client) findHandler(name string) Handler {
+	for _, h := range c.handlers {
+		if h.Name() == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// onReplay (IN peerLoop) replay the blocks to handlers, packets from peers are processed after it finished
+func (c *Client) onReplay(job *replayJob) {
+	handlers, err := c.replayHandlers(job.names)
+	if err != nil {
+		job.done <- err
+		return
+	}
+
+	c.logger.Info("replay blocks",
+		zap.Uint32("from", job.from), zap.Uint32("to", job.to), zap.Int("handlers", len(handlers)))
+
+	lastNum, err := c.replayBlocks(job.ctx, handlers, job.from, job.to)
+	if err != nil {
+		job.done <- errors.Wrapf(err, "replay stopped after %d", lastNum)
+		return
+	}
+
+	job.done <- nil
+}
+
+// Replay call the handlers by the blocks in [from, to] from storer, all handlers if no names,
+// the envelopes are sent by the replay peer, peers are not requested. Blocks are replayed IN peerLoop,
+// so handlers are not called concurrently, packets from peers wait until replay finished.
+// Only the handlers added by WithHandler or RegisterHandler can be replayed, not the BlockHandlers,
+// it fails if the names include a BlockHandler
+func (c *Client) Replay(ctx context.Context, from, to uint32, handlerNames ...string) error {
+	if from == 0 || from > to {
+		return errors.Errorf("invalid range [%d, %d]", from, to)
+	}
+
+	if headNum := c.HeadBlockNum(); to > headNum {
+		return errors.Errorf("range end %d is after storer head %d", to, headNum)
+	}
+
+	job := &replayJob{
+		ctx:   ctx,
+		from:  from,
+		to:    to,
+		names: handlerNames,
+		done:  make(chan error, 1),
+	}
+
+	if !c.postEnvelopMsg(envelopMsg{
+		typ:       envelopMsgReplay,
+		replayJob: job,
+	}) {
+		return errors.New("client stopped")
+	}
+
+	select {
+	case err := <-job.done:
+		return err
+	case <-c.loopDone:
+		return errors.New("client stopped")
+	}
+}
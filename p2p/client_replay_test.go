@@ -0,0 +1,91 @@
+package p2p_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/store"
+)
+
+// TestReplay test replay the blocks from storer to a handler added after synced
+func TestReplay(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 120)
+	srv := newServerForTest(t, chain)
+
+	storer, err := store.NewBBoltStorer(logger, chainIDForTest, filepath.Join(t.TempDir(), "blocks.db"), true)
+	if err != nil {
+		t.Fatalf("new storer error %s", err.Error())
+	}
+	defer storer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer),
+		p2p.WithBlockHandler(p2p.NewBlockHandlerFunc("events", func(ev *p2p.BlockEvent) {})))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 120
+	})
+
+	// called IN peerLoop, the same goroutine as Replay waiting, so no lock
+	var (
+		nums        []uint32
+		isNotReplay bool
+	)
+	client.RegisterHandler(p2p.NewHandlerFunc("late", func(envelope *p2p.Envelope) {
+		blk, ok := envelope.Packet.P2PMessage.(*p2p.SignedBlock)
+		if !ok {
+			return
+		}
+
+		if !envelope.Sender.IsReplay() {
+			isNotReplay = true
+		}
+		nums = append(nums, blk.BlockNumber())
+	}))
+
+	if err := client.Replay(context.Background(), 10, 100, "late"); err != nil {
+		t.Fatalf("replay error %s", err.Error())
+	}
+
+	if isNotReplay {
+		t.Errorf("sender should be the replay peer")
+	}
+
+	if len(nums) != 91 {
+		t.Fatalf("handler should get 91 blocks, got %d", len(nums))
+	}
+
+	for idx, num := range nums {
+		if num != uint32(10+idx) {
+			t.Fatalf("block %d should be %d, got %d", idx, 10+idx, num)
+		}
+	}
+
+	if err := client.Replay(context.Background(), 10, 100, "unknown"); err == nil {
+		t.Errorf("replay to unknown handler should fail")
+	}
+
+	if err := client.Replay(context.Background(), 10, 100, "late", "events"); err == nil {
+		t.Errorf("replay to block handler should fail")
+	}
+
+	if err := client.Replay(context.Background(), 100, 200, "late"); err == nil {
+		t.Errorf("replay after head should fail")
+	}
+
+	cancel()
+	client.Wait()
+}
@@ -0,0 +1,210 @@
+package p2p
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// defaultShutdownTimeout timeout for shutdown when the ctx of client is done
+const defaultShutdownTimeout = 10 * time.Second
+
+// flusher storer which can flush to db
+type flusher interface {
+	Flush() error
+}
+
+// isClosedErr is err by closing a connection had closed
+func isClosedErr(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe)
+}
+
+// postPeerMsg send msg to peerMngLoop, return false if the loop had stopped
+func (c *Client) postPeerMsg(msg peerMsg) bool {
+	select {
+	case <-c.mngDone:
+		return false
+	default:
+	}
+
+	select {
+	case c.peerChan <- msg:
+		return true
+	case <-c.mngDone:
+		return false
+	}
+}
+
+// postEnvelopMsg send msg to peerLoop, return false if the loop had stopped
+func (c *Client) postEnvelopMsg(msg envelopMsg) bool {
+	select {
+	case <-c.loopDone:
+		return false
+	default:
+	}
+
+	select {
+	case c.packetChan <- msg:
+		return true
+	case <-c.loopDone:
+		return false
+	}
+}
+
+// isShuttingDown is client shutting down
+func (c *Client) isShuttingDown() bool {
+	select {
+	case <-c.shutdownChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitShutdown (IN goroutine) shutdown client when the ctx is done
+func (c *Client) waitShutdown(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+		defer cancel()
+
+		if err := c.Shutdown(shutdownCtx); err != nil {
+			c.logger.Error("shutdown client error", zap.Error(err))
+		}
+	case <-c.shutdownDone:
+	}
+}
+
+// Shutdown stop the client gracefully: send go away to all peers, stop reading,
+// process the msgs received to handlers, then flush the storer,
+// it returns all errors happened, or the ctx error if ctx done before it finished
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.shutdownOnce.Do(func() {
+		go func() {
+			c.shutdownErr = c.shutdown(ctx)
+			close(c.shutdownDone)
+		}()
+	})
+
+	select {
+	case <-c.shutdownDone:
+		return c.shutdownErr
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "wait client shutdown")
+	}
+}
+
+func (c *Client) shutdown(ctx context.Context) error {
+	c.logger.Info("shutdown p2p client")
+	close(c.shutdownChan)
+
+	var errs error
+
+	// stop peerMngLoop so no peer will be connected or reconnected
+	c.cancel()
+	select {
+	case <-c.mngDone:
+		// c.ps will not changed after peerMngLoop stopped
+		if err := c.closeAllPeer(ctx); err != nil {
+			errs = multierr.Append(errs, err)
+		}
+	case <-ctx.Done():
+		errs = multierr.Append(errs, errors.Wrap(ctx.Err(), "wait peer manager stop"))
+
+		// c.ps is still owned by peerMngLoop, close the peers after it stopped
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			<-c.mngDone
+			if err := c.closeAllPeer(context.Background()); err != nil {
+				c.logger.Warn("close peers after shutdown timeout error", zap.Error(err))
+			}
+		}()
+	}
+
+	if err := c.stopPeerLoop(ctx); err != nil {
+		errs = multierr.Append(errs, err)
+	}
+
+	if f, ok := c.blkStorer.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			errs = multierr.Append(errs, errors.Wrap(err, "flush storer"))
+		}
+	}
+
+	c.logger.Info("p2p client stopped")
+
+	return errs
+}
+
+// stopPeerLoop stop peerLoop after the msgs received processed, stop it at once if ctx done
+func (c *Client) stopPeerLoop(ctx context.Context) error {
+	// all peers stopped, the msgs before this marker are all msgs to process
+	select {
+	case c.packetChan <- envelopMsg{typ: envelopMsgShutdown}:
+	case <-c.loopDone:
+		return errors.New("peer loop had stopped")
+	case <-ctx.Done():
+		close(c.loopStop)
+		return errors.Wrap(ctx.Err(), "post shutdown to peer loop")
+	}
+
+	select {
+	case <-c.loopDone:
+		c.logger.Info("all msgs processed")
+		return nil
+	case <-ctx.Done():
+		close(c.loopStop)
+		return errors.Wrap(ctx.Err(), "wait msgs processed")
+	}
+}
+
+// closeAllPeer send go away to all peers then close them, wait all peers stopped
+func (c *Client) closeAllPeer(ctx context.Context) error {
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		errs  error
+	)
+
+	// the dials stop soon by the ctx of peerMngLoop done
+	c.dials.Wait()
+
+	for _, ps := range c.ps {
+		ps.status = peerStatClosed
+
+		wg.Add(1)
+		go func(p *Peer) {
+			defer wg.Done()
+
+			if err := p.Close(goAwayNoReason); err != nil && !isClosedErr(err) {
+				mutex.Lock()
+				errs = multierr.Append(errs, errors.Wrapf(err, "close peer %s", p.Address))
+				mutex.Unlock()
+			}
+			p.Wait()
+		}(ps.peer)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "wait peers stop")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return errs
+}
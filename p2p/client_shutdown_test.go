@@ -0,0 +1,100 @@
+package p2p_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// TestClientShutdown test client send go away to peers and stop when shutdown
+func TestClientShutdown(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 50))
+	storer := newStorerForTest(t, logger)
+
+	client, err := p2p.NewClient(context.Background(), chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 50
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown error %s", err.Error())
+	}
+
+	if err := client.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown again error %s", err.Error())
+	}
+
+	client.Wait()
+
+	waitFor(t, 5*time.Second, func() bool {
+		for _, msg := range srv.Received() {
+			if goAway, ok := msg.(*types.GoAwayMessage); ok {
+				return goAway.Reason == types.GoAwayNoReason
+			}
+		}
+		return false
+	})
+
+	if err := client.NewPeer(&p2p.PeerCfg{Address: srv.Addr()}); err == nil {
+		t.Errorf("new peer after shutdown should fail")
+	}
+
+	if client.PeerStats() != nil {
+		t.Errorf("no peer stats after shutdown")
+	}
+}
+
+// TestClientShutdownTimeout test the client stopped even if shutdown timeout
+func TestClientShutdownTimeout(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 50))
+
+	client, err := p2p.NewClient(context.Background(), chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(newStorerForTest(t, logger)))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 50
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := client.Shutdown(ctx); err == nil {
+		t.Fatalf("shutdown should timeout")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("client should stop after shutdown timeout")
+	}
+}
@@ -19,21 +19,32 @@ func (c *Client) onStartSyncIrreversible(peer *Peer) {
 
 	c.logger.Info("curr stat", zap.Uint32("headNum", stat.HeadBlockNum))
 
+	c.sync.setSyncPeer(peer)
+	c.sync.watchdog.reset(stat.HeadBlockNum)
+	c.sync.updateProgress()
+
+	// the handshake from peer may be received before it selected, so process it again
+	if hs := peer.handshakeRecv(); hs != nil {
+		c.sync.OnHandshakeMsg(peer, hs)
+		return
+	}
+
 	h := stat.ToHandshakeInfo()
 	peer.SendHandshake(h)
 }
 
 // startSyncIrr start sync by peer
 func (c *Client) startSyncIrr(peer *Peer) {
-	c.packetChan <- envelopMsg{
+	c.postEnvelopMsg(envelopMsg{
 		Sender: peer,
 		typ:    envelopMsgStartSync,
-	}
+	})
 }
 
 // onSyncFinished (IN peerMngLoop) when sync irr success start to sync blocks and trxs( if need )
 func (c *Client) onSyncFinished(ctx context.Context, msg *peerMsg) {
 	c.logger.Info("sync finished", zap.Uint32("current head", c.HeadBlockNum()))
+	c.syncPeerSelect.isSyncDone = true
 
 	stat := c.blkStorer.State()
 	h := stat.ToHandshakeInfo()
@@ -48,8 +59,8 @@ func (c *Client) onSyncFinished(ctx context.Context, msg *peerMsg) {
 
 // syncSuccessNotice notice sync irr stop
 func (c *Client) syncSuccessNotice(peer *Peer) {
-	c.peerChan <- peerMsg{
+	c.postPeerMsg(peerMsg{
 		msgTyp: peerSyncFinished,
 		peer:   peer,
-	}
+	})
 }
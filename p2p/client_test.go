@@ -0,0 +1,96 @@
+package p2p_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+	"github.com/fanyang1988/eos-p2p/store"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+const chainIDForTest = "76eab2b704733e933d0e4eb6cc24d260d9fbbe5d93d760392e97398f4e301448"
+
+func newStorerForTest(t *testing.T, logger *zap.Logger) *store.BBoltStorer {
+	storer, err := store.NewBBoltStorer(logger, chainIDForTest, filepath.Join(t.TempDir(), "blocks.db"), false)
+	if err != nil {
+		t.Fatalf("new storer error %s", err.Error())
+	}
+	t.Cleanup(storer.Close)
+	return storer
+}
+
+// producersForTest 21 producers like mainnet, so the lib estimated by the blocks is far behind head
+func producersForTest() types.ChainGenOption {
+	producers := make([]string, 0, 21)
+	for i := 0; i < 21; i++ {
+		producers = append(producers, "producer"+string(rune('a'+i)))
+	}
+	return types.WithGenProducers(producers...)
+}
+
+func newChainForTest(t *testing.T, headNum uint32) *p2ptest.Chain {
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForTest), producersForTest())
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	if _, err := gen.GenerateTo(headNum); err != nil {
+		t.Fatalf("generate chain error %s", err.Error())
+	}
+	return p2ptest.NewChainByGenerator(gen)
+}
+
+func newServerForTest(t *testing.T, chain *p2ptest.Chain, opts ...p2ptest.ServerOption) *p2ptest.Server {
+	srv, err := p2ptest.NewServer(types.MustNewChecksum256(chainIDForTest), chain, opts...)
+	if err != nil {
+		t.Fatalf("new mock peer error %s", err.Error())
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("wait timeout after %s", timeout)
+}
+
+// TestClientSyncFromMockPeer test client sync all blocks from a mock peer
+func TestClientSyncFromMockPeer(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 235), p2ptest.WithLogger(logger))
+	storer := newStorerForTest(t, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 235
+	})
+
+	headID, _ := srv.Chain().HeadBlock().BlockID()
+	if !types.IsChecksumEq(storer.HeadBlockID(), headID) {
+		t.Errorf("head id diff %s %s", storer.HeadBlockID(), headID)
+	}
+
+	cancel()
+	client.Wait()
+}
@@ -0,0 +1,46 @@
+package p2p
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/store"
+)
+
+// onStreamUndo (IN peerLoop) rewind the head from the block undone by switching fork,
+// and remove the index of trxs and account actions in it
+func (c *Client) onStreamUndo(blk *SignedBlock) {
+	c.rewindHead(blk)
+
+	if ts, ok := c.blkStorer.(store.TransactionStorer); ok {
+		if err := ts.UndoBlockTransactions(blk); err != nil {
+			c.logger.Warn("undo trxs in block error", zap.Uint32("num", blk.BlockNumber()), zap.Error(err))
+		}
+	}
+
+	if hs, ok := c.blkStorer.(store.AccountHistoryStorer); ok {
+		if err := hs.UndoBlockActions(blk); err != nil {
+			c.logger.Warn("undo actions in block error", zap.Uint32("num", blk.BlockNumber()), zap.Error(err))
+		}
+	}
+}
+
+// GetTransaction get the location of the trx by id from storer
+func (c *Client) GetTransaction(id Checksum256) (store.TrxLocation, bool, error) {
+	ts, ok := c.blkStorer.(store.TransactionStorer)
+	if !ok {
+		return store.TrxLocation{}, false, errors.New("storer not support trx index")
+	}
+
+	return ts.GetTransaction(id)
+}
+
+// GetAccountActions get a page of the actions of account from storer
+func (c *Client) GetAccountActions(query store.AccountHistoryQuery) ([]*store.AccountAction, error) {
+	hs, ok := c.blkStorer.(store.AccountHistoryStorer)
+	if !ok {
+		return nil, errors.New("storer not support account history")
+	}
+
+	return hs.GetAccountActions(query)
+}
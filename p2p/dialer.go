@@ -0,0 +1,405 @@
+package p2p
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Dialer dial the connection to a peer address
+type Dialer interface {
+	DialContext(ctx context.Context, address string) (net.Conn, error)
+}
+
+// DialerFunc a func as Dialer
+type DialerFunc func(ctx context.Context, address string) (net.Conn, error)
+
+// DialContext imp Dialer
+func (f DialerFunc) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	return f(ctx, address)
+}
+
+// netDialer dial by the network from net pkg
+type netDialer struct {
+	network string
+	dialer  net.Dialer
+}
+
+// NewTCPDialer create a dialer dial by tcp, it is the default dialer
+func NewTCPDialer() Dialer {
+	return &netDialer{network: "tcp"}
+}
+
+// NewUnixDialer create a dialer dial unix socket, address is the path of socket
+func NewUnixDialer() Dialer {
+	return &netDialer{network: "unix"}
+}
+
+// DialContext imp Dialer
+func (d *netDialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, d.network, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial %s %s", d.network, address)
+	}
+	return conn, nil
+}
+
+// tlsDialer dial by tls over a base dialer
+type tlsDialer struct {
+	base Dialer
+	cfg  *tls.Config
+}
+
+// NewTLSDialer create a dialer connect to a tls endpoint by base dialer, use tcp if base is nil
+func NewTLSDialer(base Dialer, cfg *tls.Config) Dialer {
+	if base == nil {
+		base = NewTCPDialer()
+	}
+
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+
+	return &tlsDialer{
+		base: base,
+		cfg:  cfg,
+	}
+}
+
+// DialContext imp Dialer
+func (d *tlsDialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	rawConn, err := d.base.DialContext(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := d.cfg
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			cfg.ServerName = host
+		} else {
+			cfg.ServerName = address
+		}
+	}
+
+	conn := tls.Client(rawConn, cfg)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, errors.Wrapf(err, "tls handshake to %s", address)
+	}
+
+	return conn, nil
+}
+
+// socks5 consts, see rfc1928 and rfc1929
+const (
+	socks5Version         = 0x05
+	socks5AuthNone        = 0x00
+	socks5AuthPassword    = 0x02
+	socks5AuthNoAccept    = 0xff
+	socks5PasswordVersion = 0x01
+	socks5CmdConnect      = 0x01
+	socks5AtypIPv4        = 0x01
+	socks5AtypDomain      = 0x03
+	socks5AtypIPv6        = 0x04
+)
+
+// socks5Dialer dial to peer by a socks5 proxy
+type socks5Dialer struct {
+	base      Dialer
+	proxyAddr string
+	username  string
+	password  string
+}
+
+// NewSOCKS5Dialer create a dialer connect to peer by the socks5 proxy,
+// no auth if username is empty, use tcp to connect proxy if base is nil
+func NewSOCKS5Dialer(base Dialer, proxyAddr, username, password string) Dialer {
+	if base == nil {
+		base = NewTCPDialer()
+	}
+
+	return &socks5Dialer{
+		base:      base,
+		proxyAddr: proxyAddr,
+		username:  username,
+		password:  password,
+	}
+}
+
+// DialContext imp Dialer
+func (d *socks5Dialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	conn, err := d.base.DialContext(ctx, d.proxyAddr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial socks5 proxy %s", d.proxyAddr)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "set socks5 deadline")
+		}
+	}
+
+	if err := d.connect(conn, address); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "socks5 connect to %s by %s", address, d.proxyAddr)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "reset socks5 deadline")
+	}
+
+	return conn, nil
+}
+
+func (d *socks5Dialer) connect(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrap(err, "split address")
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return errors.Wrapf(err, "parse port %s", portStr)
+	}
+
+	if err := d.auth(conn); err != nil {
+		return err
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AtypIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AtypIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.Errorf("host name too long %s", host)
+		}
+		req = append(req, socks5AtypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+
+	portBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBytes, uint16(port))
+	req = append(req, portBytes...)
+
+	if _, err := conn.Write(req); err != nil {
+		return errors.Wrap(err, "write connect request")
+	}
+
+	// ver, rep, rsv, atyp
+	resp := make([]byte, 4)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return errors.Wrap(err, "read connect reply")
+	}
+
+	if resp[0] != socks5Version {
+		return errors.Errorf("unexpected socks version %d", resp[0])
+	}
+
+	if resp[1] != 0x00 {
+		return errors.Errorf("connect failed by reply %d", resp[1])
+	}
+
+	// skip the bound address and port
+	var addrLen int
+	switch resp[3] {
+	case socks5AtypIPv4:
+		addrLen = net.IPv4len
+	case socks5AtypIPv6:
+		addrLen = net.IPv6len
+	case socks5AtypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return errors.Wrap(err, "read bound address len")
+		}
+		addrLen = int(l[0])
+	default:
+		return errors.Errorf("unknown address type %d", resp[3])
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, addrLen+2)); err != nil {
+		return errors.Wrap(err, "read bound address")
+	}
+
+	return nil
+}
+
+func (d *socks5Dialer) auth(conn net.Conn) error {
+	methods := []byte{socks5AuthNone}
+	if d.username != "" {
+		methods = []byte{socks5AuthNone, socks5AuthPassword}
+	}
+
+	req := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(req); err != nil {
+		return errors.Wrap(err, "write auth methods")
+	}
+
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return errors.Wrap(err, "read auth method")
+	}
+
+	if resp[0] != socks5Version {
+		return errors.Errorf("unexpected socks version %d", resp[0])
+	}
+
+	switch resp[1] {
+	case socks5AuthNone:
+		return nil
+	case socks5AuthPassword:
+		if d.username == "" {
+			return errors.New("proxy need password auth")
+		}
+	case socks5AuthNoAccept:
+		return errors.New("no auth method accepted by proxy")
+	default:
+		return errors.Errorf("unknown auth method %d", resp[1])
+	}
+
+	if len(d.username) > 255 || len(d.password) > 255 {
+		return errors.New("username or password too long")
+	}
+
+	authReq := []byte{socks5PasswordVersion, byte(len(d.username))}
+	authReq = append(authReq, d.username...)
+	authReq = append(authReq, byte(len(d.password)))
+	authReq = append(authReq, d.password...)
+	if _, err := conn.Write(authReq); err != nil {
+		return errors.Wrap(err, "write password auth")
+	}
+
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return errors.Wrap(err, "read password auth")
+	}
+
+	if resp[1] != 0x00 {
+		return errors.Errorf("password auth failed by status %d", resp[1])
+	}
+
+	return nil
+}
+
+// memAddr address in MemNetwork
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+// MemNetwork a in-memory network by net.Pipe, used to connect peers in one process,
+// it is a Dialer to its listeners
+type MemNetwork struct {
+	mutex     sync.Mutex
+	listeners map[string]*memListener
+}
+
+// NewMemNetwork create a in-memory network
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{
+		listeners: make(map[string]*memListener, 8),
+	}
+}
+
+// Listen listen on address in network
+func (n *MemNetwork) Listen(address string) (net.Listener, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if _, ok := n.listeners[address]; ok {
+		return nil, errors.Errorf("address %s already in use", address)
+	}
+
+	l := &memListener{
+		network: n,
+		addr:    memAddr(address),
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	n.listeners[address] = l
+
+	return l, nil
+}
+
+// DialContext imp Dialer
+func (n *MemNetwork) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	n.mutex.Lock()
+	l, ok := n.listeners[address]
+	n.mutex.Unlock()
+
+	if !ok {
+		return nil, errors.Errorf("dial mem %s: connection refused", address)
+	}
+
+	client, server := net.Pipe()
+
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		client.Close()
+		server.Close()
+		return nil, errors.Errorf("dial mem %s: connection refused", address)
+	case <-ctx.Done():
+		client.Close()
+		server.Close()
+		return nil, errors.Wrapf(ctx.Err(), "dial mem %s", address)
+	}
+}
+
+func (n *MemNetwork) remove(l *memListener) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if curr, ok := n.listeners[string(l.addr)]; ok && curr == l {
+		delete(n.listeners, string(l.addr))
+	}
+}
+
+// memListener listener in MemNetwork
+type memListener struct {
+	network *MemNetwork
+	addr    memAddr
+	conns   chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Accept imp net.Listener
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.Errorf("accept mem %s: listener closed", l.addr)
+	}
+}
+
+// Close imp net.Listener
+func (l *memListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.network.remove(l)
+	})
+	return nil
+}
+
+// Addr imp net.Listener
+func (l *memListener) Addr() net.Addr {
+	return l.addr
+}
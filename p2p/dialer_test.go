@@ -0,0 +1,140 @@
+package p2p_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+)
+
+func syncByDialerForTest(t *testing.T, address string, dialer p2p.Dialer, headNum uint32) {
+	logger := zap.NewNop()
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: address, Dialer: dialer}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(newStorerForTest(t, logger)))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == headNum
+	})
+
+	cancel()
+	client.Wait()
+}
+
+// TestMemNetworkDialer test sync from mock peer by in-memory connections
+func TestMemNetworkDialer(t *testing.T) {
+	network := p2p.NewMemNetwork()
+	listener, err := network.Listen("mock-peer")
+	if err != nil {
+		t.Fatalf("listen error %s", err.Error())
+	}
+
+	if _, err := network.Listen("mock-peer"); err == nil {
+		t.Errorf("listen on same address should fail")
+	}
+
+	srv := newServerForTest(t, newChainForTest(t, 50), p2ptest.WithListener(listener))
+	if srv.Addr() != "mock-peer" {
+		t.Errorf("server addr error %s", srv.Addr())
+	}
+
+	syncByDialerForTest(t, "mock-peer", network, 50)
+
+	if _, err := network.DialContext(context.Background(), "no-peer"); err == nil {
+		t.Errorf("dial to unknown address should fail")
+	}
+}
+
+// TestSOCKS5Dialer test sync from mock peer by a socks5 proxy with password auth
+func TestSOCKS5Dialer(t *testing.T) {
+	srv := newServerForTest(t, newChainForTest(t, 50))
+
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error %s", err.Error())
+	}
+	defer proxy.Close()
+
+	go func() {
+		for {
+			conn, err := proxy.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5ForTest(conn, "user", "pass")
+		}
+	}()
+
+	dialer := p2p.NewSOCKS5Dialer(nil, proxy.Addr().String(), "user", "pass")
+	syncByDialerForTest(t, srv.Addr(), dialer, 50)
+
+	badDialer := p2p.NewSOCKS5Dialer(nil, proxy.Addr().String(), "user", "wrong")
+	if _, err := badDialer.DialContext(context.Background(), srv.Addr()); err == nil {
+		t.Errorf("dial with wrong password should fail")
+	}
+}
+
+// serveSOCKS5ForTest a minimal socks5 server only support password auth and ipv4 connect
+func serveSOCKS5ForTest(conn net.Conn, username, password string) {
+	defer conn.Close()
+
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, head[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{0x05, 0x02})
+
+	readStr := func() string {
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return ""
+		}
+		s := make([]byte, l[0])
+		io.ReadFull(conn, s)
+		return string(s)
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, 1)); err != nil {
+		return
+	}
+	if readStr() != username || readStr() != password {
+		conn.Write([]byte{0x01, 0x01})
+		return
+	}
+	conn.Write([]byte{0x01, 0x00})
+
+	req := make([]byte, 4+4+2)
+	if _, err := io.ReadFull(conn, req); err != nil || req[3] != 0x01 {
+		return
+	}
+
+	address := net.JoinHostPort(net.IP(req[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(req[8:]))))
+	target, err := net.Dial("tcp", address)
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
@@ -0,0 +1,409 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// DiscoveryCfg config for peer discovery
+type DiscoveryCfg struct {
+	// Seeds static addresses to add into address book
+	Seeds []string `json:"seeds"`
+	// TargetOutbound the number of healthy outbound peers to maintain
+	TargetOutbound int `json:"target"`
+	// AddrBookPath the json file to persist address book, no persist if empty
+	AddrBookPath string `json:"path"`
+	// DialInterval interval to check peers and dial new ones
+	DialInterval time.Duration `json:"interval"`
+	// MaxFailures the interval to redial an address doubles each failure, after failed to connect
+	// so many times continuously the address is only retried after a long interval, except seeds
+	MaxFailures int `json:"maxFailures"`
+	// DialTimeout timeout to dial a discovered peer
+	DialTimeout time.Duration `json:"dialTimeout"`
+}
+
+const (
+	defaultTargetOutbound       = 8
+	defaultDialInterval         = 10 * time.Second
+	defaultMaxFailures          = 5
+	defaultDiscoveryDialTimeout = 2 * time.Second
+
+	// maxDialBackoff max interval to redial an address failed to connect
+	maxDialBackoff = 30 * time.Minute
+	// giveUpRetryInterval interval to retry an address failed too many times, it may come back
+	giveUpRetryInterval = 24 * time.Hour
+)
+
+// address sources
+const (
+	AddrSourceSeed      = "seed"
+	AddrSourceHandshake = "handshake"
+	AddrSourceConfig    = "config"
+)
+
+// AddrInfo a address known by client
+type AddrInfo struct {
+	Address       string    `json:"addr"`
+	Source        string    `json:"source"`
+	LastSeen      time.Time `json:"lastSeen"`
+	LastAttempt   time.Time `json:"lastAttempt"`
+	LastConnected time.Time `json:"lastConnected"`
+	Failures      int       `json:"failures"`
+}
+
+// AddressBook all peer addresses known by client, can be persisted to a json file
+type AddressBook struct {
+	path  string
+	mutex sync.RWMutex
+	addrs map[string]*AddrInfo
+	dirty bool
+}
+
+// NewAddressBook create address book, load from path if the file exists
+func NewAddressBook(path string) (*AddressBook, error) {
+	res := &AddressBook{
+		path:  path,
+		addrs: make(map[string]*AddrInfo, 64),
+	}
+
+	if path == "" {
+		return res, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, errors.Wrapf(err, "read address book %s", path)
+	}
+
+	infos := make([]*AddrInfo, 0, 64)
+	if err := json.Unmarshal(data, &infos); err != nil {
+		return nil, errors.Wrapf(err, "decode address book %s", path)
+	}
+
+	for _, info := range infos {
+		res.addrs[info.Address] = info
+	}
+
+	return res, nil
+}
+
+// Add add a address into book, if had exist just update last seen
+func (b *AddressBook) Add(address string, source string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.dirty = true
+	if info, ok := b.addrs[address]; ok {
+		info.LastSeen = time.Now()
+		return
+	}
+
+	b.addrs[address] = &AddrInfo{
+		Address:  address,
+		Source:   source,
+		LastSeen: time.Now(),
+	}
+}
+
+// MarkAttempt mark a dial attempt to address
+func (b *AddressBook) MarkAttempt(address string) {
+	b.update(address, func(info *AddrInfo) {
+		info.LastAttempt = time.Now()
+	})
+}
+
+// MarkConnected mark address connected success, will reset failures
+func (b *AddressBook) MarkConnected(address string) {
+	b.update(address, func(info *AddrInfo) {
+		info.LastConnected = time.Now()
+		info.LastSeen = info.LastConnected
+		info.Failures = 0
+	})
+}
+
+// MarkFailure mark address connect failed
+func (b *AddressBook) MarkFailure(address string) {
+	b.update(address, func(info *AddrInfo) {
+		info.Failures++
+	})
+}
+
+func (b *AddressBook) update(address string, f func(info *AddrInfo)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	info, ok := b.addrs[address]
+	if !ok {
+		info = &AddrInfo{
+			Address: address,
+			Source:  AddrSourceConfig,
+		}
+		b.addrs[address] = info
+	}
+
+	f(info)
+	b.dirty = true
+}
+
+// Get get info by address
+func (b *AddressBook) Get(address string) (AddrInfo, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	info, ok := b.addrs[address]
+	if !ok {
+		return AddrInfo{}, false
+	}
+	return *info, true
+}
+
+// List all address infos, sorted by address
+func (b *AddressBook) List() []AddrInfo {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	res := make([]AddrInfo, 0, len(b.addrs))
+	for _, info := range b.addrs {
+		res = append(res, *info)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Address < res[j].Address
+	})
+
+	return res
+}
+
+// candidates addresses can dial, less failures and recently seen first
+func (b *AddressBook) candidates(isDialable func(info *AddrInfo) bool, n int) []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	infos := make([]*AddrInfo, 0, len(b.addrs))
+	for _, info := range b.addrs {
+		if !isDialable(info) {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Failures != infos[j].Failures {
+			return infos[i].Failures < infos[j].Failures
+		}
+		return infos[i].LastSeen.After(infos[j].LastSeen)
+	})
+
+	res := make([]string, 0, n)
+	for i := 0; i < len(infos) && i < n; i++ {
+		res = append(res, infos[i].Address)
+	}
+
+	return res
+}
+
+// Save save address book to file if changed
+func (b *AddressBook) Save() error {
+	if b.path == "" {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.dirty {
+		return nil
+	}
+
+	infos := make([]*AddrInfo, 0, len(b.addrs))
+	for _, info := range b.addrs {
+		infos = append(infos, info)
+	}
+
+	data, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode address book")
+	}
+
+	tmpPath := b.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return errors.Wrapf(err, "write address book %s", tmpPath)
+	}
+
+	if err := os.Rename(tmpPath, b.path); err != nil {
+		return errors.Wrapf(err, "rename address book %s", b.path)
+	}
+
+	b.dirty = false
+	return nil
+}
+
+// ParseP2PAddress get the address to dial from the p2p address in handshake,
+// nodeos use format like "host:port - 1a2b3c4"
+func ParseP2PAddress(p2pAddress string) (string, bool) {
+	fields := strings.Fields(p2pAddress)
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	address := fields[0]
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" || port == "" || port == "0" {
+		return "", false
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return "", false
+	}
+
+	return address, true
+}
+
+// discovery peer discovery by handshakes and seeds
+type discovery struct {
+	cfg   DiscoveryCfg
+	book  *AddressBook
+	seeds map[string]bool
+	cli   *Client
+}
+
+func newDiscovery(cli *Client, cfg DiscoveryCfg) (*discovery, error) {
+	if cfg.TargetOutbound <= 0 {
+		cfg.TargetOutbound = defaultTargetOutbound
+	}
+
+	if cfg.DialInterval <= 0 {
+		cfg.DialInterval = defaultDialInterval
+	}
+
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = defaultMaxFailures
+	}
+
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaultDiscoveryDialTimeout
+	}
+
+	book, err := NewAddressBook(cfg.AddrBookPath)
+	if err != nil {
+		return nil, err
+	}
+
+	seeds := make(map[string]bool, len(cfg.Seeds))
+	for _, seed := range cfg.Seeds {
+		book.Add(seed, AddrSourceSeed)
+		seeds[seed] = true
+	}
+
+	return &discovery{
+		cfg:   cfg,
+		book:  book,
+		seeds: seeds,
+		cli:   cli,
+	}, nil
+}
+
+// onHandshake (IN peer readLoop) record the address from handshake
+func (d *discovery) onHandshake(peer *Peer, msg *HandshakeMessage) {
+	address, ok := ParseP2PAddress(msg.P2PAddress)
+	if !ok || address == peer.Address {
+		return
+	}
+
+	d.book.Add(address, AddrSourceHandshake)
+}
+
+// onDiscoveryTick (IN peerMngLoop) dial new peers if healthy outbound peers not enough,
+// the peers dialing are counted, so no more peers dialed than needed
+func (c *Client) onDiscoveryTick(ctx context.Context) {
+	d := c.discovery
+
+	healthy := 0
+	for _, ps := range c.ps {
+		if ps.status == peerStatNormal || ps.isDialing {
+			healthy++
+		}
+	}
+
+	if healthy < d.cfg.TargetOutbound {
+		now := time.Now()
+		addrs := d.book.candidates(func(info *AddrInfo) bool {
+			if _, ok := c.ps[info.Address]; ok {
+				return false
+			}
+			return !now.Before(d.nextDial(info))
+		}, d.cfg.TargetOutbound-healthy)
+
+		for _, address := range addrs {
+			c.logger.Info("dial discovered peer", zap.String("addr", address))
+			c.onNewPeer(ctx, &peerMsg{
+				msgTyp: peerMsgNewPeer,
+				cfg: &PeerCfg{
+					Address:     address,
+					DialTimeout: d.cfg.DialTimeout,
+				},
+			})
+
+			if ps, ok := c.ps[address]; ok {
+				ps.isDiscovered = true
+			}
+		}
+	}
+
+	if err := d.book.Save(); err != nil {
+		c.logger.Error("save address book error", zap.Error(err))
+	}
+}
+
+// nextDial the time the address can be dialed again, the interval doubles each failure up to maxDialBackoff,
+// the address failed too many times is retried after giveUpRetryInterval, but seeds are never given up
+func (d *discovery) nextDial(info *AddrInfo) time.Time {
+	if info.Failures == 0 {
+		return info.LastAttempt
+	}
+
+	if d.isFailedTooMany(info) {
+		return info.LastAttempt.Add(giveUpRetryInterval)
+	}
+
+	backoff := maxDialBackoff
+	if shift := info.Failures - 1; shift < 32 {
+		if interval := d.cfg.DialInterval << uint(shift); interval > 0 && interval < maxDialBackoff {
+			backoff = interval
+		}
+	}
+
+	return info.LastAttempt.Add(backoff)
+}
+
+// isGiveUp if a discovered peer failed too many times no need reconnect soon, seeds are never given up
+func (d *discovery) isGiveUp(address string) bool {
+	info, ok := d.book.Get(address)
+	return ok && d.isFailedTooMany(&info)
+}
+
+// isFailedTooMany the address not in seeds failed MaxFailures times continuously
+func (d *discovery) isFailedTooMany(info *AddrInfo) bool {
+	return !d.seeds[info.Address] && info.Failures >= d.cfg.MaxFailures
+}
+
+// AddressBook get the address book, nil if discovery not enabled
+func (c *Client) AddressBook() *AddressBook {
+	if c.discovery == nil {
+		return nil
+	}
+	return c.discovery.book
+}
@@ -0,0 +1,66 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDiscoveryBackoff test the interval to redial doubles each failure, and seeds are never given up
+func TestDiscoveryBackoff(t *testing.T) {
+	d, err := newDiscovery(nil, DiscoveryCfg{
+		Seeds:        []string{"seed:9876"},
+		DialInterval: 10 * time.Second,
+		MaxFailures:  3,
+	})
+	if err != nil {
+		t.Fatalf("new discovery error %s", err.Error())
+	}
+
+	attempt := time.Now()
+	cases := []struct {
+		addr     string
+		failures int
+		backoff  time.Duration
+	}{
+		{"seed:9876", 0, 0},
+		{"seed:9876", 1, 10 * time.Second},
+		{"seed:9876", 3, 40 * time.Second},
+		{"seed:9876", 100, maxDialBackoff},
+		{"peer:9876", 2, 20 * time.Second},
+		{"peer:9876", 3, giveUpRetryInterval},
+	}
+
+	for _, c := range cases {
+		info := &AddrInfo{
+			Address:     c.addr,
+			LastAttempt: attempt,
+			Failures:    c.failures,
+		}
+
+		if next := d.nextDial(info); next.Sub(attempt) != c.backoff {
+			t.Errorf("backoff of %s failed %d times should be %s, got %s",
+				c.addr, c.failures, c.backoff, next.Sub(attempt))
+		}
+	}
+
+	d.book.update("seed:9876", func(info *AddrInfo) { info.Failures = 100 })
+	d.book.update("peer:9876", func(info *AddrInfo) {
+		info.Failures = 3
+		info.LastAttempt = time.Now()
+	})
+	if d.isGiveUp("seed:9876") || !d.isGiveUp("peer:9876") {
+		t.Errorf("only the address not in seeds can be given up")
+	}
+
+	// the address failed can be dialed again after backoff
+	d.book.update("seed:9876", func(info *AddrInfo) {
+		info.Failures = 1
+		info.LastAttempt = time.Now().Add(-time.Minute)
+	})
+	addrs := d.book.candidates(func(info *AddrInfo) bool {
+		return !time.Now().Before(d.nextDial(info))
+	}, 2)
+	if len(addrs) != 1 || addrs[0] != "seed:9876" {
+		t.Errorf("only the seed can be dialed, got %v", addrs)
+	}
+}
@@ -0,0 +1,197 @@
+package p2p_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+)
+
+// TestDiscoveryFromHandshake test client dial the address learned from handshake
+func TestDiscoveryFromHandshake(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 10)
+	srvB := newServerForTest(t, chain)
+	srvA := newServerForTest(t, chain, p2ptest.WithP2PAddress(srvB.Addr()+" - 12345678"))
+	bookPath := filepath.Join(t.TempDir(), "addrbook.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srvA.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithStorer(newStorerForTest(t, logger)),
+		p2p.WithDiscovery(p2p.DiscoveryCfg{
+			TargetOutbound: 2,
+			AddrBookPath:   bookPath,
+			DialInterval:   50 * time.Millisecond,
+		}))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	// client not send handshake if not sync, so send one to get handshake from A
+	waitFor(t, 5*time.Second, func() bool {
+		return len(srvA.Conns()) == 1
+	})
+	srvA.Broadcast(srvA.Handshake())
+
+	waitFor(t, 5*time.Second, func() bool {
+		return len(srvB.Conns()) == 1
+	})
+
+	info, ok := client.AddressBook().Get(srvB.Addr())
+	if !ok || info.Source != p2p.AddrSourceHandshake || info.LastConnected.IsZero() {
+		t.Errorf("address book state error %v", info)
+	}
+
+	cancel()
+	client.Wait()
+
+	book, err := p2p.NewAddressBook(bookPath)
+	if err != nil {
+		t.Fatalf("load address book error %s", err.Error())
+	}
+
+	if _, ok := book.Get(srvB.Addr()); !ok {
+		t.Errorf("address book not persisted")
+	}
+}
+
+// TestParseP2PAddress test parse p2p address from nodeos handshakes
+func TestParseP2PAddress(t *testing.T) {
+	cases := map[string]string{
+		"peer.eos.io:9876 - 1a2b3c4": "peer.eos.io:9876",
+		"127.0.0.1:9876":             "127.0.0.1:9876",
+		"0.0.0.0:9876 - 1a2b3c4":     "",
+		"ClientPeer-1a2b3c4":         "",
+		"":                           "",
+	}
+
+	for p2pAddress, expected := range cases {
+		address, ok := p2p.ParseP2PAddress(p2pAddress)
+		if address != expected || ok != (expected != "") {
+			t.Errorf("parse %s error, got %s", p2pAddress, address)
+		}
+	}
+}
+
+// TestDiscoveryDialNotBlock test the dials to discovered peers not hang, so the peers can be managed
+func TestDiscoveryDialNotBlock(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 10)
+	srv := newServerForTest(t, chain)
+
+	// the seeds never connected, each dial hang until timeout
+	var dials int32
+	dialer := p2p.NewTCPDialer()
+	blackhole := p2p.DialerFunc(func(ctx context.Context, address string) (net.Conn, error) {
+		if address == srv.Addr() {
+			return dialer.DialContext(ctx, address)
+		}
+
+		atomic.AddInt32(&dials, 1)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithStorer(newStorerForTest(t, logger)),
+		p2p.WithDialer(blackhole),
+		p2p.WithDiscovery(p2p.DiscoveryCfg{
+			Seeds:          []string{"10.255.0.1:9876", "10.255.0.2:9876", "10.255.0.3:9876"},
+			TargetOutbound: 4,
+			DialInterval:   50 * time.Millisecond,
+			DialTimeout:    200 * time.Millisecond,
+		}))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+	defer func() {
+		cancel()
+		client.Wait()
+	}()
+
+	waitFor(t, 5*time.Second, func() bool {
+		return atomic.LoadInt32(&dials) >= 1
+	})
+
+	// peerMngLoop is blocked by one dial at most
+	for i := 0; i < 5; i++ {
+		begin := time.Now()
+		if stats := client.PeerStats(); len(stats) == 0 {
+			t.Errorf("peer stats should not be empty")
+		}
+
+		if cost := time.Since(begin); cost > time.Second {
+			t.Fatalf("peer stats blocked by dials %s", cost)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		info, ok := client.AddressBook().Get("10.255.0.1:9876")
+		return ok && info.Failures > 0
+	})
+}
+
+// TestDialFailuresNotBlock test the failures of more peers than the peer chan can hold not block peer manager
+func TestDialFailuresNotBlock(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 10)
+	srv := newServerForTest(t, chain)
+
+	dialer := p2p.NewTCPDialer()
+	refused := p2p.DialerFunc(func(ctx context.Context, address string) (net.Conn, error) {
+		if address == srv.Addr() {
+			return dialer.DialContext(ctx, address)
+		}
+		return nil, errors.New("connection refused")
+	})
+
+	cfgs := []*p2p.PeerCfg{{Address: srv.Addr()}}
+	for i := 0; i < 16; i++ {
+		cfgs = append(cfgs, &p2p.PeerCfg{Address: fmt.Sprintf("10.255.1.%d:9876", i+1)})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest, cfgs,
+		p2p.WithLogger(logger),
+		p2p.WithStorer(newStorerForTest(t, logger)),
+		p2p.WithDialer(refused))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return len(srv.Conns()) == 1
+	})
+
+	stats := make(chan int, 1)
+	go func() {
+		stats <- len(client.PeerStats())
+	}()
+
+	select {
+	case n := <-stats:
+		if n != len(cfgs) {
+			t.Errorf("peer stats should have %d peers, got %d", len(cfgs), n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("peer manager blocked by dial failures")
+	}
+
+	cancel()
+	client.Wait()
+}
@@ -0,0 +1,187 @@
+package p2p
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/store"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// replayPeer the sender of the envelopes made by the blocks replayed from storer,
+// handlers can check it by Peer.IsReplay to know the block is not from network.
+// It is not connected, the Send* methods of it return ErrPeerNotConnected
+var replayPeer = &Peer{
+	Address: "replay",
+	Name:    "replay",
+}
+
+// cursorHandler a handler resumed from its cursor, skip the blocks it had processed
+type cursorHandler struct {
+	Handler
+	lastNum uint32
+}
+
+// Handle implements Handler interface
+func (h *cursorHandler) Handle(envelope *Envelope) {
+	if blk, ok := isBlockMsg(envelope.Packet); ok && blk.BlockNumber() <= h.lastNum {
+		return
+	}
+
+	h.Handler.Handle(envelope)
+}
+
+// newBlockEnvelope make a envelope for the block from storer
+func newBlockEnvelope(blk *SignedBlock) *Envelope {
+	return newEnvelope(replayPeer, &Packet{
+		Type:       blk.GetType(),
+		P2PMessage: blk,
+	})
+}
+
+// replayBlocks call handlers by the blocks in [from, to] from storer, return the last block num replayed
+func (c *Client) replayBlocks(ctx context.Context, handlers []Handler, from, to uint32) (uint32, error) {
+	lastNum := from - 1
+	for num := from; num <= to; num++ {
+		if err := ctx.Err(); err != nil {
+			return lastNum, err
+		}
+
+		blk, ok := c.blkStorer.GetBlockByNum(num)
+		if !ok {
+			return lastNum, errors.Errorf("block %d not found in storer", num)
+		}
+
+		envelope := newBlockEnvelope(blk)
+		for _, h := range handlers {
+			h.Handle(envelope)
+		}
+		lastNum = num
+	}
+
+	return lastNum, nil
+}
+
+// checkCursor check the handler can be resumed from its cursor, the cursor should be in the chain of storer
+// and the blocks after it should be in storer, so the handler never get blocks with a gap, return false if no cursor
+func (c *Client) checkCursor(h Handler) (store.Cursor, bool, error) {
+	cs, ok := c.blkStorer.(store.CursorStorer)
+	if !ok {
+		return store.Cursor{}, false, nil
+	}
+
+	cursor, ok, err := cs.GetCursor(h.Name())
+	if err != nil {
+		return cursor, false, errors.Wrapf(err, "get cursor of handler %s", h.Name())
+	}
+
+	if !ok {
+		return cursor, false, nil
+	}
+
+	if len(cursor.BlockID) > 0 {
+		if blk, ok := c.blkStorer.GetBlockByNum(cursor.BlockNum); ok {
+			if id, _ := blk.BlockID(); !types.IsChecksumEq(id, cursor.BlockID) {
+				return cursor, false, errors.Errorf("cursor %d %s of handler %s not in chain of storer",
+					cursor.BlockNum, cursor.BlockID, h.Name())
+			}
+		}
+	}
+
+	if c.HeadBlockNum() > cursor.BlockNum {
+		if _, ok := c.blkStorer.GetBlockByNum(cursor.BlockNum + 1); !ok {
+			return cursor, false, errors.Errorf("block %d after cursor of handler %s not found in storer",
+				cursor.BlockNum+1, h.Name())
+		}
+	}
+
+	return cursor, true, nil
+}
+
+// resumeHandler (IN peerLoop) replay the blocks after the cursor of handler to storer head,
+// the handler will skip the blocks before the last one replayed
+func (c *Client) resumeHandler(h Handler) (Handler, error) {
+	if ch, ok := h.(*cursorHandler); ok {
+		h = ch.Handler
+	}
+
+	cursor, ok, err := c.checkCursor(h)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return h, nil
+	}
+
+	headNum := c.HeadBlockNum()
+	lastNum := cursor.BlockNum
+	if headNum > cursor.BlockNum {
+		c.logger.Info("replay blocks to handler",
+			zap.String("handler", h.Name()),
+			zap.Uint32("from", cursor.BlockNum+1), zap.Uint32("to", headNum))
+
+		lastNum, err = c.replayBlocks(context.Background(), []Handler{h}, cursor.BlockNum+1, headNum)
+		if err != nil {
+			return nil, errors.Wrapf(err, "replay blocks to handler %s", h.Name())
+		}
+	}
+
+	return &cursorHandler{
+		Handler: h,
+		lastNum: lastNum,
+	}, nil
+}
+
+// checkHandlers check all handlers can be resumed by their cursors before client started
+func (c *Client) checkHandlers() error {
+	for _, h := range c.handlers {
+		if _, _, err := c.checkCursor(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeHandlers (IN peerLoop) resume all handlers by their cursors before process packets,
+// the cursors are checked by NewClient, so the handler is removed only if the storer failed
+func (c *Client) resumeHandlers() {
+	handlers := c.handlers[:0]
+	for _, h := range c.handlers {
+		resumed, err := c.resumeHandler(h)
+		if err != nil {
+			c.logger.Error("resume handler error, handler removed",
+				zap.String("name", h.Name()), zap.Error(err))
+			continue
+		}
+		handlers = append(handlers, resumed)
+	}
+	c.handlers = handlers
+}
+
+// AckBlock persist the block as the cursor of the handler, when client restarted, the blocks after
+// the cursor in storer will be replayed to the handler before live blocks, if the cursor is not in
+// the chain of storer or the blocks after it not in storer, NewClient and AddHandler return the error
+func (c *Client) AckBlock(handlerName string, blockNum uint32, blockID Checksum256) error {
+	cs, ok := c.blkStorer.(store.CursorStorer)
+	if !ok {
+		return errors.New("storer not support cursors")
+	}
+
+	return cs.SetCursor(handlerName, store.Cursor{
+		BlockNum: blockNum,
+		BlockID:  blockID,
+	})
+}
+
+// HandlerCursor get the cursor of the handler
+func (c *Client) HandlerCursor(handlerName string) (store.Cursor, bool, error) {
+	cs, ok := c.blkStorer.(store.CursorStorer)
+	if !ok {
+		return store.Cursor{}, false, errors.New("storer not support cursors")
+	}
+
+	return cs.GetCursor(handlerName)
+}
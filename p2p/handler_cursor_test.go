@@ -0,0 +1,222 @@
+package p2p_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+	"github.com/fanyang1988/eos-p2p/store"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// runCursorForTest sync from the server by a handler which acks blocks <= ackNum, return the blocks it got
+func runCursorForTest(t *testing.T, dbPath string, srv *p2ptest.Server, ackNum uint32) []uint32 {
+	logger := zap.NewNop()
+	storer, err := store.NewBBoltStorer(logger, chainIDForTest, dbPath, true)
+	if err != nil {
+		t.Fatalf("new storer error %s", err.Error())
+	}
+	defer storer.Close()
+
+	var (
+		mutex  sync.Mutex
+		nums   []uint32
+		client *p2p.Client
+	)
+
+	ready := make(chan struct{})
+	handler := p2p.NewHandlerFunc("indexer", func(envelope *p2p.Envelope) {
+		blk, ok := envelope.Packet.P2PMessage.(*p2p.SignedBlock)
+		if !ok {
+			return
+		}
+
+		mutex.Lock()
+		nums = append(nums, blk.BlockNumber())
+		mutex.Unlock()
+
+		<-ready
+		if blk.BlockNumber() <= ackNum {
+			id, _ := blk.BlockID()
+			if err := client.AckBlock("indexer", blk.BlockNumber(), id); err != nil {
+				t.Errorf("ack block error %s", err.Error())
+			}
+		}
+	})
+
+	client, err = p2p.NewClient(context.Background(), chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer),
+		p2p.WithHandler(handler))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+	close(ready)
+
+	headNum := srv.Chain().HeadBlockNum()
+	waitFor(t, 10*time.Second, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(nums) > 0 && nums[len(nums)-1] == headNum
+	})
+
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error %s", err.Error())
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return nums
+}
+
+// TestHandlerCursorReplay test the blocks after the cursor of handler are replayed once after restarted
+func TestHandlerCursorReplay(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "blocks.db")
+
+	chain := newChainForTest(t, 120)
+	runCursorForTest(t, dbPath, newServerForTest(t, chain), 50)
+
+	longer, err := chain.Extend(130)
+	if err != nil {
+		t.Fatalf("extend chain error %s", err.Error())
+	}
+
+	// the blocks in (50, 120] from storer, then (120, 130] from peer
+	nums := runCursorForTest(t, dbPath, newServerForTest(t, longer), 130)
+	if len(nums) != 80 {
+		t.Fatalf("handler should get 80 blocks, got %d", len(nums))
+	}
+
+	for idx, num := range nums {
+		if num != uint32(51+idx) {
+			t.Fatalf("block %d should be %d, got %d", idx, 51+idx, num)
+		}
+	}
+}
+
+// TestHandlerCursorNotInChain test the handler cannot be added if its cursor is not in the chain of storer
+func TestHandlerCursorNotInChain(t *testing.T) {
+	logger := zap.NewNop()
+	dbPath := filepath.Join(t.TempDir(), "blocks.db")
+
+	chain := newChainForTest(t, 120)
+	runCursorForTest(t, dbPath, newServerForTest(t, chain), 50)
+
+	storer, err := store.NewBBoltStorer(logger, chainIDForTest, dbPath, true)
+	if err != nil {
+		t.Fatalf("new storer error %s", err.Error())
+	}
+	defer storer.Close()
+
+	// the cursor acked by a block in other fork
+	if err := storer.SetCursor("indexer", store.Cursor{
+		BlockNum: 50,
+		BlockID:  types.MustNewChecksum256("00000032" + strings.Repeat("ab", 28)),
+	}); err != nil {
+		t.Fatalf("set cursor error %s", err.Error())
+	}
+
+	var (
+		mutex sync.Mutex
+		nums  []uint32
+	)
+
+	handler := p2p.NewHandlerFunc("indexer", func(envelope *p2p.Envelope) {
+		if blk, ok := envelope.Packet.P2PMessage.(*p2p.SignedBlock); ok {
+			mutex.Lock()
+			nums = append(nums, blk.BlockNumber())
+			mutex.Unlock()
+		}
+	})
+
+	longer, err := chain.Extend(130)
+	if err != nil {
+		t.Fatalf("extend chain error %s", err.Error())
+	}
+	srv := newServerForTest(t, longer)
+
+	if _, err := p2p.NewClient(context.Background(), chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer),
+		p2p.WithHandler(handler)); err == nil {
+		t.Fatalf("new client with the handler not in chain should fail")
+	}
+
+	client, err := p2p.NewClient(context.Background(), chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	if err := client.AddHandler(handler); err == nil {
+		t.Errorf("add the handler not in chain should fail")
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 130
+	})
+
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error %s", err.Error())
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(nums) != 0 {
+		t.Errorf("handler should get no blocks, got %d", len(nums))
+	}
+}
+
+// TestHandlerCursorBlocksMissing test the handler cannot be added if the blocks after its cursor not in storer
+func TestHandlerCursorBlocksMissing(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 120))
+	storer := newStorerForTest(t, logger)
+
+	cpBlk, _ := srv.Chain().GetBlockByNum(80)
+	cpID, _ := cpBlk.BlockID()
+
+	client, err := p2p.NewClient(context.Background(), chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithCheckpoint(store.Checkpoint{
+			BlockNum: 80,
+			BlockID:  cpID,
+		}),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 120
+	})
+
+	// the blocks before checkpoint not synced
+	if err := client.AckBlock("indexer", 50, nil); err != nil {
+		t.Fatalf("ack block error %s", err.Error())
+	}
+
+	if err := client.AddHandler(p2p.NewHandlerFunc("indexer", func(envelope *p2p.Envelope) {})); err == nil {
+		t.Errorf("add the handler with blocks missing should fail")
+	}
+
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error %s", err.Error())
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"context"
 	"crypto/rand"
-	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -19,6 +18,9 @@ import (
 	"github.com/fanyang1988/eos-p2p/types"
 )
 
+// defaultDialTimeout timeout to connect peer if not set in cfg
+const defaultDialTimeout = 5 * time.Second
+
 // Peer a p2p peer to other
 type Peer struct {
 	Address           string
@@ -28,9 +30,21 @@ type Peer struct {
 	connection        net.Conn
 	reader            io.Reader
 	connectionTimeout time.Duration
+	dialer            Dialer
 	cli               *Client
 	wg                *sync.WaitGroup
 
+	mutex         sync.RWMutex
+	writer        *peerWriter
+	writeTimeout  time.Duration
+	sendQueueSize int
+
+	idleTimeout   time.Duration
+	rtt           time.Duration
+	clockOffset   time.Duration
+	lastRecv      time.Time
+	lastHeartbeat time.Time
+
 	lastHandshakeSend  *types.HandshakeMessage
 	lastHandshakeRecv  *types.HandshakeMessage
 	sendHandshakeCount int16
@@ -40,6 +54,15 @@ type Peer struct {
 type PeerCfg struct {
 	Name    string `json:"name"`
 	Address string `json:"addr"`
+
+	// WriteTimeout timeout for each msg write to peer, default 10s
+	WriteTimeout time.Duration `json:"writeTimeout"`
+	// SendQueueSize max msgs waiting to write to peer, default 1024
+	SendQueueSize int `json:"sendQueueSize"`
+	// Dialer dialer to connect the peer, use the dialer of client if nil
+	Dialer Dialer `json:"-"`
+	// DialTimeout timeout to connect the peer, default 5s
+	DialTimeout time.Duration `json:"dialTimeout"`
 }
 
 // MarshalLogObject calls the underlying function from zap.
@@ -70,30 +93,65 @@ func NewPeer(cfg *PeerCfg, cli *Client, headBlockNum uint32, chainID Checksum256
 		Address:           cfg.Address,
 		agent:             name,
 		Name:              name,
-		connectionTimeout: 5 * time.Second,
+		connectionTimeout: cfg.DialTimeout,
 		wg:                &sync.WaitGroup{},
 		cli:               cli,
+		writeTimeout:      cfg.WriteTimeout,
+		sendQueueSize:     cfg.SendQueueSize,
+		idleTimeout:       cli.heartbeat.IdleTimeout,
+		dialer:            cfg.Dialer,
+	}
+
+	if res.dialer == nil {
+		res.dialer = cli.dialer
+	}
+
+	if res.dialer == nil {
+		res.dialer = NewTCPDialer()
+	}
+
+	if res.connectionTimeout <= 0 {
+		res.connectionTimeout = defaultDialTimeout
+	}
+
+	if res.writeTimeout <= 0 {
+		res.writeTimeout = defaultWriteTimeout
+	}
+
+	if res.sendQueueSize <= 0 {
+		res.sendQueueSize = defaultSendQueueSize
 	}
 
 	return res, nil
 }
 
-// SetConnectionTimeout for net DialTimeout
+// SetConnectionTimeout timeout for dial to peer
 func (p *Peer) SetConnectionTimeout(timeout time.Duration) {
 	p.connectionTimeout = timeout
 }
 
 func (p *Peer) Read() (*Packet, error) {
+	if err := p.setReadDeadline(); err != nil {
+		return nil, errors.Wrapf(err, "connection: set read deadline %s err", p.Address)
+	}
+
 	packet, err := types.ReadChainPacket(p.reader, p.connection)
 	if err != nil {
 		return nil, errors.Wrapf(err, "connection: read %s err", p.Address)
 	}
 
+	p.mutex.Lock()
+	p.lastRecv = time.Now()
+	p.mutex.Unlock()
+
 	return packet, nil
 }
 
-func (p *Peer) connect() error {
-	conn, err := net.DialTimeout("tcp", p.Address, p.connectionTimeout)
+func (p *Peer) connect(ctx context.Context) error {
+	dialCtx, cancel := context.WithTimeout(ctx, p.connectionTimeout)
+	defer cancel()
+
+	conn, err := p.dialer.DialContext(dialCtx, p.Address)
 	if err != nil {
 		return errors.Wrapf(err, "peer connect error %s", p.Address)
 	}
@@ -101,6 +159,10 @@ func (p *Peer) connect() error {
 	p.connection = conn
 	p.reader = bufio.NewReader(p.connection)
 
+	p.mutex.Lock()
+	p.writer = newPeerWriter(p, conn, p.writeTimeout, p.sendQueueSize)
+	p.mutex.Unlock()
+
 	return nil
 }
 
@@ -109,14 +171,20 @@ func (p *Peer) Start(ctx context.Context) error {
 	address2log := zap.String("address", p.Address)
 
 	p.cli.logger.Info("Dialing", address2log, zap.Duration("timeout", p.connectionTimeout))
-	err := p.connect()
+	err := p.connect(ctx)
 	if err != nil {
 		return err
 	}
 
+	w := p.currWriter()
+
+	p.wg.Add(2)
+	go func() {
+		defer p.wg.Done()
+		w.loop()
+	}()
 	go func() {
-		p.wg.Add(1)
-		p.readLoop()
+		p.readLoop(w)
 	}()
 
 	return nil
@@ -124,12 +192,33 @@ func (p *Peer) Start(ctx context.Context) error {
 
 // Close send GoAway message then close connection
 func (p *Peer) Close(reason GoAwayReason) error {
-	p.SendGoAway(reason)
+	p.sendGoAwayAndWait(reason)
 	return p.ClosePeer()
 }
 
+// sendGoAwayAndWait send GoAway message and wait it written
+func (p *Peer) sendGoAwayAndWait(reason GoAwayReason) {
+	if p.IsReplay() {
+		return
+	}
+
+	p.cli.logger.Debug("SendGoAway", zap.String("reason", reason.String()))
+
+	err := p.WriteP2PMessageAndWait(&GoAwayMessage{
+		Reason: reason,
+		NodeID: p.NodeID,
+	}, p.writeTimeout)
+	if err != nil {
+		p.cli.logger.Debug("send go away error", zap.String("peer", p.Address), zap.Error(err))
+	}
+}
+
 // ClosePeer close peer connect
 func (p *Peer) ClosePeer() error {
+	if w := p.currWriter(); w != nil {
+		w.stop()
+	}
+
 	if p.connection != nil {
 		return p.connection.Close()
 	}
@@ -142,12 +231,12 @@ func (p *Peer) Wait() {
 	p.wg.Wait()
 }
 
-func (p *Peer) readLoop() {
+func (p *Peer) readLoop(w *peerWriter) {
 	defer func() {
 		p.wg.Done()
 		if r := recover(); r != nil {
 			p.cli.logger.Error("peer readLoop panic", zap.String("addr", p.Address))
-			p.cli.packetChan <- newEnvelopMsgWithError(p, errors.Errorf("panic by %v", r))
+			w.fail(errors.Errorf("panic by %v", r))
 		}
 	}()
 
@@ -156,17 +245,17 @@ func (p *Peer) readLoop() {
 
 		if err != nil {
 			//p.cli.logger.Warn("peer readLoop return by read error", zap.String("addr", p.Address), zap.Error(err))
-			p.cli.packetChan <- newEnvelopMsgWithError(p, errors.Wrapf(err, "read message from %s", p.Address))
+			w.fail(errors.Wrapf(err, "read message from %s", p.Address))
 			p.cli.logger.Debug("peer readloop exit", zap.String("address", p.Address))
 			return
 		}
 
 		if err := p.onMsg(packet); err != nil {
-			p.cli.packetChan <- newEnvelopMsgWithError(p, errors.Wrapf(err, "peer process message from %s", p.Address))
+			w.fail(errors.Wrapf(err, "peer process message from %s", p.Address))
 			p.cli.logger.Debug("peer readloop exit", zap.String("address", p.Address))
 			return
 		}
-		p.cli.packetChan <- newEnvelopMsg(p, packet)
+		p.cli.postEnvelopMsg(newEnvelopMsg(p, packet))
 	}
 }
 
@@ -187,12 +276,65 @@ func (p *Peer) onMsg(msg *types.Packet) error {
 		if ok && goAwayMsg != nil {
 			p.onGoAwayMsg(goAwayMsg)
 		}
+	case *TimeMessage:
+		timeMsg, ok := msg.P2PMessage.(*TimeMessage)
+		if ok && timeMsg != nil {
+			p.onTimeMsg(timeMsg)
+		}
 	}
 	return nil
 }
 
 func (p *Peer) onHandshakeMsg(msg *HandshakeMessage) {
+	p.mutex.Lock()
 	p.lastHandshakeRecv = msg
+	p.mutex.Unlock()
+
+	if !types.IsChecksumEq(msg.ChainID, p.cli.ChainID()) {
+		p.cli.logger.Warn("peer handshake with wrong chain",
+			zap.String("addr", p.Address), zap.String("chainID", msg.ChainID.String()))
+		// report before close so the peer is banned before reconnect
+		p.sendGoAwayAndWait(goAwayWrongChain)
+		p.cli.reportPeer(p, PeerEventWrongChain)
+		p.ClosePeer()
+		return
+	}
+
+	if p.cli.isPeerBanned(p) {
+		p.cli.logger.Info("peer node is banned", zap.String("addr", p.Address))
+		p.ClosePeer()
+		return
+	}
+	if p.cli.discovery != nil {
+		p.cli.discovery.onHandshake(p, msg)
+	}
+}
+
+// headNumRecv head num from handshake received, 0 if no handshake
+func (p *Peer) headNumRecv() uint32 {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.lastHandshakeRecv == nil {
+		return 0
+	}
+	return p.lastHandshakeRecv.HeadNum
+}
+
+// handshakeRecv the last handshake received, nil if no handshake
+func (p *Peer) handshakeRecv() *HandshakeMessage {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.lastHandshakeRecv
+}
+
+// handshakeSent is handshake had sent to peer
+func (p *Peer) handshakeSent() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.lastHandshakeSend != nil
 }
 
 func (p *Peer) onGoAwayMsg(msg *GoAwayMessage) {
@@ -200,10 +342,8 @@ func (p *Peer) onGoAwayMsg(msg *GoAwayMessage) {
 }
 
 func (p *Peer) onNoticeMsg(msg *NoticeMessage) {
-	// TODO: fix notice msg mode type
-	switch binary.LittleEndian.Uint32(msg.KnownTrx.Mode[:]) {
-	case 1:
-		p.cli.logger.Debug("recv trx catch_up notice")
-	default:
-	}
+	p.cli.logger.Debug("recv notice",
+		zap.String("peer", p.Address),
+		zap.Stringer("trx", types.GetIDListMode(&msg.KnownTrx)),
+		zap.Stringer("blk", types.GetIDListMode(&msg.KnownBlocks)))
 }
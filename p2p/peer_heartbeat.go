@@ -0,0 +1,178 @@
+package p2p
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHeartbeatInterval = 30 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+// HeartbeatCfg config for heartbeat by TimeMessage
+type HeartbeatCfg struct {
+	// Interval interval to send TimeMessage to all peers, default if 0, no heartbeat if < 0
+	Interval time.Duration
+	// IdleTimeout close peer if nothing received in IdleTimeout, default if 0, no timeout if < 0
+	IdleTimeout time.Duration
+}
+
+// PeerStats stats for a peer
+type PeerStats struct {
+	Address string `json:"addr"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+
+	// RTT round-trip latency by last TimeMessage
+	RTT time.Duration `json:"rtt"`
+	// ClockOffset the clock of peer minus local clock
+	ClockOffset time.Duration `json:"offset"`
+
+	LastRecv      time.Time `json:"lastRecv"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+
+	HeadNum                  uint32 `json:"head"`
+	LastIrreversibleBlockNum uint32 `json:"lib"`
+
+	Score int `json:"score"`
+}
+
+var peerStatusNames = map[peerStatusTyp]string{
+	peerStatNormal:  "normal",
+	peerStatInit:    "init",
+	peerStatError:   "error",
+	peerStatClosed:  "closed",
+	peerStatWaiting: "waiting",
+}
+
+func isTstampEmpty(t Tstamp) bool {
+	return t.UnixNano() <= 0
+}
+
+// emptyTstamp a Tstamp encoded as zero
+func emptyTstamp() Tstamp {
+	return Tstamp{Time: time.Unix(0, 0)}
+}
+
+// onTimeMsg (IN readLoop) set destination stamp, and update rtt if it is a reply for our time msg
+func (p *Peer) onTimeMsg(msg *TimeMessage) {
+	msg.Destination = Tstamp{Time: time.Now()}
+
+	if isTstampEmpty(msg.Transmit) || isTstampEmpty(msg.Origin) {
+		// a request from peer, or an invalid msg
+		return
+	}
+
+	// see ntp: rtt = (dst - org) - (xmt - rec), offset = ((rec - org) + (xmt - dst)) / 2
+	org := msg.Origin.UnixNano()
+	rec := msg.Receive.UnixNano()
+	xmt := msg.Transmit.UnixNano()
+	dst := msg.Destination.UnixNano()
+
+	rtt := time.Duration((dst - org) - (xmt - rec))
+	offset := time.Duration(((rec - org) + (xmt - dst)) / 2)
+
+	p.mutex.Lock()
+	p.rtt = rtt
+	p.clockOffset = offset
+	p.mutex.Unlock()
+}
+
+// sendHeartbeat send an original TimeMessage to peer
+func (p *Peer) sendHeartbeat() error {
+	p.mutex.Lock()
+	p.lastHeartbeat = time.Now()
+	p.mutex.Unlock()
+
+	return errors.WithStack(p.WriteP2PMessage(&TimeMessage{
+		Origin:      emptyTstamp(),
+		Receive:     emptyTstamp(),
+		Transmit:    Tstamp{Time: time.Now()},
+		Destination: emptyTstamp(),
+	}))
+}
+
+// setReadDeadline set the read deadline by idle timeout before each read
+func (p *Peer) setReadDeadline() error {
+	if p.idleTimeout <= 0 {
+		return nil
+	}
+
+	return p.connection.SetReadDeadline(time.Now().Add(p.idleTimeout))
+}
+
+// Stats get stats of the peer
+func (p *Peer) Stats() PeerStats {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	res := PeerStats{
+		Address:       p.Address,
+		Name:          p.Name,
+		RTT:           p.rtt,
+		ClockOffset:   p.clockOffset,
+		LastRecv:      p.lastRecv,
+		LastHeartbeat: p.lastHeartbeat,
+	}
+
+	if hs := p.lastHandshakeRecv; hs != nil {
+		res.HeadNum = hs.HeadNum
+		res.LastIrreversibleBlockNum = hs.LastIrreversibleBlockNum
+	}
+
+	return res
+}
+
+// isTimeoutErr is err a net timeout, which means peer is idle too long
+func isTimeoutErr(err error) bool {
+	netErr, ok := errors.Cause(err).(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// onHeartbeatTick (IN peerMngLoop) send heartbeat to all peers connected
+func (c *Client) onHeartbeatTick() {
+	for _, ps := range c.ps {
+		if ps.status != peerStatNormal {
+			continue
+		}
+
+		if err := ps.peer.sendHeartbeat(); err != nil {
+			c.logger.Debug("send heartbeat error", zap.String("peer", ps.peer.Address), zap.Error(err))
+		}
+	}
+}
+
+// onPeerStats (IN peerMngLoop) collect stats for all peers
+func (c *Client) onPeerStats(msg *peerMsg) {
+	res := make([]PeerStats, 0, len(c.ps))
+	for _, ps := range c.ps {
+		stat := ps.peer.Stats()
+		stat.Status = peerStatusNames[ps.status]
+		stat.Score = c.scorer.score(ps.peer.Address)
+		res = append(res, stat)
+	}
+
+	msg.statsResp <- res
+}
+
+// PeerStats get stats for all peers
+func (c *Client) PeerStats() []PeerStats {
+	resp := make(chan []PeerStats, 1)
+	if !c.postPeerMsg(peerMsg{
+		msgTyp:    peerMsgStats,
+		statsResp: resp,
+	}) {
+		return nil
+	}
+
+	select {
+	case res := <-resp:
+		return res
+	case <-c.mngDone:
+		return nil
+	}
+}
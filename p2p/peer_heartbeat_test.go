@@ -0,0 +1,76 @@
+package p2p_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+)
+
+// TestHeartbeatRTT test client send heartbeat and get rtt from the reply
+func TestHeartbeatRTT(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 10), p2ptest.WithLogger(logger))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(newStorerForTest(t, logger)),
+		p2p.WithHeartbeat(p2p.HeartbeatCfg{
+			Interval:    50 * time.Millisecond,
+			IdleTimeout: time.Second,
+		}))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		stats := client.PeerStats()
+		return len(stats) == 1 && stats[0].RTT > 0 && stats[0].HeadNum == 10
+	})
+
+	stats := client.PeerStats()
+	if stats[0].Status != "normal" || stats[0].LastRecv.IsZero() {
+		t.Errorf("peer stats error %v", stats[0])
+	}
+
+	cancel()
+	client.Wait()
+
+	if client.PeerStats() != nil {
+		t.Errorf("no stats after client stopped")
+	}
+}
+
+// TestIdleTimeout test peer not sending anything will be closed and scored
+func TestIdleTimeout(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 10),
+		p2ptest.WithLogger(logger),
+		p2ptest.WithBehavior(p2ptest.BehaviorStall))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithStorer(newStorerForTest(t, logger)),
+		p2p.WithHeartbeat(p2p.HeartbeatCfg{
+			IdleTimeout: 100 * time.Millisecond,
+		}))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return client.PeerScore(srv.Addr()) < 0
+	})
+
+	cancel()
+	client.Wait()
+}
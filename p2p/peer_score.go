@@ -0,0 +1,370 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PeerEvent event which changes the score of a peer
+type PeerEvent uint8
+
+const (
+	// PeerEventUsefulBlock peer delivered a block we need
+	PeerEventUsefulBlock = PeerEvent(iota)
+	// PeerEventInvalidBlock peer sent a block which cannot link to our chain
+	PeerEventInvalidBlock
+	// PeerEventUnrequestedBlock peer sent a block we not requested
+	PeerEventUnrequestedBlock
+	// PeerEventTimeout peer not respond in time
+	PeerEventTimeout
+	// PeerEventDecodeFailure peer sent data which cannot decode
+	PeerEventDecodeFailure
+	// PeerEventWrongChain peer handshake with a diff chain id
+	PeerEventWrongChain
+)
+
+var peerEventNames = map[PeerEvent]string{
+	PeerEventUsefulBlock:      "useful block",
+	PeerEventInvalidBlock:     "invalid block",
+	PeerEventUnrequestedBlock: "unrequested block",
+	PeerEventTimeout:          "timeout",
+	PeerEventDecodeFailure:    "decode failure",
+	PeerEventWrongChain:       "wrong chain",
+}
+
+func (e PeerEvent) String() string {
+	if name, ok := peerEventNames[e]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ScoreCfg config for peer scoring, the zero fields use the default
+type ScoreCfg struct {
+	// EventScores score delta for each event, use default for events not in map
+	EventScores map[PeerEvent]int
+	// MaxScore the max score a peer can reach by useful events
+	MaxScore int
+	// DisconnectThreshold disconnect peer when score is lower or equal
+	DisconnectThreshold int
+	// BanThreshold ban peer when score is lower or equal
+	BanThreshold int
+	// BanDuration how long a ban lasts
+	BanDuration time.Duration
+	// DecayInterval scores move one point toward 0 each interval, so peers can recover from old events
+	DecayInterval time.Duration
+}
+
+// DefaultScoreCfg default config for peer scoring
+func DefaultScoreCfg() ScoreCfg {
+	return ScoreCfg{
+		EventScores: map[PeerEvent]int{
+			PeerEventUsefulBlock:      1,
+			PeerEventInvalidBlock:     -50,
+			PeerEventUnrequestedBlock: -5,
+			PeerEventTimeout:          -20,
+			PeerEventDecodeFailure:    -40,
+			PeerEventWrongChain:       -100,
+		},
+		MaxScore:            100,
+		DisconnectThreshold: -50,
+		BanThreshold:        -100,
+		BanDuration:         time.Hour,
+		DecayInterval:       time.Minute,
+	}
+}
+
+// BanInfo a ban for an address or node id
+type BanInfo struct {
+	// Key the address or hex node id banned
+	Key    string    `json:"key"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// scoreAction what need to do after a event
+type scoreAction uint8
+
+const (
+	scoreActionNone = scoreAction(iota)
+	scoreActionDisconnect
+	scoreActionBan
+)
+
+// peerScore the score of a peer and the time it decayed to
+type peerScore struct {
+	score   int
+	decayed time.Time
+}
+
+// decay move the score toward 0 by the intervals passed
+func (p *peerScore) decay(now time.Time, interval time.Duration) {
+	steps := int(now.Sub(p.decayed) / interval)
+	if steps <= 0 {
+		return
+	}
+	p.decayed = p.decayed.Add(time.Duration(steps) * interval)
+
+	switch {
+	case p.score > steps:
+		p.score -= steps
+	case p.score < -steps:
+		p.score += steps
+	default:
+		p.score = 0
+	}
+}
+
+// peerScorer scores for all peers by address, and bans by address or node id
+type peerScorer struct {
+	cfg    ScoreCfg
+	mutex  sync.Mutex
+	scores map[string]*peerScore
+	bans   map[string]*BanInfo
+}
+
+func newPeerScorer(cfg ScoreCfg) *peerScorer {
+	defaultCfg := DefaultScoreCfg()
+	for e, s := range defaultCfg.EventScores {
+		if _, ok := cfg.EventScores[e]; !ok {
+			if cfg.EventScores == nil {
+				cfg.EventScores = make(map[PeerEvent]int, len(defaultCfg.EventScores))
+			}
+			cfg.EventScores[e] = s
+		}
+	}
+
+	if cfg.MaxScore == 0 {
+		cfg.MaxScore = defaultCfg.MaxScore
+	}
+
+	if cfg.DisconnectThreshold == 0 {
+		cfg.DisconnectThreshold = defaultCfg.DisconnectThreshold
+	}
+
+	if cfg.BanThreshold == 0 {
+		cfg.BanThreshold = defaultCfg.BanThreshold
+	}
+
+	if cfg.BanDuration <= 0 {
+		cfg.BanDuration = defaultCfg.BanDuration
+	}
+
+	if cfg.DecayInterval <= 0 {
+		cfg.DecayInterval = defaultCfg.DecayInterval
+	}
+
+	return &peerScorer{
+		cfg:    cfg,
+		scores: make(map[string]*peerScore, 64),
+		bans:   make(map[string]*BanInfo, 16),
+	}
+}
+
+// add add event to peer score, return the action to do
+func (s *peerScorer) add(address string, event PeerEvent) (int, scoreAction) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ps := s.current(address)
+	if ps == nil {
+		ps = &peerScore{decayed: time.Now()}
+		s.scores[address] = ps
+	}
+
+	score := ps.score + s.cfg.EventScores[event]
+	if score > s.cfg.MaxScore {
+		score = s.cfg.MaxScore
+	}
+	ps.score = score
+
+	switch {
+	case score <= s.cfg.BanThreshold:
+		return score, scoreActionBan
+	case score <= s.cfg.DisconnectThreshold:
+		return score, scoreActionDisconnect
+	}
+	return score, scoreActionNone
+}
+
+// current the score of address decayed to now, nil if no score, need lock
+func (s *peerScorer) current(address string) *peerScore {
+	ps, ok := s.scores[address]
+	if !ok {
+		return nil
+	}
+
+	ps.decay(time.Now(), s.cfg.DecayInterval)
+	return ps
+}
+
+func (s *peerScorer) score(address string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ps := s.current(address); ps != nil {
+		return ps.score
+	}
+	return 0
+}
+
+// isUnderDisconnect is the score of address not higher than the disconnect threshold
+func (s *peerScorer) isUnderDisconnect(address string) bool {
+	return s.score(address) <= s.cfg.DisconnectThreshold
+}
+
+func (s *peerScorer) ban(key string, reason string, duration time.Duration) {
+	if key == "" {
+		return
+	}
+
+	if duration <= 0 {
+		duration = s.cfg.BanDuration
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.bans[key] = &BanInfo{
+		Key:    key,
+		Reason: reason,
+		Until:  time.Now().Add(duration),
+	}
+}
+
+func (s *peerScorer) isBanned(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, ok := s.bans[key]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(b.Until) {
+		delete(s.bans, key)
+		return false
+	}
+
+	return true
+}
+
+func (s *peerScorer) clearBan(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.bans[key]
+	delete(s.bans, key)
+
+	// give peer a new start
+	delete(s.scores, key)
+
+	return ok
+}
+
+func (s *peerScorer) list() []BanInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	res := make([]BanInfo, 0, len(s.bans))
+	for key, b := range s.bans {
+		if now.After(b.Until) {
+			delete(s.bans, key)
+			continue
+		}
+		res = append(res, *b)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+
+	return res
+}
+
+// reportPeer report a event for peer, the peer will be disconnected or banned if score is too low
+func (c *Client) reportPeer(peer *Peer, event PeerEvent) {
+	score, action := c.scorer.add(peer.Address, event)
+
+	c.logger.Debug("peer event",
+		zap.String("peer", peer.Address),
+		zap.String("event", event.String()),
+		zap.Int("score", score))
+
+	switch action {
+	case scoreActionBan:
+		c.logger.Warn("ban peer", zap.String("peer", peer.Address), zap.String("event", event.String()))
+		c.scorer.ban(peer.Address, event.String(), 0)
+		c.scorer.ban(peer.nodeIDRecv(), event.String(), 0)
+		peer.ClosePeer()
+	case scoreActionDisconnect:
+		c.logger.Warn("disconnect peer by low score", zap.String("peer", peer.Address), zap.Int("score", score))
+		peer.ClosePeer()
+	}
+}
+
+// isPeerBanned is peer banned by address or node id
+func (c *Client) isPeerBanned(peer *Peer) bool {
+	return c.scorer.isBanned(peer.Address) || c.scorer.isBanned(peer.nodeIDRecv())
+}
+
+// PeerScore get score of peer by address
+func (c *Client) PeerScore(address string) int {
+	return c.scorer.score(address)
+}
+
+// BanPeer ban a address or hex node id for duration, use the default duration if duration is 0
+func (c *Client) BanPeer(key string, reason string, duration time.Duration) {
+	c.scorer.ban(key, reason, duration)
+
+	c.postPeerMsg(peerMsg{
+		msgTyp: peerMsgBanPeer,
+		cfg: &PeerCfg{
+			Address: key,
+		},
+	})
+}
+
+// Bans list all bans current
+func (c *Client) Bans() []BanInfo {
+	return c.scorer.list()
+}
+
+// ClearBan clear ban by address or hex node id, return false if not banned
+func (c *Client) ClearBan(key string) bool {
+	return c.scorer.clearBan(key)
+}
+
+// ClearAllBans clear all bans
+func (c *Client) ClearAllBans() {
+	for _, b := range c.scorer.list() {
+		c.scorer.clearBan(b.Key)
+	}
+}
+
+// onBanPeer (IN peerMngLoop) close peers banned, the peers dialing are checked when dialed
+func (c *Client) onBanPeer(msg *peerMsg) {
+	for _, ps := range c.ps {
+		if ps.isDialing {
+			continue
+		}
+
+		if ps.peer.Address == msg.cfg.Address || ps.peer.nodeIDRecv() == msg.cfg.Address {
+			ps.peer.ClosePeer()
+		}
+	}
+}
+
+// nodeIDRecv hex node id from handshake received, empty if no handshake
+func (p *Peer) nodeIDRecv() string {
+	hs := p.handshakeRecv()
+	if hs == nil || len(hs.NodeID) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(hs.NodeID)
+}
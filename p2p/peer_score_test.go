@@ -0,0 +1,234 @@
+package p2p_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// TestBanWrongChainPeer test peer handshake with wrong chain is banned and not reconnected
+func TestBanWrongChainPeer(t *testing.T) {
+	logger := zap.NewNop()
+	wrongChainID := types.MustNewChecksum256("aca376f206b8fc25a6ed44dbdc66547c36c6c33e3a119ffbeaef943642f0e906")
+	srv, err := p2ptest.NewServer(wrongChainID, newChainForTest(t, 10))
+	if err != nil {
+		t.Fatalf("new mock peer error %s", err.Error())
+	}
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithStorer(newStorerForTest(t, logger)))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return len(srv.Conns()) == 1
+	})
+	srv.Broadcast(srv.Handshake())
+
+	waitFor(t, 5*time.Second, func() bool {
+		return len(client.Bans()) == 2
+	})
+
+	if client.PeerScore(srv.Addr()) > p2p.DefaultScoreCfg().BanThreshold {
+		t.Errorf("peer score should under ban threshold, got %d", client.PeerScore(srv.Addr()))
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if len(srv.Conns()) != 0 {
+		t.Errorf("banned peer should not reconnect")
+	}
+
+	if !client.ClearBan(srv.Addr()) || client.ClearBan(srv.Addr()) {
+		t.Errorf("clear ban error")
+	}
+
+	cancel()
+	client.Wait()
+}
+
+// peerStatusForTest the status of peer in client, empty if not found
+func peerStatusForTest(client *p2p.Client, address string) string {
+	for _, stat := range client.PeerStats() {
+		if stat.Address == address {
+			return stat.Status
+		}
+	}
+	return ""
+}
+
+// countMsgForTest count the msgs received by server which match the type of msg
+func countMsgForTest(srv *p2ptest.Server, match func(msg types.Message) bool) int {
+	res := 0
+	for _, msg := range srv.Received() {
+		if match(msg) {
+			res++
+		}
+	}
+	return res
+}
+
+func isHandshakeForTest(msg types.Message) bool {
+	_, ok := msg.(*types.HandshakeMessage)
+	return ok
+}
+
+// TestUnrequestedBlockScore test only the blocks out of the outstanding sync request are penalized
+func TestUnrequestedBlockScore(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 100)
+	unrequested := func(num uint32) types.Message {
+		blk, _ := chain.GetBlockByNum(num)
+		return blk
+	}
+
+	var (
+		mutex      sync.Mutex
+		handshakes int
+		requests   int
+	)
+	srv := newServerForTest(t, chain, p2ptest.WithMessageHook(func(c *p2ptest.Conn, msg types.Message) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch msg.(type) {
+		case *types.HandshakeMessage:
+			handshakes++
+			if handshakes == 1 {
+				// no answer, so the peer is selected to sync by timeout before handshake
+				return true
+			}
+
+			if handshakes == 2 {
+				// no request outstanding before our handshake
+				c.Send(unrequested(80))
+			}
+		case *types.SyncRequestMessage:
+			requests++
+			if requests == 1 {
+				c.Send(unrequested(90))
+			}
+		}
+		return false
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithSyncPeerCfg(p2p.SyncPeerCfg{Wait: 100 * time.Millisecond}),
+		p2p.WithScoreCfg(p2p.ScoreCfg{EventScores: map[p2p.PeerEvent]int{p2p.PeerEventUsefulBlock: 0}}),
+		p2p.WithStorer(newStorerForTest(t, logger)))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 100
+	})
+
+	expect := p2p.DefaultScoreCfg().EventScores[p2p.PeerEventUnrequestedBlock]
+	if score := client.PeerScore(srv.Addr()); score != expect {
+		t.Errorf("only the block out of request should be penalized to %d, got %d", expect, score)
+	}
+
+	cancel()
+	client.Wait()
+}
+
+// TestTimeoutPeerWaitReconnect test the peer disconnected by timeouts is not reconnected until its score decayed
+func TestTimeoutPeerWaitReconnect(t *testing.T) {
+	logger := zap.NewNop()
+	// handshake but never answer sync requests
+	srv := newServerForTest(t, newChainForTest(t, 100), p2ptest.WithMessageHook(func(c *p2ptest.Conn, msg types.Message) bool {
+		_, ok := msg.(*types.SyncRequestMessage)
+		return ok
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithSyncStallTimeout(200*time.Millisecond),
+		p2p.WithScoreCfg(p2p.ScoreCfg{
+			EventScores:   map[p2p.PeerEvent]int{p2p.PeerEventTimeout: -60},
+			DecayInterval: 50 * time.Millisecond,
+		}),
+		p2p.WithStorer(newStorerForTest(t, logger)))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return peerStatusForTest(client, srv.Addr()) == "waiting"
+	})
+
+	if n := countMsgForTest(srv, isHandshakeForTest); n != 1 {
+		t.Errorf("peer under disconnect threshold should not reconnect, handshakes %d", n)
+	}
+
+	// score decayed higher than threshold in 11 intervals
+	waitFor(t, 5*time.Second, func() bool {
+		return countMsgForTest(srv, isHandshakeForTest) >= 2
+	})
+
+	cancel()
+	client.Wait()
+}
+
+// TestBanExpire test the banned peer is reconnected after the ban expired
+func TestBanExpire(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 10))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithScoreCfg(p2p.ScoreCfg{DecayInterval: 50 * time.Millisecond}),
+		p2p.WithStorer(newStorerForTest(t, logger)))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return len(srv.Conns()) == 1
+	})
+
+	client.BanPeer(srv.Addr(), "test", 500*time.Millisecond)
+	if bans := client.Bans(); len(bans) != 1 || bans[0].Key != srv.Addr() {
+		t.Fatalf("bans diff %v", bans)
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return peerStatusForTest(client, srv.Addr()) == "waiting"
+	})
+
+	if len(srv.Conns()) != 0 {
+		t.Errorf("banned peer should not reconnect")
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return len(srv.Conns()) == 1
+	})
+
+	if len(client.Bans()) != 0 {
+		t.Errorf("ban should be expired")
+	}
+
+	cancel()
+	client.Wait()
+}
@@ -1,7 +1,6 @@
 package p2p
 
 import (
-	"bytes"
 	"time"
 
 	"github.com/pkg/errors"
@@ -10,31 +9,26 @@ import (
 	"github.com/fanyang1988/eos-p2p/types"
 )
 
-// WriteP2PMessage write a p2p msg to peer
-func (p *Peer) WriteP2PMessage(message Message) (err error) {
-	packet := &Packet{
-		Type:       message.GetType(),
-		P2PMessage: message,
-	}
-
-	buff := bytes.NewBuffer(make([]byte, 0, 512))
-
-	encoder := types.NewChainEncoder(buff)
-	err = encoder.Encode(packet)
-	if err != nil {
-		return errors.Wrapf(err, "unable to encode message %s", message)
-	}
+// IsReplay if the peer is the sender of blocks replayed from storer,
+// it has no connection, so msgs sent to it always fail with ErrPeerNotConnected
+func (p *Peer) IsReplay() bool {
+	return p == replayPeer
+}
 
-	_, err = p.connection.Write(buff.Bytes())
-	if err != nil {
-		return errors.Wrapf(err, "write msg to %s", p.Address)
+// checkSend check the peer can send msgs, the replay peer has no client and connection
+func (p *Peer) checkSend() error {
+	if p.IsReplay() {
+		return errors.Wrapf(ErrPeerNotConnected, "send msg to %s", p.Address)
 	}
-
 	return nil
 }
 
 // SendGoAway send go away message to peer
 func (p *Peer) SendGoAway(reason GoAwayReason) error {
+	if err := p.checkSend(); err != nil {
+		return err
+	}
+
 	p.cli.logger.Debug("SendGoAway", zap.String("reason", reason.String()))
 
 	return errors.WithStack(p.WriteP2PMessage(&GoAwayMessage{
@@ -45,6 +39,10 @@ func (p *Peer) SendGoAway(reason GoAwayReason) error {
 
 // SendSyncRequest send a sync req
 func (p *Peer) SendSyncRequest(startBlockNum uint32, endBlockNumber uint32) (err error) {
+	if err := p.checkSend(); err != nil {
+		return err
+	}
+
 	//p.cli.logger.Debug("SendSyncRequest",
 	//	zap.String("peer", p.Address),
 	//	zap.Uint32("start", startBlockNum),
@@ -58,29 +56,51 @@ func (p *Peer) SendSyncRequest(startBlockNum uint32, endBlockNumber uint32) (err
 	return errors.WithStack(p.WriteP2PMessage(syncRequest))
 }
 
-// SendRequest send req msg for p2p
-func (p *Peer) SendRequest(startBlockNum uint32, endBlockNumber uint32) (err error) {
+// SendRequest request blocks and trxs by ids
+func (p *Peer) SendRequest(blockIDs, trxIDs []Checksum256) error {
+	if err := p.checkSend(); err != nil {
+		return err
+	}
+
 	p.cli.logger.Debug("SendRequest",
 		zap.String("peer", p.Address),
-		zap.Uint32("start", startBlockNum),
-		zap.Uint32("end", endBlockNumber))
+		zap.Int("blocks", len(blockIDs)),
+		zap.Int("trxs", len(trxIDs)))
+
+	return errors.WithStack(p.WriteP2PMessage(types.NewRequest(blockIDs, trxIDs)))
+}
 
-	request := &RequestMessage{
-		ReqTrx: OrderedBlockIDs{
-			Mode:    [4]byte{0, 0, 0, 0},
-			Pending: startBlockNum,
-		},
-		ReqBlocks: OrderedBlockIDs{
-			Mode:    [4]byte{0, 0, 0, 0},
-			Pending: endBlockNumber,
-		},
+// SendCatchupRequest request blocks after headID to the head of peer
+func (p *Peer) SendCatchupRequest(headID Checksum256) error {
+	if err := p.checkSend(); err != nil {
+		return err
 	}
 
-	return errors.WithStack(p.WriteP2PMessage(request))
+	p.cli.logger.Debug("SendCatchupRequest",
+		zap.String("peer", p.Address),
+		zap.String("head", headID.String()))
+
+	return errors.WithStack(p.WriteP2PMessage(types.NewCatchupRequest(headID)))
+}
+
+// SendBlocksRequest request blocks by ids
+func (p *Peer) SendBlocksRequest(ids []Checksum256) error {
+	return p.SendRequest(ids, nil)
 }
 
-// SendNotice send notice msg for p2p
+// SendTrxRequest request trxs by ids
+func (p *Peer) SendTrxRequest(ids []Checksum256) error {
+	return p.SendRequest(nil, ids)
+}
+
+// SendNotice send notice msg for p2p, the head and lib num are sent by the same mode
+//
+// Deprecated: nodeos uses diff modes for the two lists, use SendLastIrrCatchupNotice or SendNoticeHeadCatchup
 func (p *Peer) SendNotice(headBlockNum uint32, libNum uint32, mode byte) error {
+	if err := p.checkSend(); err != nil {
+		return err
+	}
+
 	p.cli.logger.Debug("Send Notice",
 		zap.String("peer", p.Address),
 		zap.Uint32("head", headBlockNum),
@@ -88,42 +108,50 @@ func (p *Peer) SendNotice(headBlockNum uint32, libNum uint32, mode byte) error {
 		zap.Uint8("type", mode))
 
 	notice := &NoticeMessage{
-		KnownTrx: OrderedBlockIDs{
-			Mode:    [4]byte{mode, 0, 0, 0},
-			Pending: headBlockNum,
-		},
-		KnownBlocks: OrderedBlockIDs{
-			Mode:    [4]byte{mode, 0, 0, 0},
-			Pending: libNum,
-		},
+		KnownTrx:    types.NewIDList(IDListMode(mode), headBlockNum, nil),
+		KnownBlocks: types.NewIDList(IDListMode(mode), libNum, nil),
 	}
 	return errors.WithStack(p.WriteP2PMessage(notice))
 }
 
+// SendLastIrrCatchupNotice notice peer our lib and head, like nodeos when peer lib is behind
+func (p *Peer) SendLastIrrCatchupNotice(libNum uint32, headBlockNum uint32) error {
+	if err := p.checkSend(); err != nil {
+		return err
+	}
+
+	p.cli.logger.Debug("SendLastIrrCatchupNotice",
+		zap.String("peer", p.Address),
+		zap.Uint32("lib", libNum),
+		zap.Uint32("head", headBlockNum))
+
+	return errors.WithStack(p.WriteP2PMessage(types.NewLastIrrCatchupNotice(libNum, headBlockNum)))
+}
+
 // SendNoticeHeadCatchup send notice msg for p2p
 func (p *Peer) SendNoticeHeadCatchup(msg *NoticeMessage) error {
+	if err := p.checkSend(); err != nil {
+		return err
+	}
+
 	p.cli.logger.Debug("SendNoticeHeadCatchup",
 		zap.String("peer", p.Address),
 		zap.String("trx", msg.KnownTrx.String()),
 		zap.String("blk", msg.KnownBlocks.String()))
 
 	notice := &NoticeMessage{
-		KnownTrx: OrderedBlockIDs{
-			Mode:    [4]byte{0, 0, 0, 0},
-			Pending: msg.KnownTrx.Pending,
-			IDs:     msg.KnownTrx.IDs,
-		},
-		KnownBlocks: OrderedBlockIDs{
-			Mode:    [4]byte{1, 0, 0, 0},
-			Pending: msg.KnownBlocks.Pending,
-			IDs:     msg.KnownBlocks.IDs,
-		},
+		KnownTrx:    types.NewIDList(idListNone, msg.KnownTrx.Pending, msg.KnownTrx.IDs),
+		KnownBlocks: types.NewIDList(idListCatchUp, msg.KnownBlocks.Pending, msg.KnownBlocks.IDs),
 	}
 	return errors.WithStack(p.WriteP2PMessage(notice))
 }
 
 // SendTime send time sync msg to peer
 func (p *Peer) SendTime(recv *TimeMessage) error {
+	if err := p.checkSend(); err != nil {
+		return err
+	}
+
 	p.cli.logger.Debug("SendTime", zap.String("peer", p.Address))
 
 	notice := &TimeMessage{}
@@ -142,6 +170,10 @@ func (p *Peer) SendTime(recv *TimeMessage) error {
 // SendHandshake send handshake msg to peer
 func (p *Peer) SendHandshake(info *HandshakeInfo) error {
 
+	if err := p.checkSend(); err != nil {
+		return err
+	}
+
 	// TODO: support peer key
 	publicKey, err := types.NewPublicKey("EOS1111111111111111111111111111111114T1Anm")
 	if err != nil {
@@ -152,10 +184,7 @@ func (p *Peer) SendHandshake(info *HandshakeInfo) error {
 
 	tstamp := Tstamp{Time: info.HeadBlockTime}
 
-	signature := Signature{
-		Curve:   CurveK1,
-		Content: make([]byte, 65, 65),
-	}
+	signature := types.NewEmptySignature()
 
 	p.sendHandshakeCount++
 
@@ -181,8 +210,12 @@ func (p *Peer) SendHandshake(info *HandshakeInfo) error {
 
 	err = p.WriteP2PMessage(handshake)
 	if err != nil {
-		err = errors.Wrapf(err, "sending handshake to %s", p.Address)
+		return errors.Wrapf(err, "sending handshake to %s", p.Address)
 	}
 
+	p.mutex.Lock()
+	p.lastHandshakeSend = handshake
+	p.mutex.Unlock()
+
 	return nil
 }
@@ -0,0 +1,39 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// TestReplayPeerSend test msgs sent to the replay peer fail without panic
+func TestReplayPeerSend(t *testing.T) {
+	peer := replayPeer
+	if !peer.IsReplay() {
+		t.Fatalf("replay peer should be replay")
+	}
+
+	if other := (&Peer{Address: "replay"}); other.IsReplay() {
+		t.Errorf("only the replay peer should be replay")
+	}
+
+	for name, send := range map[string]func() error{
+		"sync":      func() error { return peer.SendSyncRequest(1, 10) },
+		"catchup":   func() error { return peer.SendCatchupRequest(Checksum256{}) },
+		"notice":    func() error { return peer.SendNotice(10, 1, 0) },
+		"libNotice": func() error { return peer.SendLastIrrCatchupNotice(1, 10) },
+		"time":      func() error { return peer.SendTime(nil) },
+		"goaway":    func() error { return peer.SendGoAway(types.GoAwayNoReason) },
+		"handshake": func() error { return peer.SendHandshake(&HandshakeInfo{}) },
+	} {
+		if err := send(); errors.Cause(err) != ErrPeerNotConnected {
+			t.Errorf("send %s to replay peer should be not connected, got %v", name, err)
+		}
+	}
+
+	if err := peer.Close(types.GoAwayNoReason); err != nil {
+		t.Errorf("close replay peer error %s", err.Error())
+	}
+}
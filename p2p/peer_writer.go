@@ -0,0 +1,230 @@
+package p2p
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+const (
+	defaultWriteTimeout  = 10 * time.Second
+	defaultSendQueueSize = 1024
+	ctrlQueueSize        = 32
+)
+
+var (
+	// ErrSendQueueFull the send queue of peer is full, the peer is too slow
+	ErrSendQueueFull = errors.New("send queue full")
+	// ErrPeerNotConnected peer had not connected or had closed
+	ErrPeerNotConnected = errors.New("peer not connected")
+)
+
+// sendItem a msg waiting to write
+type sendItem struct {
+	msg  Message
+	done chan error
+}
+
+// peerWriter write msgs to a connection of peer by its own goroutine,
+// control msgs (handshake, go away, time) will be written first
+type peerWriter struct {
+	peer         *Peer
+	conn         net.Conn
+	writeTimeout time.Duration
+
+	ctrlQueue chan *sendItem
+	sendQueue chan *sendItem
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	errOnce  sync.Once
+}
+
+func newPeerWriter(peer *Peer, conn net.Conn, writeTimeout time.Duration, queueSize int) *peerWriter {
+	return &peerWriter{
+		peer:         peer,
+		conn:         conn,
+		writeTimeout: writeTimeout,
+		ctrlQueue:    make(chan *sendItem, ctrlQueueSize),
+		sendQueue:    make(chan *sendItem, queueSize),
+		stopChan:     make(chan struct{}),
+	}
+}
+
+func isCtrlMsg(msg Message) bool {
+	switch msg.(type) {
+	case *HandshakeMessage, *GoAwayMessage, *TimeMessage:
+		return true
+	}
+	return false
+}
+
+// push push msg to queue, no block, return ErrSendQueueFull if queue is full
+func (w *peerWriter) push(item *sendItem) error {
+	queue := w.sendQueue
+	if isCtrlMsg(item.msg) {
+		queue = w.ctrlQueue
+	}
+
+	select {
+	case <-w.stopChan:
+		return ErrPeerNotConnected
+	default:
+	}
+
+	select {
+	case queue <- item:
+		return nil
+	default:
+		return ErrSendQueueFull
+	}
+}
+
+// stop stop write loop, msgs in queue will be dropped
+func (w *peerWriter) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopChan)
+	})
+}
+
+// fail report the first error of the connection to client, then stop the connection
+func (w *peerWriter) fail(err error) {
+	w.errOnce.Do(func() {
+		w.stop()
+		w.conn.Close()
+		w.peer.cli.postEnvelopMsg(newEnvelopMsgWithError(w.peer, err))
+	})
+}
+
+func (w *peerWriter) loop() {
+	for {
+		// control msgs first
+		select {
+		case item := <-w.ctrlQueue:
+			if !w.write(item) {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case item := <-w.ctrlQueue:
+			if !w.write(item) {
+				return
+			}
+		case item := <-w.sendQueue:
+			if !w.write(item) {
+				return
+			}
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+func (w *peerWriter) write(item *sendItem) bool {
+	data, err := types.EncodePacket(item.msg)
+	if err != nil {
+		// msg cannot encode, no need close connection
+		w.peer.cli.logger.Error("encode msg error", zap.String("peer", w.peer.Address), zap.Error(err))
+		if item.done != nil {
+			item.done <- err
+		}
+		return true
+	}
+
+	err = w.writeData(data)
+	if item.done != nil {
+		item.done <- err
+	}
+
+	if err != nil {
+		w.fail(err)
+		return false
+	}
+
+	return true
+}
+
+func (w *peerWriter) writeData(data []byte) error {
+	if w.writeTimeout > 0 {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout)); err != nil {
+			return errors.Wrapf(err, "set write deadline to %s", w.peer.Address)
+		}
+	}
+
+	if _, err := w.conn.Write(data); err != nil {
+		return errors.Wrapf(err, "write msg to %s", w.peer.Address)
+	}
+
+	return nil
+}
+
+// currWriter the writer for current connection, nil if not connected
+func (p *Peer) currWriter() *peerWriter {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.writer
+}
+
+// isConnected is the current connection not stopped
+func (p *Peer) isConnected() bool {
+	w := p.currWriter()
+	if w == nil {
+		return false
+	}
+
+	select {
+	case <-w.stopChan:
+		return false
+	default:
+		return true
+	}
+}
+
+// WriteP2PMessage push a p2p msg to send queue of peer, it will not wait msg written
+func (p *Peer) WriteP2PMessage(message Message) error {
+	w := p.currWriter()
+	if w == nil {
+		return errors.Wrapf(ErrPeerNotConnected, "write msg to %s", p.Address)
+	}
+
+	if err := w.push(&sendItem{msg: message}); err != nil {
+		p.cli.logger.Warn("push msg error", zap.String("peer", p.Address), zap.Error(err))
+		return errors.Wrapf(err, "write msg to %s", p.Address)
+	}
+
+	return nil
+}
+
+// WriteP2PMessageAndWait push a p2p msg to send queue of peer, and wait it written or timeout
+func (p *Peer) WriteP2PMessageAndWait(message Message, timeout time.Duration) error {
+	w := p.currWriter()
+	if w == nil {
+		return errors.Wrapf(ErrPeerNotConnected, "write msg to %s", p.Address)
+	}
+
+	item := &sendItem{
+		msg:  message,
+		done: make(chan error, 1),
+	}
+
+	if err := w.push(item); err != nil {
+		return errors.Wrapf(err, "write msg to %s", p.Address)
+	}
+
+	select {
+	case err := <-item.done:
+		return err
+	case <-w.stopChan:
+		return errors.Wrapf(ErrPeerNotConnected, "write msg to %s", p.Address)
+	case <-time.After(timeout):
+		return errors.Errorf("write msg to %s timeout", p.Address)
+	}
+}
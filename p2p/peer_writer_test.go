@@ -0,0 +1,157 @@
+package p2p
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	eos "github.com/eoscanada/eos-go"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+func newPeerWriterForTest(t *testing.T, queueSize int) (*peerWriter, net.Conn, *Client) {
+	cli := &Client{
+		logger:     zap.NewNop(),
+		scorer:     newPeerScorer(DefaultScoreCfg()),
+		packetChan: make(chan envelopMsg, 8),
+	}
+
+	peer, err := NewPeer(&PeerCfg{Address: "pipe"}, cli, 1, nil)
+	if err != nil {
+		t.Fatalf("new peer error %s", err.Error())
+	}
+
+	local, remote := net.Pipe()
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+
+	w := newPeerWriter(peer, local, time.Second, queueSize)
+	peer.writer = w
+
+	return w, remote, cli
+}
+
+// TestPeerWriterCtrlFirst test control msgs are written before other msgs in queue
+func TestPeerWriterCtrlFirst(t *testing.T) {
+	w, remote, _ := newPeerWriterForTest(t, 2)
+	peer := w.peer
+
+	for i := 0; i < 2; i++ {
+		if err := peer.SendSyncRequest(1, 2); err != nil {
+			t.Fatalf("push msg error %s", err.Error())
+		}
+	}
+
+	if err := peer.SendSyncRequest(1, 2); errors.Cause(err) != ErrSendQueueFull {
+		t.Fatalf("queue should be full, got %v", err)
+	}
+
+	if err := peer.SendTime(nil); err != nil {
+		t.Fatalf("push ctrl msg error %s", err.Error())
+	}
+
+	go w.loop()
+	defer w.stop()
+
+	reader := bufio.NewReader(remote)
+	expected := []types.Message{&TimeMessage{}, &SyncRequestMessage{}, &SyncRequestMessage{}}
+	for idx, e := range expected {
+		packet, err := types.ReadChainPacket(reader, remote)
+		if err != nil {
+			t.Fatalf("read packet error %s", err.Error())
+		}
+
+		if packet.Type != e.GetType() {
+			t.Errorf("msg %d type error, got %d expected %d", idx, packet.Type, e.GetType())
+		}
+	}
+}
+
+// TestPeerWriterTimeout test write error is reported when remote not read
+func TestPeerWriterTimeout(t *testing.T) {
+	w, _, cli := newPeerWriterForTest(t, 8)
+	w.writeTimeout = 50 * time.Millisecond
+
+	go w.loop()
+
+	if err := w.peer.SendTime(nil); err != nil {
+		t.Fatalf("push ctrl msg error %s", err.Error())
+	}
+
+	select {
+	case r := <-cli.packetChan:
+		if r.typ != envelopMsgError || r.err == nil {
+			t.Errorf("should report error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no error reported")
+	}
+
+	if err := w.peer.SendTime(nil); errors.Cause(err) != ErrPeerNotConnected {
+		t.Errorf("should not connected after error, got %v", err)
+	}
+}
+
+// TestPeerWriteBlockWithTrxs test the block with trxs written by peer can be read by the remote peer
+func TestPeerWriteBlockWithTrxs(t *testing.T) {
+	w, remote, _ := newPeerWriterForTest(t, 8)
+
+	gen, err := types.NewChainGenerator(types.Checksum256(make([]byte, 32)),
+		types.WithGenTransactions(func(blockNum uint32) [][]*types.Action {
+			return [][]*types.Action{{{
+				Account:    types.AccountName("eosio.token"),
+				Name:       types.ActionName("transfer"),
+				ActionData: eos.NewActionDataFromHexData([]byte{byte(blockNum)}),
+			}}}
+		}))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	blk, err := gen.Next()
+	if err != nil {
+		t.Fatalf("generate error %s", err.Error())
+	}
+
+	go w.loop()
+	defer w.stop()
+
+	if err := w.peer.WriteP2PMessage(blk); err != nil {
+		t.Fatalf("push block error %s", err.Error())
+	}
+
+	reader, err := NewPeer(&PeerCfg{Address: "remote"}, w.peer.cli, 2, nil)
+	if err != nil {
+		t.Fatalf("new peer error %s", err.Error())
+	}
+	reader.connection = remote
+	reader.reader = bufio.NewReader(remote)
+
+	packet, err := reader.Read()
+	if err != nil {
+		t.Fatalf("read block error %s", err.Error())
+	}
+
+	got, ok := packet.P2PMessage.(*SignedBlock)
+	if !ok {
+		t.Fatalf("packet should be a block, got %T", packet.P2PMessage)
+	}
+
+	id, _ := blk.BlockID()
+	gotID, _ := got.BlockID()
+	if !types.IsChecksumEq(id, gotID) {
+		t.Errorf("block id diff")
+	}
+
+	if len(got.Transactions) != 1 ||
+		!types.IsChecksumEq(got.Transactions[0].Transaction.ID, blk.Transactions[0].Transaction.ID) ||
+		got.Transactions[0].Transaction.Packed == nil {
+		t.Errorf("trx diff after read")
+	}
+}
@@ -1,11 +1,12 @@
 package p2p
 
 import (
-	"encoding/binary"
 	"math"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
 )
 
 const (
@@ -16,6 +17,19 @@ const (
 type syncManager struct {
 	syncHandler syncHandlerInterface
 	cli         *Client
+
+	// phase, irrHandler and headHandler only used IN peerLoop
+	phase       syncPhase
+	irrHandler  *syncIrreversibleHandler
+	headHandler *syncHeadHandler
+
+	// syncPeer the peer to request blocks, changed IN peerLoop
+	syncPeer *Peer
+	watchdog *syncWatchdog
+	progress *syncProgress
+
+	// rangeHandler the handler for SyncRange, nil if no range syncing
+	rangeHandler *syncRangeHandler
 }
 
 type syncHandlerInterface interface {
@@ -28,24 +42,75 @@ type syncHandlerInterface interface {
 
 func (s *syncManager) init(isSyncIrr bool) {
 	if isSyncIrr {
-		s.syncHandler = &syncIrreversibleHandler{
+		s.irrHandler = &syncIrreversibleHandler{
 			cli:      s.cli,
 			isInSync: true,
 		}
+		s.headHandler = &syncHeadHandler{
+			cli: s.cli,
+		}
+		s.phase = syncPhaseIrreversible
+		s.syncHandler = s.irrHandler
 	} else {
+		s.phase = syncPhaseLive
 		s.syncHandler = &syncNoIrrHandler{
 			cli: s.cli,
 		}
 	}
 
 	s.cli.syncHandler = NewMsgHandler("sync", s)
+	s.updateProgress()
+}
+
+// setPhase (IN peerLoop) change the sync phase
+func (s *syncManager) setPhase(phase syncPhase) {
+	if s.phase == phase {
+		return
+	}
+
+	s.cli.logger.Info("sync phase changed",
+		zap.String("from", s.phase.String()), zap.String("to", phase.String()))
+	s.phase = phase
+	s.updateProgress()
+}
+
+// startIrreversible (IN peerLoop) sync blocks to target by sync request from peer
+func (s *syncManager) startIrreversible(peer *Peer, targetNum uint32) error {
+	s.setPhase(syncPhaseIrreversible)
+	s.syncHandler = s.irrHandler
+	s.setSyncPeer(peer)
+
+	s.irrHandler.originHeadBlock = targetNum
+	return s.irrHandler.sendSyncRequest(peer)
+}
+
+// startHeadCatchup (IN peerLoop) fetch reversible blocks to target then following new blocks
+func (s *syncManager) startHeadCatchup(peer *Peer, targetNum uint32) error {
+	s.setPhase(syncPhaseHeadCatchup)
+	s.syncHandler = s.headHandler
+
+	return s.headHandler.start(peer, targetNum)
+}
+
+// setSyncPeer (IN peerLoop) set the peer to request blocks, the lib known by it is used by stream
+func (s *syncManager) setSyncPeer(peer *Peer) {
+	s.syncPeer = peer
+	if hs := peer.handshakeRecv(); hs != nil {
+		s.cli.stream.setLIB(hs.LastIrreversibleBlockNum)
+	}
 }
 
 // OnHandshakeMsg handler func imp
 func (s *syncManager) OnHandshakeMsg(peer *Peer, msg *HandshakeMessage) {
+	// only trust the lib from sync peer, a wrong lib from other peers would make reversible blocks irreversible
+	if peer == s.syncPeer {
+		s.cli.stream.setLIB(msg.LastIrreversibleBlockNum)
+	}
+
 	if err := s.syncHandler.OnHandshakeMsg(peer, msg); err != nil {
 		s.cli.logger.Error("on handshake msg error", zap.Error(err))
 	}
+	s.updateProgress()
 }
 
 // OnGoAwayMsg handler func imp
@@ -54,16 +119,24 @@ func (s *syncManager) OnGoAwayMsg(peer *Peer, msg *GoAwayMessage) {
 	peer.ClosePeer()
 }
 
-// OnTimeMsg handler func imp
+// OnTimeMsg handler func imp, only reply the original time msg from peer
 func (s *syncManager) OnTimeMsg(peer *Peer, msg *TimeMessage) {
-	peer.SendTime(msg)
+	if isTstampEmpty(msg.Origin) && !isTstampEmpty(msg.Transmit) {
+		peer.SendTime(msg)
+	}
 }
 
 // OnNoticeMsg handler func imp
 func (s *syncManager) OnNoticeMsg(peer *Peer, msg *NoticeMessage) {
+	// the lib of sync peer is in the known trxs of last irr catch up notice
+	if peer == s.syncPeer && types.GetIDListMode(&msg.KnownTrx) == idListLastIrrCatchUp {
+		s.cli.stream.setLIB(msg.KnownTrx.Pending)
+	}
+
 	if err := s.syncHandler.OnNoticeMsg(peer, msg); err != nil {
 		s.cli.logger.Error("on notice msg error", zap.Error(err))
 	}
+	s.updateProgress()
 }
 
 // OnRequestMsg handler func imp
@@ -81,6 +154,7 @@ func (s *syncManager) OnSignedBlock(peer *Peer, msg *SignedBlock) {
 	if err := s.syncHandler.OnSignedBlock(peer, msg); err != nil {
 		s.cli.logger.Error("on block msg error", zap.Error(err))
 	}
+	s.updateProgress()
 }
 
 // OnPackedTransactionMsg handler func imp
@@ -92,9 +166,11 @@ func (s *syncManager) OnPackedTransactionMsg(peer *Peer, msg *PackedTransactionM
 type syncIrreversibleHandler struct {
 	requestedStartBlock uint32
 	requestedEndBlock   uint32
-	originHeadBlock     uint32
-	cli                 *Client
-	isInSync            bool
+	// isRequesting a sync request sent and not all blocks in it received
+	isRequesting    bool
+	originHeadBlock uint32
+	cli             *Client
+	isInSync        bool
 }
 
 // No need imp
@@ -112,6 +188,7 @@ func (h *syncIrreversibleHandler) sendSyncRequest(peer *Peer) error {
 
 	// send req
 	err := peer.SendSyncRequest(h.requestedStartBlock, h.requestedEndBlock)
+	h.isRequesting = err == nil
 	if err != nil {
 		return errors.Wrapf(err, "send sync request to %s", peer.Address)
 	}
@@ -121,6 +198,11 @@ func (h *syncIrreversibleHandler) sendSyncRequest(peer *Peer) error {
 
 // OnHandshakeMsg when need sync irreversible blocks, after handshake client need send req to peer
 func (h *syncIrreversibleHandler) OnHandshakeMsg(peer *Peer, msg *HandshakeMessage) error {
+	if peer != h.cli.sync.syncPeer {
+		// only request blocks from sync peer
+		return nil
+	}
+
 	// init sync status
 	h.originHeadBlock = msg.HeadNum
 	return h.sendSyncRequest(peer)
@@ -138,8 +220,8 @@ func (h *syncIrreversibleHandler) OnNoticeMsg(peer *Peer, msg *NoticeMessage) er
 		return h.sendSyncRequest(peer)
 	}
 
-	switch binary.LittleEndian.Uint32(msg.KnownTrx.Mode[:]) {
-	case 1:
+	switch types.GetIDListMode(&msg.KnownTrx) {
+	case idListCatchUp:
 		if h.isInSync {
 			h.isInSync = false
 			h.cli.logger.Debug("recv trx catch_up notice")
@@ -155,7 +237,33 @@ func (h *syncIrreversibleHandler) OnNoticeMsg(peer *Peer, msg *NoticeMessage) er
 
 // OnSignedBlock handler func imp
 func (h *syncIrreversibleHandler) OnSignedBlock(peer *Peer, msg *SignedBlock) error {
+	if peer != h.cli.sync.syncPeer {
+		// blocks requested before sync peer changed
+		return nil
+	}
+
 	blockNum := msg.BlockNumber()
+	if !h.isRequesting {
+		// no request outstanding, such as the blocks sent by peer before our request, just ignore
+		return nil
+	}
+
+	if blockNum < h.requestedStartBlock || blockNum > h.requestedEndBlock {
+		h.cli.reportPeer(peer, PeerEventUnrequestedBlock)
+		return nil
+	}
+
+	if blockNum < h.cli.HeadBlockNum() {
+		// had got by request repeated
+		return nil
+	}
+
+	if err := h.cli.checkBlockLinkable(msg); err != nil {
+		h.cli.reportPeer(peer, PeerEventInvalidBlock)
+		return err
+	}
+
+	h.cli.reportPeer(peer, PeerEventUsefulBlock)
 	h.cli.SetHeadBlock(msg)
 
 	// update sync status
@@ -163,11 +271,12 @@ func (h *syncIrreversibleHandler) OnSignedBlock(peer *Peer, msg *SignedBlock) er
 		// need to get more blocks, no need process new request in next
 		return nil
 	}
+	h.isRequesting = false
 
 	if h.originHeadBlock <= blockNum {
-		// now block have got all
+		// now block have got all, catch up the head of peer
 		h.cli.syncSuccessNotice(peer)
-		return nil
+		return h.cli.sync.startHeadCatchup(peer, peer.headNumRecv())
 	}
 
 	// need get next blocks by sync
@@ -178,6 +287,8 @@ func (h *syncIrreversibleHandler) OnSignedBlock(peer *Peer, msg *SignedBlock) er
 // syncNoIrrHandler handler for syncManager when client is sync blocks and trxs
 type syncNoIrrHandler struct {
 	cli *Client
+	// isRangeSynced the storer had blocks synced by SyncRange, only SyncRange commits blocks then
+	isRangeSynced bool
 }
 
 // No need imp
@@ -205,6 +316,11 @@ func (h *syncNoIrrHandler) OnNoticeMsg(peer *Peer, msg *NoticeMessage) error {
 
 // OnSignedBlock handler func imp
 func (h *syncNoIrrHandler) OnSignedBlock(peer *Peer, msg *SignedBlock) error {
+	if h.isRangeSynced {
+		// the block relayed may not link to the range, not commit it
+		return nil
+	}
+
 	h.cli.SetHeadBlock(msg)
 	return nil
 }
@@ -0,0 +1,268 @@
+package p2p
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/store"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+const (
+	// HeadCatchupMaxGap if peer head is ahead of us more than it, use irreversible sync
+	HeadCatchupMaxGap uint32 = 360
+
+	// catchupRequestInterval min interval to request catch up again to the same target
+	catchupRequestInterval = 3 * time.Second
+)
+
+// syncPhase the phase of sync
+type syncPhase uint8
+
+const (
+	// syncPhaseIrreversible sync blocks by sync request from a peer
+	syncPhaseIrreversible = syncPhase(iota)
+	// syncPhaseHeadCatchup fetch the reversible blocks between our head and the head of network
+	syncPhaseHeadCatchup
+	// syncPhaseLive following the new blocks from network
+	syncPhaseLive
+)
+
+var syncPhaseNames = map[syncPhase]string{
+	syncPhaseIrreversible: "irreversible",
+	syncPhaseHeadCatchup:  "head_catchup",
+	syncPhaseLive:         "live",
+}
+
+func (p syncPhase) String() string {
+	if name, ok := syncPhaseNames[p]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// syncHeadHandler handler for syncManager when client is catching up the head or following new blocks
+type syncHeadHandler struct {
+	cli *Client
+
+	targetHeadNum uint32
+	requestedNum  uint32
+	requestedTime time.Time
+	// isForked our head may be in a fork the peer not know, so request catch up from the lib
+	isForked bool
+}
+
+// No need imp
+func (h *syncHeadHandler) OnRequestMsg(peer *Peer, msg *RequestMessage) error { return nil }
+func (h *syncHeadHandler) OnSyncRequestMsg(peer *Peer, msg *SyncRequestMessage) error {
+	return nil
+}
+
+// start start catch up to target by the peer
+func (h *syncHeadHandler) start(peer *Peer, targetNum uint32) error {
+	h.targetHeadNum = targetNum
+	h.requestedNum = 0
+
+	if h.cli.HeadBlockNum() >= targetNum {
+		h.cli.sync.setPhase(syncPhaseLive)
+		return nil
+	}
+
+	return h.requestCatchup(peer, targetNum)
+}
+
+// requestCatchup request the blocks after our head from peer, no repeat request in a short time
+func (h *syncHeadHandler) requestCatchup(peer *Peer, targetNum uint32) error {
+	if targetNum <= h.requestedNum {
+		if time.Since(h.requestedTime) < catchupRequestInterval {
+			return nil
+		}
+
+		// the request to the same target got nothing, maybe the peer not know our head in a fork
+		h.isForked = true
+	}
+
+	h.requestedNum = targetNum
+	h.requestedTime = time.Now()
+
+	fromID := h.catchupFromID()
+	h.cli.logger.Info("request head catch up",
+		zap.String("peer", peer.Address),
+		zap.Uint32("head", h.cli.HeadBlockNum()),
+		zap.Uint32("target", targetNum),
+		zap.Bool("forked", h.isForked))
+
+	return peer.SendCatchupRequest(fromID)
+}
+
+// catchupFromID the id to request the blocks after, it is our head, or the lib if our head may be in a fork,
+// the peer sends the blocks from the fork point then
+func (h *syncHeadHandler) catchupFromID() Checksum256 {
+	stat := h.cli.blkStorer.State()
+	if !h.isForked {
+		return stat.HeadBlockID
+	}
+
+	if rootID := h.cli.stream.rootID; rootID != "" {
+		return Checksum256(rootID)
+	}
+
+	if len(stat.LastIrreversibleBlockID) > 0 {
+		return stat.LastIrreversibleBlockID
+	}
+
+	return stat.HeadBlockID
+}
+
+// onPeerAhead peer has a head ahead of us, catch up it or fall back to irreversible sync if too far
+func (h *syncHeadHandler) onPeerAhead(peer *Peer, peerHeadNum uint32) error {
+	headNum := h.cli.HeadBlockNum()
+	if peerHeadNum <= headNum {
+		return nil
+	}
+
+	if peerHeadNum > h.targetHeadNum {
+		h.targetHeadNum = peerHeadNum
+	}
+
+	if peerHeadNum-headNum > HeadCatchupMaxGap {
+		h.cli.logger.Info("fall far behind, sync irreversible",
+			zap.Uint32("head", headNum), zap.Uint32("peer", peerHeadNum))
+		return h.cli.sync.startIrreversible(peer, peerHeadNum)
+	}
+
+	h.cli.sync.setPhase(syncPhaseHeadCatchup)
+	return h.requestCatchup(peer, peerHeadNum)
+}
+
+// OnHandshakeMsg
+func (h *syncHeadHandler) OnHandshakeMsg(peer *Peer, msg *HandshakeMessage) error {
+	return h.onPeerAhead(peer, msg.HeadNum)
+}
+
+// OnNoticeMsg
+func (h *syncHeadHandler) OnNoticeMsg(peer *Peer, msg *NoticeMessage) error {
+	blocks := &msg.KnownBlocks
+
+	switch types.GetIDListMode(blocks) {
+	case idListCatchUp:
+		return h.onPeerAhead(peer, blocks.Pending)
+	case idListLastIrrCatchUp:
+		// pending of known blocks is the head of peer, the lib of peer is in known trxs
+		return h.onPeerAhead(peer, blocks.Pending)
+	case idListNormal:
+		stat := h.cli.blkStorer.State()
+		ids := make([]Checksum256, 0, len(blocks.IDs))
+		for _, id := range blocks.IDs {
+			if !isBlockKnown(&stat, id) {
+				ids = append(ids, id)
+			}
+		}
+
+		if len(ids) > 0 {
+			return peer.SendBlocksRequest(ids)
+		}
+	}
+
+	return nil
+}
+
+// OnSignedBlock handler func imp, the block had been passed to stream, which commits the blocks of
+// the longest chain to storer by onStreamNew, only the blocks cannot link to stream need request
+func (h *syncHeadHandler) OnSignedBlock(peer *Peer, msg *SignedBlock) error {
+	id, err := msg.BlockID()
+	if err != nil {
+		return errors.Wrap(err, "block id")
+	}
+
+	blockNum := msg.BlockNumber()
+	if blockNum > h.cli.stream.rootNum && !h.cli.stream.isLinked(id) {
+		if blockNum > h.cli.HeadBlockNum()+1 {
+			// missing blocks before it
+			return h.onPeerAhead(peer, blockNum)
+		}
+
+		// cannot link to our chain, it is a fork, get the branch from peer
+		h.cli.logger.Debug("block not linkable in head catch up, maybe a fork",
+			zap.Uint32("num", blockNum), zap.String("id", id.String()))
+		h.isForked = true
+		h.requestedNum = 0
+		return h.requestCatchup(peer, blockNum)
+	}
+
+	stat := h.cli.blkStorer.State()
+	if headID, ok := stat.BlockIDByNum(blockNum); ok && types.IsChecksumEq(headID, id) {
+		h.isForked = false
+		h.cli.reportPeer(peer, PeerEventUsefulBlock)
+	}
+
+	if stat.HeadBlockNum >= h.targetHeadNum && h.cli.sync.phase == syncPhaseHeadCatchup {
+		h.cli.logger.Info("head catch up finished", zap.Uint32("head", stat.HeadBlockNum))
+		h.cli.sync.setPhase(syncPhaseLive)
+	}
+
+	return nil
+}
+
+// isFollowingStream is the head of storer following the longest chain of stream
+func (c *Client) isFollowingStream() bool {
+	_, ok := c.sync.syncHandler.(*syncHeadHandler)
+	return ok
+}
+
+// onStreamNew (IN peerLoop) commit the new block of the longest chain in stream to storer when following head,
+// it is called before the new event emitted
+func (c *Client) onStreamNew(blk *SignedBlock) {
+	if !c.isFollowingStream() {
+		return
+	}
+
+	stat := c.blkStorer.State()
+	if blk.BlockNumber() != stat.HeadBlockNum+1 ||
+		(len(stat.HeadBlockID) > 0 && !types.IsChecksumEq(blk.Previous, stat.HeadBlockID)) {
+		// not next to our head, such as the blocks before it had not been committed
+		return
+	}
+
+	c.SetHeadBlock(blk)
+}
+
+// rewindHead (IN peerLoop) rewind the head of storer when the head block is undone by stream switching fork
+func (c *Client) rewindHead(blk *SignedBlock) {
+	if !c.isFollowingStream() {
+		return
+	}
+
+	id, err := blk.BlockID()
+	if err != nil || !types.IsChecksumEq(id, c.blkStorer.State().HeadBlockID) {
+		return
+	}
+
+	rewinder, ok := c.blkStorer.(store.HeadRewinder)
+	if !ok {
+		c.logger.Warn("storer cannot rewind head to switch fork", zap.Uint32("head", blk.BlockNumber()))
+		return
+	}
+
+	if err := rewinder.RewindHead(blk.BlockNumber() - 1); err != nil {
+		c.logger.Warn("rewind head to switch fork error", zap.Uint32("head", blk.BlockNumber()), zap.Error(err))
+	}
+}
+
+// isBlockKnown is the block in recent blocks of storer
+func isBlockKnown(stat *store.BlockDBState, id Checksum256) bool {
+	if types.IsChecksumEq(stat.HeadBlockID, id) {
+		return true
+	}
+
+	for _, blk := range stat.LastBlocks {
+		blkID, err := blk.BlockID()
+		if err == nil && types.IsChecksumEq(blkID, id) {
+			return true
+		}
+	}
+
+	return false
+}
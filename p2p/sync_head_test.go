@@ -0,0 +1,199 @@
+package p2p_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// TestHeadCatchup test client catch up the head after irreversible sync, follow new blocks,
+// and fall back to irreversible sync when it is far behind
+func TestHeadCatchup(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 120))
+	storer := newStorerForTest(t, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 120
+	})
+
+	extendTo := func(headNum uint32) {
+		chain, err := srv.Chain().Extend(headNum)
+		if err != nil {
+			t.Fatalf("extend chain error %s", err.Error())
+		}
+		srv.SetChain(chain)
+	}
+
+	// catch up by notice
+	extendTo(150)
+	if err := srv.SendCatchupNotice(); err != nil {
+		t.Fatalf("send notice error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return client.HeadBlockNum() == 150
+	})
+
+	// following new block
+	extendTo(151)
+	if err := srv.Broadcast(srv.Chain().HeadBlock()); err != nil {
+		t.Fatalf("broadcast block error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return client.HeadBlockNum() == 151
+	})
+
+	// a block after a gap need catch up
+	extendTo(160)
+	if err := srv.Broadcast(srv.Chain().HeadBlock()); err != nil {
+		t.Fatalf("broadcast block error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return client.HeadBlockNum() == 160
+	})
+
+	// far behind, sync by irreversible
+	extendTo(160 + p2p.HeadCatchupMaxGap + 100)
+	if err := srv.SendCatchupNotice(); err != nil {
+		t.Fatalf("send notice error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == srv.Chain().HeadBlockNum()
+	})
+
+	headID, _ := srv.Chain().HeadBlock().BlockID()
+	if !types.IsChecksumEq(storer.HeadBlockID(), headID) {
+		t.Errorf("head id diff %s %s", storer.HeadBlockID(), headID)
+	}
+
+	hasSyncReq := false
+	for _, msg := range srv.Received() {
+		if req, ok := msg.(*types.SyncRequestMessage); ok && req.StartBlock >= 160 {
+			hasSyncReq = true
+		}
+	}
+
+	if !hasSyncReq {
+		t.Errorf("should sync by irreversible when far behind")
+	}
+
+	cancel()
+	client.Wait()
+}
+
+// TestHeadCatchupSwitchFork test client following new blocks switches to the longer fork of peer,
+// whether the fork is sent by its head or by a block next to our head
+func TestHeadCatchupSwitchFork(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 100), p2ptest.WithLIBLag(30))
+	storer := newStorerForTest(t, logger)
+
+	var mutex sync.Mutex
+	undone := make(map[uint32]int)
+	handler := p2p.NewBlockHandlerFunc("undo", func(ev *p2p.BlockEvent) {
+		if ev.Type == p2p.BlockEventUndo {
+			mutex.Lock()
+			undone[ev.BlockNum]++
+			mutex.Unlock()
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithBlockHandler(handler),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 100
+	})
+
+	checkHead := func(chain *p2ptest.Chain) {
+		waitFor(t, 5*time.Second, func() bool {
+			headID, _ := chain.HeadBlock().BlockID()
+			return types.IsChecksumEq(storer.HeadBlockID(), headID)
+		})
+	}
+
+	// the head of a longer fork from 91, our head is unknown by peer
+	fork, err := srv.Chain().Fork(91, 110)
+	if err != nil {
+		t.Fatalf("fork chain error %s", err.Error())
+	}
+	srv.SetChain(fork)
+	if err := srv.Broadcast(fork.HeadBlock()); err != nil {
+		t.Fatalf("broadcast block error %s", err.Error())
+	}
+	checkHead(fork)
+
+	// a competing block at our head is kept, the next block of its fork switches to it
+	fork, err = srv.Chain().Fork(105, 111)
+	if err != nil {
+		t.Fatalf("fork chain error %s", err.Error())
+	}
+	srv.SetChain(fork)
+
+	competing, _ := fork.GetBlockByNum(110)
+	if err := srv.Broadcast(competing); err != nil {
+		t.Fatalf("broadcast block error %s", err.Error())
+	}
+	if err := srv.Broadcast(fork.HeadBlock()); err != nil {
+		t.Fatalf("broadcast block error %s", err.Error())
+	}
+	checkHead(fork)
+
+	// the block next to our head in another fork, cannot link, request the branch from lib
+	fork, err = srv.Chain().Fork(108, 112)
+	if err != nil {
+		t.Fatalf("fork chain error %s", err.Error())
+	}
+	srv.SetChain(fork)
+	if err := srv.Broadcast(fork.HeadBlock()); err != nil {
+		t.Fatalf("broadcast block error %s", err.Error())
+	}
+	checkHead(fork)
+
+	stat := storer.State()
+	for num := uint32(100); num <= 112; num++ {
+		expected, _ := fork.GetBlockByNum(num)
+		expectedID, _ := expected.BlockID()
+		if id, ok := stat.BlockIDByNum(num); !ok || !types.IsChecksumEq(id, expectedID) {
+			t.Errorf("block %d in storer not from fork", num)
+		}
+	}
+
+	mutex.Lock()
+	if undone[91] != 1 || undone[100] != 1 || undone[105] != 1 || undone[110] != 2 {
+		t.Errorf("undo events diff %v", undone)
+	}
+	mutex.Unlock()
+
+	cancel()
+	client.Wait()
+}
@@ -0,0 +1,235 @@
+package p2p
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSyncPeerWait       = 2 * time.Second
+	defaultSyncPeerReevaluate = 30 * time.Second
+
+	// syncPeerCheckInterval interval to check handshakes when waiting to select the sync peer
+	syncPeerCheckInterval = 100 * time.Millisecond
+)
+
+// SyncPeerCfg config for selecting the peer to sync
+type SyncPeerCfg struct {
+	// Wait max time to wait handshakes from all connected peers before selecting
+	Wait time.Duration
+	// ReevaluateInterval interval to select again in sync, no re-evaluate if < 0
+	ReevaluateInterval time.Duration
+	// LibTolerance peers whose lib behind the best no more than it are ranked by reliability and rtt
+	LibTolerance uint32
+}
+
+// DefaultSyncPeerCfg default config for selecting sync peer
+func DefaultSyncPeerCfg() SyncPeerCfg {
+	return SyncPeerCfg{
+		Wait:               defaultSyncPeerWait,
+		ReevaluateInterval: defaultSyncPeerReevaluate,
+		LibTolerance:       BlockNumPerRequest,
+	}
+}
+
+// syncPeerSelector state for selecting sync peer, only used IN peerMngLoop
+type syncPeerSelector struct {
+	cfg          SyncPeerCfg
+	deadline     time.Time
+	isWaiting    bool
+	isSyncDone   bool
+	lastEvaluate time.Time
+}
+
+// syncPeerRank the info of a peer to rank
+type syncPeerRank struct {
+	peer  *Peer
+	stats PeerStats
+}
+
+// betterThan rank by reliability, then rtt, then head, unknown rtt is the worst
+func (r *syncPeerRank) betterThan(o *syncPeerRank) bool {
+	if r.stats.Score != o.stats.Score {
+		return r.stats.Score > o.stats.Score
+	}
+
+	if r.stats.RTT != o.stats.RTT {
+		if r.stats.RTT == 0 || o.stats.RTT == 0 {
+			return o.stats.RTT == 0
+		}
+		return r.stats.RTT < o.stats.RTT
+	}
+
+	return r.stats.HeadNum > o.stats.HeadNum
+}
+
+// syncPeerRanks (IN peerMngLoop) ranks of connected peers handshaked, whose head >= blockNum
+func (c *Client) syncPeerRanks(except *Peer, blockNum uint32) []*syncPeerRank {
+	res := make([]*syncPeerRank, 0, len(c.ps))
+	for _, ps := range c.ps {
+		if ps.status != peerStatNormal || ps.peer == except {
+			continue
+		}
+
+		stats := ps.peer.Stats()
+		if stats.HeadNum == 0 || stats.HeadNum < blockNum {
+			continue
+		}
+		stats.Score = c.scorer.score(ps.peer.Address)
+
+		res = append(res, &syncPeerRank{
+			peer:  ps.peer,
+			stats: stats,
+		})
+	}
+
+	return res
+}
+
+// bestSyncPeer select the best from ranks, the peers far behind the max lib are not selected
+func (c *Client) bestSyncPeer(ranks []*syncPeerRank) *syncPeerRank {
+	var maxLib uint32
+	for _, r := range ranks {
+		if r.stats.LastIrreversibleBlockNum > maxLib {
+			maxLib = r.stats.LastIrreversibleBlockNum
+		}
+	}
+
+	var res *syncPeerRank
+	for _, r := range ranks {
+		if c.isSyncPeerBehind(r, maxLib) {
+			continue
+		}
+
+		if res == nil || r.betterThan(res) {
+			res = r
+		}
+	}
+
+	return res
+}
+
+func (c *Client) isSyncPeerBehind(r *syncPeerRank, maxLib uint32) bool {
+	return r.stats.LastIrreversibleBlockNum+c.syncPeerSelect.cfg.LibTolerance < maxLib
+}
+
+// selectSyncPeer (IN peerMngLoop) select the best connected peer whose head covers blockNum, except the peer
+func (c *Client) selectSyncPeer(except *Peer, blockNum uint32) *Peer {
+	best := c.bestSyncPeer(c.syncPeerRanks(except, blockNum))
+	if best == nil {
+		return nil
+	}
+	return best.peer
+}
+
+// waitSyncPeer (IN peerMngLoop) start waiting handshakes to select sync peer
+func (c *Client) waitSyncPeer() {
+	s := c.syncPeerSelect
+	if s.isWaiting {
+		return
+	}
+
+	s.isWaiting = true
+	s.deadline = time.Now().Add(s.cfg.Wait)
+}
+
+// onSyncPeerTick (IN peerMngLoop) select sync peer if waiting, or re-evaluate if need
+func (c *Client) onSyncPeerTick() {
+	s := c.syncPeerSelect
+	if s.isWaiting {
+		c.trySelectSyncPeer()
+		return
+	}
+
+	if s.isSyncDone || c.currentSyncPeer == nil || s.cfg.ReevaluateInterval <= 0 ||
+		time.Since(s.lastEvaluate) < s.cfg.ReevaluateInterval {
+		return
+	}
+
+	c.reevaluateSyncPeer()
+}
+
+// trySelectSyncPeer (IN peerMngLoop) start sync when all connected peers handshaked or timeout
+func (c *Client) trySelectSyncPeer() {
+	s := c.syncPeerSelect
+
+	var connected, handshaked int
+	var first *Peer
+	for _, ps := range c.ps {
+		if ps.status != peerStatNormal {
+			continue
+		}
+
+		connected++
+		if first == nil {
+			first = ps.peer
+		}
+		if ps.peer.headNumRecv() > 0 {
+			handshaked++
+		}
+	}
+
+	isTimeout := time.Now().After(s.deadline)
+	if connected == 0 || (handshaked < connected && !isTimeout) {
+		return
+	}
+
+	peer := c.selectSyncPeer(nil, 0)
+	if peer == nil {
+		// no handshake in time, use any peer connected, it will handshake when start sync
+		peer = first
+	}
+
+	c.logger.Info("select sync peer",
+		zap.String("peer", peer.Address),
+		zap.Int("connected", connected),
+		zap.Int("handshaked", handshaked))
+
+	s.isWaiting = false
+	s.lastEvaluate = time.Now()
+	c.currentSyncPeer = peer
+	c.startSyncIrr(peer)
+}
+
+// reevaluateSyncPeer (IN peerMngLoop) switch to a better peer if current is behind, unreliable or slow
+func (c *Client) reevaluateSyncPeer() {
+	s := c.syncPeerSelect
+	s.lastEvaluate = time.Now()
+
+	ranks := c.syncPeerRanks(nil, c.HeadBlockNum()+1)
+	best := c.bestSyncPeer(ranks)
+	if best == nil || best.peer == c.currentSyncPeer {
+		return
+	}
+
+	var curr *syncPeerRank
+	var maxLib uint32
+	for _, r := range ranks {
+		if r.peer == c.currentSyncPeer {
+			curr = r
+		}
+		if r.stats.LastIrreversibleBlockNum > maxLib {
+			maxLib = r.stats.LastIrreversibleBlockNum
+		}
+	}
+
+	// keep the current unless it is much worse, so no switching between similar peers
+	isWorse := curr == nil ||
+		c.isSyncPeerBehind(curr, maxLib) ||
+		(curr.stats.Score < 0 && best.stats.Score >= 0) ||
+		(best.stats.RTT > 0 && (curr.stats.RTT == 0 || curr.stats.RTT > 2*best.stats.RTT))
+	if !isWorse {
+		return
+	}
+
+	c.logger.Info("switch to better sync peer",
+		zap.String("from", c.currentSyncPeer.Address),
+		zap.String("to", best.peer.Address))
+
+	c.currentSyncPeer = best.peer
+	c.postEnvelopMsg(envelopMsg{
+		typ:    envelopMsgSwitchSyncPeer,
+		Sender: best.peer,
+	})
+}
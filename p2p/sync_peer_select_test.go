@@ -0,0 +1,96 @@
+package p2p_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// TestSelectBestSyncPeer test client select the peer with the highest lib to sync
+func TestSelectBestSyncPeer(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 120)
+	short, err := chain.Fork(1, 40)
+	if err != nil {
+		t.Fatalf("fork chain error %s", err.Error())
+	}
+
+	behind := newServerForTest(t, short)
+	best := newServerForTest(t, chain)
+	storer := newStorerForTest(t, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: behind.Addr()}, {Address: best.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 120
+	})
+
+	if hasSyncRequest(behind) {
+		t.Errorf("should not sync from the peer behind")
+	}
+
+	cancel()
+	client.Wait()
+}
+
+// TestReevaluateSyncPeer test client switch to a better peer in sync
+func TestReevaluateSyncPeer(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 120)
+	longer, err := chain.Extend(400)
+	if err != nil {
+		t.Fatalf("extend chain error %s", err.Error())
+	}
+
+	// the first peer hold the requests after 60
+	first := newServerForTest(t, chain, p2ptest.WithMessageHook(func(c *p2ptest.Conn, msg types.Message) bool {
+		req, ok := msg.(*types.SyncRequestMessage)
+		return ok && req.StartBlock >= 60
+	}))
+	second := newServerForTest(t, longer)
+	storer := newStorerForTest(t, logger)
+
+	cfg := p2p.DefaultSyncPeerCfg()
+	cfg.ReevaluateInterval = 200 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: first.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithSyncPeerCfg(cfg),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return client.HeadBlockNum() >= 60
+	})
+
+	if err := client.NewPeer(&p2p.PeerCfg{Address: second.Addr()}); err != nil {
+		t.Fatalf("new peer error %s", err.Error())
+	}
+
+	// the stall timeout is default 30s, so the switch is by re-evaluating
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 400
+	})
+
+	cancel()
+	client.Wait()
+}
@@ -0,0 +1,198 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// syncRateWindow the moving window to calculate sync rate
+	syncRateWindow = 10 * time.Second
+	// syncRateSampleInterval min interval between two samples for sync rate
+	syncRateSampleInterval = 100 * time.Millisecond
+	// syncProgressEventInterval min interval between two progress events if phase not changed
+	syncProgressEventInterval = time.Second
+)
+
+// SyncStatus status of sync
+type SyncStatus struct {
+	// Phase irreversible, head_catchup or live
+	Phase     string `json:"phase"`
+	HeadNum   uint32 `json:"head"`
+	TargetNum uint32 `json:"target"`
+
+	// Rate blocks per second in the moving window
+	Rate float64 `json:"rate"`
+	// ETA estimated time to reach target, 0 if unknown or reached
+	ETA time.Duration `json:"eta"`
+
+	// Peers address of the peers to sync blocks
+	Peers []string `json:"peers"`
+
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type progressSample struct {
+	at      time.Time
+	headNum uint32
+}
+
+// syncProgress progress of sync, updated IN peerLoop and read by any goroutine
+type syncProgress struct {
+	mutex   sync.Mutex
+	status  SyncStatus
+	samples []progressSample
+
+	lastEvent time.Time
+	subs      map[chan SyncStatus]struct{}
+	isClosed  bool
+}
+
+func newSyncProgress() *syncProgress {
+	return &syncProgress{
+		subs: make(map[chan SyncStatus]struct{}, 4),
+	}
+}
+
+// rate blocks per second from the oldest sample in window, samples out of window are removed
+func (p *syncProgress) rate(now time.Time, headNum uint32) float64 {
+	idx := 0
+	for idx < len(p.samples) && now.Sub(p.samples[idx].at) > syncRateWindow {
+		idx++
+	}
+	p.samples = p.samples[idx:]
+
+	if len(p.samples) == 0 || headNum < p.samples[0].headNum {
+		// head reset by a new sync
+		p.samples = p.samples[:0]
+	}
+
+	if len(p.samples) == 0 || now.Sub(p.samples[len(p.samples)-1].at) >= syncRateSampleInterval {
+		p.samples = append(p.samples, progressSample{at: now, headNum: headNum})
+	}
+
+	elapsed := now.Sub(p.samples[0].at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64(headNum-p.samples[0].headNum) / elapsed
+}
+
+// update update the status, and send the event to subscribers
+func (p *syncProgress) update(phase syncPhase, headNum, targetNum uint32, peers []string) {
+	now := time.Now()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if targetNum < headNum {
+		targetNum = headNum
+	}
+
+	isPhaseChanged := p.status.Phase != phase.String()
+	isReached := headNum == targetNum && p.status.HeadNum != targetNum
+
+	p.status = SyncStatus{
+		Phase:     phase.String(),
+		HeadNum:   headNum,
+		TargetNum: targetNum,
+		Rate:      p.rate(now, headNum),
+		Peers:     peers,
+		UpdatedAt: now,
+	}
+
+	if p.status.Rate > 0 && targetNum > headNum {
+		p.status.ETA = time.Duration(float64(targetNum-headNum) / p.status.Rate * float64(time.Second))
+	}
+
+	if !isPhaseChanged && !isReached && now.Sub(p.lastEvent) < syncProgressEventInterval {
+		return
+	}
+
+	p.lastEvent = now
+	for ch := range p.subs {
+		select {
+		case ch <- p.status:
+		default:
+			// subscriber too slow, drop the event, it can get status by SyncStatus
+		}
+	}
+}
+
+func (p *syncProgress) get() SyncStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	res := p.status
+	res.Peers = append([]string(nil), p.status.Peers...)
+	return res
+}
+
+func (p *syncProgress) subscribe(bufSize int) (<-chan SyncStatus, func()) {
+	ch := make(chan SyncStatus, bufSize)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.isClosed {
+		close(ch)
+		return ch, func() {}
+	}
+	p.subs[ch] = struct{}{}
+
+	return ch, func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		if _, ok := p.subs[ch]; ok {
+			delete(p.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// close close all subscribers when client stopped
+func (p *syncProgress) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.isClosed = true
+	for ch := range p.subs {
+		delete(p.subs, ch)
+		close(ch)
+	}
+}
+
+// updateProgress (IN peerLoop) update sync progress by current state
+func (s *syncManager) updateProgress() {
+	headNum := s.cli.HeadBlockNum()
+
+	var targetNum uint32
+	switch s.phase {
+	case syncPhaseIrreversible:
+		if s.irrHandler != nil {
+			targetNum = s.irrHandler.originHeadBlock
+		}
+	case syncPhaseHeadCatchup:
+		targetNum = s.headHandler.targetHeadNum
+	}
+
+	var peers []string
+	if s.syncPeer != nil {
+		peers = []string{s.syncPeer.Address}
+	}
+
+	s.progress.update(s.phase, headNum, targetNum, peers)
+}
+
+// SyncStatus get the status of sync
+func (c *Client) SyncStatus() SyncStatus {
+	return c.sync.progress.get()
+}
+
+// SubscribeSyncProgress subscribe the progress events of sync, events will be dropped if the chan is full,
+// call the func returned to unsubscribe, the chan will be closed when unsubscribe or client stopped
+func (c *Client) SubscribeSyncProgress(bufSize int) (<-chan SyncStatus, func()) {
+	return c.sync.progress.subscribe(bufSize)
+}
@@ -0,0 +1,56 @@
+package p2p_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+)
+
+// TestSyncStatus test status and progress events in sync
+func TestSyncStatus(t *testing.T) {
+	logger := zap.NewNop()
+	srv := newServerForTest(t, newChainForTest(t, 120))
+	storer := newStorerForTest(t, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	events, _ := client.SubscribeSyncProgress(64)
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.SyncStatus().Phase == "live"
+	})
+
+	status := client.SyncStatus()
+	if status.HeadNum != 120 || status.TargetNum != 120 {
+		t.Errorf("status head %d target %d should be 120", status.HeadNum, status.TargetNum)
+	}
+
+	if len(status.Peers) != 1 || status.Peers[0] != srv.Addr() {
+		t.Errorf("sync peers should be %s, got %v", srv.Addr(), status.Peers)
+	}
+
+	cancel()
+	client.Wait()
+
+	var last *p2p.SyncStatus
+	for ev := range events {
+		ev := ev
+		last = &ev
+	}
+
+	if last == nil || last.Phase != "live" || last.HeadNum != 120 {
+		t.Errorf("the last progress event should be live at 120, got %+v", last)
+	}
+}
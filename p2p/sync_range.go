@@ -0,0 +1,336 @@
+package p2p
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/store"
+)
+
+// syncRangePeerInterval interval to check peers when waiting a peer to sync range
+const syncRangePeerInterval = 100 * time.Millisecond
+
+// errSyncRangeStalled no blocks received for range in stall timeout
+var errSyncRangeStalled = errors.New("sync range stalled")
+
+// syncRangeJob a job to sync blocks in [start, end] from a peer
+type syncRangeJob struct {
+	ctx  context.Context
+	peer *Peer
+	end  uint32
+	done chan error
+}
+
+// syncRangeHandler handler for syncManager when client is syncing a range, all funcs are called IN peerLoop
+type syncRangeHandler struct {
+	cli  *Client
+	job  *syncRangeJob
+	prev syncHandlerInterface
+
+	requestedEnd uint32
+	gapHead      uint32
+	lastProgress time.Time
+}
+
+// No need imp
+func (h *syncRangeHandler) OnRequestMsg(peer *Peer, msg *RequestMessage) error { return nil }
+func (h *syncRangeHandler) OnSyncRequestMsg(peer *Peer, msg *SyncRequestMessage) error {
+	return nil
+}
+func (h *syncRangeHandler) OnHandshakeMsg(peer *Peer, msg *HandshakeMessage) error { return nil }
+func (h *syncRangeHandler) OnNoticeMsg(peer *Peer, msg *NoticeMessage) error       { return nil }
+
+// sendRequest request the blocks after head to the peer
+func (h *syncRangeHandler) sendRequest() error {
+	headNum := h.cli.HeadBlockNum()
+	h.requestedEnd = uint32(math.Min(float64(headNum)+float64(BlockNumPerRequest), float64(h.job.end)))
+
+	err := h.job.peer.SendSyncRequest(headNum+1, h.requestedEnd)
+	if err != nil {
+		return errors.Wrapf(err, "send sync request to %s", h.job.peer.Address)
+	}
+
+	return nil
+}
+
+// finish finish the job, and restore the handler before, if it is the handler for no sync,
+// it stops committing blocks, so the head is not moved past the range by the blocks relayed
+func (h *syncRangeHandler) finish(err error) {
+	s := h.cli.sync
+	if s.rangeHandler != h {
+		// had finished
+		return
+	}
+
+	s.rangeHandler = nil
+	s.syncHandler = h.prev
+	if noIrr, ok := h.prev.(*syncNoIrrHandler); ok {
+		noIrr.isRangeSynced = true
+	}
+
+	h.job.done <- err
+}
+
+// OnSignedBlock commit the block if it is the next one, re-request if there is a gap
+func (h *syncRangeHandler) OnSignedBlock(peer *Peer, msg *SignedBlock) error {
+	if peer != h.job.peer {
+		return nil
+	}
+
+	blockNum := msg.BlockNumber()
+	headNum := h.cli.HeadBlockNum()
+	if blockNum <= headNum {
+		// had got
+		return nil
+	}
+
+	if blockNum > h.requestedEnd {
+		h.cli.reportPeer(peer, PeerEventUnrequestedBlock)
+		return nil
+	}
+
+	if blockNum > headNum+1 {
+		// some blocks lost, request again from head, only once for a head
+		if h.gapHead == headNum {
+			return nil
+		}
+		h.gapHead = headNum
+
+		h.cli.logger.Info("gap in sync range, request again",
+			zap.Uint32("head", headNum), zap.Uint32("block", blockNum))
+		return h.sendRequest()
+	}
+
+	if err := h.cli.checkBlockLinkable(msg); err != nil {
+		h.cli.reportPeer(peer, PeerEventInvalidBlock)
+		h.finish(errors.Wrap(err, "invalid block"))
+		return err
+	}
+
+	if err := h.cli.SetHeadBlock(msg); err != nil {
+		h.finish(err)
+		return err
+	}
+
+	h.cli.reportPeer(peer, PeerEventUsefulBlock)
+	h.lastProgress = time.Now()
+
+	if blockNum >= h.job.end {
+		h.finish(nil)
+		return nil
+	}
+
+	if blockNum >= h.requestedEnd {
+		return h.sendRequest()
+	}
+
+	return nil
+}
+
+// onTick finish the job if stalled
+func (h *syncRangeHandler) onTick() {
+	if time.Since(h.lastProgress) <= h.cli.sync.watchdog.timeout {
+		return
+	}
+
+	h.cli.logger.Warn("sync range stalled",
+		zap.String("peer", h.job.peer.Address),
+		zap.Uint32("head", h.cli.HeadBlockNum()))
+	h.cli.reportPeer(h.job.peer, PeerEventTimeout)
+	h.finish(errSyncRangeStalled)
+}
+
+// onSyncRange (IN peerLoop) start sync range job
+func (c *Client) onSyncRange(job *syncRangeJob) {
+	s := c.sync
+
+	prev := s.syncHandler
+	if s.rangeHandler != nil {
+		// replace the job not finished
+		prev = s.rangeHandler.prev
+		s.rangeHandler.finish(errors.New("replaced by another sync range"))
+	}
+
+	h := &syncRangeHandler{
+		cli:          c,
+		job:          job,
+		prev:         prev,
+		lastProgress: time.Now(),
+	}
+
+	s.rangeHandler = h
+	s.syncHandler = h
+
+	c.logger.Info("start sync range",
+		zap.String("peer", job.peer.Address),
+		zap.Uint32("head", c.HeadBlockNum()),
+		zap.Uint32("end", job.end))
+
+	if err := job.ctx.Err(); err != nil {
+		// canceled before started
+		h.finish(err)
+		return
+	}
+
+	if err := h.sendRequest(); err != nil {
+		h.finish(err)
+	}
+}
+
+// onSyncRangeCancel (IN peerLoop) finish the job canceled by the caller
+func (c *Client) onSyncRangeCancel(job *syncRangeJob) {
+	if h := c.sync.rangeHandler; h != nil && h.job == job {
+		c.logger.Info("sync range canceled", zap.Uint32("head", c.HeadBlockNum()))
+		h.finish(job.ctx.Err())
+	}
+}
+
+// onSelectRangePeer (IN peerMngLoop) select a peer whose head covers the range,
+// handshake to the peers not handshaked to know their heads
+func (c *Client) onSelectRangePeer(msg *peerMsg) {
+	peer := c.selectSyncPeer(nil, msg.blockNum)
+	if peer == nil {
+		stat := c.blkStorer.State()
+		for _, ps := range c.ps {
+			if ps.status != peerStatNormal || ps.peer.handshakeSent() {
+				continue
+			}
+
+			if err := ps.peer.SendHandshake(stat.ToHandshakeInfo()); err != nil {
+				c.logger.Warn("send handshake error", zap.String("peer", ps.peer.Address), zap.Error(err))
+			}
+		}
+	}
+
+	msg.peerResp <- peer
+}
+
+// selectRangePeer wait a peer whose head >= endNum
+func (c *Client) selectRangePeer(ctx context.Context, endNum uint32) (*Peer, error) {
+	ticker := time.NewTicker(syncRangePeerInterval)
+	defer ticker.Stop()
+
+	for {
+		resp := make(chan *Peer, 1)
+		if !c.postPeerMsg(peerMsg{
+			msgTyp:   peerMsgSelectRangePeer,
+			blockNum: endNum,
+			peerResp: resp,
+		}) {
+			return nil, errors.New("client stopped")
+		}
+
+		select {
+		case peer := <-resp:
+			if peer != nil {
+				return peer, nil
+			}
+		case <-c.mngDone:
+			return nil, errors.New("client stopped")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// prepareSyncRange make storer can commit the blocks from start
+func (c *Client) prepareSyncRange(start uint32) error {
+	if start <= 1 || c.HeadBlockNum() >= start-1 {
+		return nil
+	}
+
+	seeder, ok := c.blkStorer.(store.CheckpointSeeder)
+	if !ok {
+		return errors.New("storer not support start from checkpoint")
+	}
+
+	// no block id, so the first block synced will be trusted
+	if err := seeder.SeedCheckpoint(store.Checkpoint{
+		BlockNum: start - 1,
+	}); err != nil {
+		return err
+	}
+
+	c.postEnvelopMsg(envelopMsg{
+		typ: envelopMsgResetStream,
+	})
+	return nil
+}
+
+// SyncRange sync the blocks in [start, end] into storer, return when all blocks committed,
+// if storer head is in the range, sync from the head. it cannot be used with WithNeedSync
+func (c *Client) SyncRange(ctx context.Context, start, end uint32) error {
+	if c.needSync {
+		return errors.New("client is syncing, cannot sync range")
+	}
+
+	if start == 0 || start > end {
+		return errors.Errorf("invalid range [%d, %d]", start, end)
+	}
+
+	if err := c.prepareSyncRange(start); err != nil {
+		return errors.Wrap(err, "prepare storer")
+	}
+
+	for c.HeadBlockNum() < end {
+		peer, err := c.selectRangePeer(ctx, end)
+		if err != nil {
+			return errors.Wrap(err, "select peer")
+		}
+
+		job := &syncRangeJob{
+			ctx:  ctx,
+			peer: peer,
+			end:  end,
+			done: make(chan error, 1),
+		}
+
+		if !c.postEnvelopMsg(envelopMsg{
+			typ:      envelopMsgSyncRange,
+			rangeJob: job,
+		}) {
+			return errors.New("client stopped")
+		}
+
+		select {
+		case err = <-job.done:
+		case <-ctx.Done():
+			// finish the job in peerLoop, not wait for it
+			c.postEnvelopMsg(envelopMsg{
+				typ:      envelopMsgSyncRangeCancel,
+				rangeJob: job,
+			})
+			return ctx.Err()
+		case <-c.loopDone:
+			return errors.New("client stopped")
+		}
+
+		if err == nil {
+			break
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		// try again by another peer, the peer failed will rank lower by score
+		c.logger.Warn("sync range by peer failed",
+			zap.String("peer", peer.Address), zap.Error(err))
+	}
+
+	if f, ok := c.blkStorer.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return errors.Wrap(err, "flush storer")
+		}
+	}
+
+	return nil
+}
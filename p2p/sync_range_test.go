@@ -0,0 +1,174 @@
+package p2p_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// TestSyncRange test sync a range of blocks and re-request the blocks lost
+func TestSyncRange(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 300)
+
+	// lost the block 150 for the first time
+	isLost := false
+	srv := newServerForTest(t, chain, p2ptest.WithMessageHook(func(c *p2ptest.Conn, msg types.Message) bool {
+		req, ok := msg.(*types.SyncRequestMessage)
+		if !ok || isLost || req.StartBlock > 150 || req.EndBlock < 150 {
+			return false
+		}
+
+		isLost = true
+		for num := req.StartBlock; num <= req.EndBlock; num++ {
+			blk, _ := chain.GetBlockByNum(num)
+			if num != 150 {
+				c.Send(blk)
+			}
+		}
+		return true
+	}))
+	storer := newStorerForTest(t, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	syncCtx, syncCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer syncCancel()
+
+	if err := client.SyncRange(syncCtx, 100, 200); err != nil {
+		t.Fatalf("sync range error %s", err.Error())
+	}
+
+	if client.HeadBlockNum() != 200 {
+		t.Errorf("head should be 200, got %d", client.HeadBlockNum())
+	}
+
+	headID, _ := chain.Blocks()[199].BlockID()
+	if !types.IsChecksumEq(storer.HeadBlockID(), headID) {
+		t.Errorf("head id diff %s %s", storer.HeadBlockID(), headID)
+	}
+
+	// no blocks after the range
+	time.Sleep(100 * time.Millisecond)
+	if client.HeadBlockNum() != 200 {
+		t.Errorf("should not sync after range, got %d", client.HeadBlockNum())
+	}
+
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown error %s", err.Error())
+	}
+}
+
+// TestSyncRangeStalled test sync range by another peer when stalled
+func TestSyncRangeStalled(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 120)
+
+	stalled := newServerForTest(t, chain, p2ptest.WithMessageHook(func(c *p2ptest.Conn, msg types.Message) bool {
+		_, ok := msg.(*types.SyncRequestMessage)
+		return ok
+	}))
+	normal := newServerForTest(t, chain)
+	storer := newStorerForTest(t, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: stalled.Addr()}, {Address: normal.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithSyncStallTimeout(200*time.Millisecond),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	syncCtx, syncCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer syncCancel()
+
+	if err := client.SyncRange(syncCtx, 1, 120); err != nil {
+		t.Fatalf("sync range error %s", err.Error())
+	}
+
+	if client.HeadBlockNum() != 120 {
+		t.Errorf("head should be 120, got %d", client.HeadBlockNum())
+	}
+
+	cancel()
+	client.Wait()
+}
+
+// TestSyncRangeCancel test the canceled job is finished without the stall check,
+// and the blocks relayed after the range are not committed
+func TestSyncRangeCancel(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 120)
+
+	var isStalled int32 = 1
+	srv := newServerForTest(t, chain, p2ptest.WithMessageHook(func(c *p2ptest.Conn, msg types.Message) bool {
+		_, ok := msg.(*types.SyncRequestMessage)
+		return ok && atomic.LoadInt32(&isStalled) == 1
+	}))
+	storer := newStorerForTest(t, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithSyncStallTimeout(0),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	syncCtx, syncCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer syncCancel()
+
+	if err := client.SyncRange(syncCtx, 1, 100); err != context.DeadlineExceeded {
+		t.Fatalf("sync range should be canceled, got %v", err)
+	}
+
+	// the range handler is removed, sync again
+	atomic.StoreInt32(&isStalled, 0)
+
+	syncCtx, syncCancel = context.WithTimeout(ctx, 10*time.Second)
+	defer syncCancel()
+
+	if err := client.SyncRange(syncCtx, 1, 100); err != nil {
+		t.Fatalf("sync range error %s", err.Error())
+	}
+
+	// the blocks after the range relayed by peer
+	for num := uint32(101); num <= 103; num++ {
+		blk, _ := chain.GetBlockByNum(num)
+		if err := srv.Broadcast(blk); err != nil {
+			t.Fatalf("broadcast block error %s", err.Error())
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if client.HeadBlockNum() != 100 {
+		t.Errorf("head should be 100 after range, got %d", client.HeadBlockNum())
+	}
+
+	cancel()
+	client.Wait()
+}
@@ -0,0 +1,168 @@
+package p2p
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultSyncStallTimeout no progress in sync for so long means the sync peer is stalled
+const defaultSyncStallTimeout = 30 * time.Second
+
+// syncWatchdog check sync progress, all funcs are called IN peerLoop
+type syncWatchdog struct {
+	timeout      time.Duration
+	lastHeadNum  uint32
+	lastProgress time.Time
+}
+
+func newSyncWatchdog(timeout time.Duration) *syncWatchdog {
+	return &syncWatchdog{
+		timeout:      timeout,
+		lastProgress: time.Now(),
+	}
+}
+
+// checkInterval interval to check progress
+func (w *syncWatchdog) checkInterval() time.Duration {
+	return w.timeout / 4
+}
+
+// reset reset the window, such as after a new sync peer selected
+func (w *syncWatchdog) reset(headNum uint32) {
+	w.lastHeadNum = headNum
+	w.lastProgress = time.Now()
+}
+
+// isStalled is no progress in window
+func (w *syncWatchdog) isStalled(headNum uint32) bool {
+	if headNum != w.lastHeadNum {
+		w.reset(headNum)
+		return false
+	}
+
+	return time.Since(w.lastProgress) > w.timeout
+}
+
+// isWaitingBlocks is sync waiting blocks from sync peer
+func (s *syncManager) isWaitingBlocks() bool {
+	switch s.phase {
+	case syncPhaseIrreversible:
+		// the phase will changed after all blocks got, also need wait the handshake from sync peer
+		return s.syncPeer != nil
+	case syncPhaseHeadCatchup:
+		return s.syncPeer != nil && s.headHandler.targetHeadNum > s.cli.HeadBlockNum()
+	}
+	return false
+}
+
+// onWatchdogTick (IN peerLoop) check sync progress, if stalled ask peerMngLoop for another sync peer
+func (c *Client) onWatchdogTick() {
+	s := c.sync
+	if s.rangeHandler != nil {
+		s.rangeHandler.onTick()
+		return
+	}
+
+	headNum := c.HeadBlockNum()
+	if !s.isWaitingBlocks() {
+		s.watchdog.reset(headNum)
+		return
+	}
+
+	if !s.watchdog.isStalled(headNum) {
+		return
+	}
+
+	stalled := s.syncPeer
+	if !stalled.isConnected() {
+		// the disconnected sync peer is changed by peerMngLoop, not penalize it again
+		s.watchdog.reset(headNum)
+		return
+	}
+
+	c.logger.Warn("sync stalled",
+		zap.String("peer", stalled.Address),
+		zap.String("phase", s.phase.String()),
+		zap.Uint32("head", headNum))
+
+	// give the new peer a full window
+	s.watchdog.reset(headNum)
+	c.reportPeer(stalled, PeerEventTimeout)
+
+	c.postPeerMsg(peerMsg{
+		msgTyp:   peerMsgSyncStalled,
+		peer:     stalled,
+		blockNum: s.targetNum(),
+	})
+}
+
+// targetNum the block num sync need to reach
+func (s *syncManager) targetNum() uint32 {
+	switch s.phase {
+	case syncPhaseIrreversible:
+		return s.irrHandler.requestedEndBlock
+	case syncPhaseHeadCatchup:
+		return s.headHandler.targetHeadNum
+	}
+	return 0
+}
+
+// switchSyncPeer (IN peerLoop) use the peer to sync, re-issue the outstanding request to it
+func (c *Client) switchSyncPeer(peer *Peer) {
+	s := c.sync
+	if s.irrHandler == nil {
+		return
+	}
+
+	c.logger.Info("switch sync peer", zap.String("peer", peer.Address), zap.String("phase", s.phase.String()))
+	s.setSyncPeer(peer)
+	s.watchdog.reset(c.HeadBlockNum())
+	s.updateProgress()
+
+	var err error
+	switch s.phase {
+	case syncPhaseIrreversible:
+		if headNum := peer.headNumRecv(); headNum > s.irrHandler.originHeadBlock {
+			s.irrHandler.originHeadBlock = headNum
+		}
+		err = s.irrHandler.sendSyncRequest(peer)
+	case syncPhaseHeadCatchup:
+		s.headHandler.requestedNum = 0
+		err = s.headHandler.requestCatchup(peer, s.headHandler.targetHeadNum)
+	}
+
+	if err != nil {
+		c.logger.Error("request to new sync peer error", zap.String("peer", peer.Address), zap.Error(err))
+	}
+}
+
+// changeSyncPeer (IN peerMngLoop) change sync peer to another one, keep current if no other can use
+func (c *Client) changeSyncPeer(from *Peer, blockNum uint32) {
+	peer := c.selectSyncPeer(from, blockNum)
+	if peer == nil {
+		c.logger.Warn("no other peer to sync", zap.Uint32("need", blockNum))
+		if from == nil || c.ps[from.Address] == nil || c.ps[from.Address].status != peerStatNormal {
+			c.currentSyncPeer = nil
+			c.waitSyncPeer()
+			return
+		}
+		// request again to the peer, maybe msgs lost
+		peer = from
+	}
+
+	c.currentSyncPeer = peer
+	c.postEnvelopMsg(envelopMsg{
+		typ:    envelopMsgSwitchSyncPeer,
+		Sender: peer,
+	})
+}
+
+// onSyncStalled (IN peerMngLoop) sync peer stalled, switch to another one
+func (c *Client) onSyncStalled(msg *peerMsg) {
+	if msg.peer != c.currentSyncPeer {
+		return
+	}
+
+	c.changeSyncPeer(msg.peer, msg.blockNum)
+}
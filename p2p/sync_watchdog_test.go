@@ -0,0 +1,136 @@
+package p2p_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// hasSyncRequest is the server had received sync request from client
+func hasSyncRequest(srv *p2ptest.Server) bool {
+	for _, msg := range srv.Received() {
+		if _, ok := msg.(*types.SyncRequestMessage); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// TestSyncStallFailover test client request blocks from another peer when sync peer stalled
+func TestSyncStallFailover(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 120)
+	// handshake but never answer sync requests
+	stalled := newServerForTest(t, chain, p2ptest.WithMessageHook(func(c *p2ptest.Conn, msg types.Message) bool {
+		_, ok := msg.(*types.SyncRequestMessage)
+		return ok
+	}))
+	normal := newServerForTest(t, chain)
+	storer := newStorerForTest(t, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: stalled.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithSyncStallTimeout(200*time.Millisecond),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	// the stalled peer is the sync peer
+	waitFor(t, 5*time.Second, func() bool {
+		return hasSyncRequest(stalled)
+	})
+
+	if err := client.NewPeer(&p2p.PeerCfg{Address: normal.Addr()}); err != nil {
+		t.Fatalf("new peer error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.HeadBlockNum() == 120
+	})
+
+	if score := client.PeerScore(stalled.Addr()); score >= 0 {
+		t.Errorf("stall should be recorded to peer, got score %d", score)
+	}
+
+	cancel()
+	client.Wait()
+}
+
+// TestSyncPeerDisconnectFailover test client sync from another peer when sync peer disconnected
+func TestSyncPeerDisconnectFailover(t *testing.T) {
+	logger := zap.NewNop()
+	chain := newChainForTest(t, 120)
+
+	var (
+		mutex    sync.Mutex
+		holdConn *p2ptest.Conn
+	)
+
+	// first peer only give blocks before 60, then hold the request
+	first := newServerForTest(t, chain, p2ptest.WithMessageHook(func(c *p2ptest.Conn, msg types.Message) bool {
+		req, ok := msg.(*types.SyncRequestMessage)
+		if !ok || req.StartBlock < 60 {
+			return false
+		}
+
+		mutex.Lock()
+		holdConn = c
+		mutex.Unlock()
+		return true
+	}))
+	second := newServerForTest(t, chain)
+	storer := newStorerForTest(t, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: first.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return holdConn != nil
+	})
+
+	if err := client.NewPeer(&p2p.PeerCfg{Address: second.Addr()}); err != nil {
+		t.Fatalf("new peer error %s", err.Error())
+	}
+
+	// wait the head of second peer known
+	waitFor(t, 5*time.Second, func() bool {
+		for _, st := range client.PeerStats() {
+			if st.Address == second.Addr() && st.HeadNum == 120 {
+				return true
+			}
+		}
+		return false
+	})
+
+	mutex.Lock()
+	holdConn.Close()
+	mutex.Unlock()
+
+	// the stall timeout is default 30s, so the blocks must from the second peer after disconnected
+	waitFor(t, 5*time.Second, func() bool {
+		return client.HeadBlockNum() == 120
+	})
+
+	cancel()
+	client.Wait()
+}
@@ -0,0 +1,300 @@
+package p2p
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/store"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// maxTrxExpirations the expirations of trxs from peers to remember for waiters registered later
+const maxTrxExpirations = 4096
+
+// TrxWaitLevel the level to wait a trx
+type TrxWaitLevel uint8
+
+const (
+	// InBlock wait until the trx is included in a block
+	InBlock = TrxWaitLevel(iota)
+	// Irreversible wait until the block includes the trx become irreversible
+	Irreversible
+)
+
+// ErrTrxExpired the trx is not included before its expiration
+var ErrTrxExpired = errors.New("trx expired")
+
+// TrxResult the block includes the trx and the status of the trx receipt
+type TrxResult struct {
+	BlockNum uint32            `json:"blockNum"`
+	BlockID  Checksum256       `json:"blockID"`
+	Index    uint32            `json:"index"`
+	Status   TransactionStatus `json:"status"`
+}
+
+type trxWaitResp struct {
+	res TrxResult
+	err error
+}
+
+// trxWaiter a caller waiting a trx
+type trxWaiter struct {
+	ctx   context.Context
+	id    Checksum256
+	level TrxWaitLevel
+	resp  chan trxWaitResp
+
+	// expiration the expiration given by caller, if zero use the one from peers
+	expiration time.Time
+
+	// included the block includes the trx, waiting it become irreversible
+	included *TrxResult
+}
+
+func (w *trxWaiter) done(res TrxResult, err error) {
+	w.resp <- trxWaitResp{
+		res: res,
+		err: err,
+	}
+}
+
+// trxWaits waiters for trxs, a BlockHandler for the block stream, all funcs are called IN peerLoop
+type trxWaits struct {
+	cli     *Client
+	waiters map[string][]*trxWaiter
+
+	// expirations the expirations of trxs from peers, trx not included before it will fail
+	expirations map[string]time.Time
+	expRing     []string
+	expIdx      int
+}
+
+func newTrxWaits(cli *Client) *trxWaits {
+	return &trxWaits{
+		cli:         cli,
+		waiters:     make(map[string][]*trxWaiter, 16),
+		expirations: make(map[string]time.Time, maxTrxExpirations),
+		expRing:     make([]string, maxTrxExpirations),
+	}
+}
+
+// Name implements BlockHandler interface
+func (t *trxWaits) Name() string {
+	return "trxWaits"
+}
+
+// OnBlockEvent implements BlockHandler interface
+func (t *trxWaits) OnBlockEvent(ev *BlockEvent) {
+	if len(t.waiters) == 0 {
+		return
+	}
+
+	switch ev.Type {
+	case BlockEventNew:
+		t.onNewBlock(ev)
+	case BlockEventUndo:
+		t.each(func(w *trxWaiter) bool {
+			if w.included != nil && types.IsChecksumEq(w.included.BlockID, ev.BlockID) {
+				w.included = nil
+			}
+			return false
+		})
+	case BlockEventIrreversible:
+		t.each(func(w *trxWaiter) bool {
+			if w.included != nil && types.IsChecksumEq(w.included.BlockID, ev.BlockID) {
+				w.done(*w.included, nil)
+				return true
+			}
+			return false
+		})
+	}
+}
+
+func (t *trxWaits) onNewBlock(ev *BlockEvent) {
+	for idx, receipt := range ev.Block.Transactions {
+		ws, ok := t.waiters[string(receipt.Transaction.ID)]
+		if !ok {
+			continue
+		}
+
+		res := TrxResult{
+			BlockNum: ev.BlockNum,
+			BlockID:  ev.BlockID,
+			Index:    uint32(idx),
+			Status:   receipt.Status,
+		}
+
+		for _, w := range ws {
+			w.included = &res
+		}
+	}
+
+	blockTime := ev.Block.Timestamp.Time
+	t.each(func(w *trxWaiter) bool {
+		if w.included != nil {
+			if w.level == InBlock {
+				w.done(*w.included, nil)
+				return true
+			}
+			return false
+		}
+
+		if exp, ok := t.expiration(w); ok && blockTime.After(exp) {
+			w.done(TrxResult{}, errors.Wrapf(ErrTrxExpired, "expired at %s, block %d at %s",
+				exp.UTC(), ev.BlockNum, blockTime.UTC()))
+			return true
+		}
+
+		return false
+	})
+}
+
+// expiration the expiration of the trx waiting, given by caller or from peers
+func (t *trxWaits) expiration(w *trxWaiter) (time.Time, bool) {
+	if !w.expiration.IsZero() {
+		return w.expiration, true
+	}
+
+	exp, ok := t.expirations[string(w.id)]
+	return exp, ok
+}
+
+// each call f by the waiters, remove the waiters if f return true or the caller canceled
+func (t *trxWaits) each(f func(w *trxWaiter) bool) {
+	for key, ws := range t.waiters {
+		kept := ws[:0]
+		for _, w := range ws {
+			if w.ctx.Err() != nil || f(w) {
+				continue
+			}
+			kept = append(kept, w)
+		}
+
+		if len(kept) == 0 {
+			delete(t.waiters, key)
+		} else {
+			t.waiters[key] = kept
+		}
+	}
+}
+
+// onPackedTrx remember the expiration of the trx from peers
+func (t *trxWaits) onPackedTrx(msg *PackedTransactionMessage) {
+	id, err := types.TransactionID(&msg.PackedTransaction)
+	if err != nil {
+		return
+	}
+
+	key := string(id)
+	if _, ok := t.expirations[key]; ok {
+		return
+	}
+
+	trx, err := types.DecodeTransaction(&msg.PackedTransaction)
+	if err != nil {
+		t.cli.logger.Debug("unpack trx error", zap.String("id", id.String()), zap.Error(err))
+		return
+	}
+
+	if old := t.expRing[t.expIdx]; old != "" {
+		delete(t.expirations, old)
+	}
+	t.expRing[t.expIdx] = key
+	t.expIdx = (t.expIdx + 1) % len(t.expRing)
+	t.expirations[key] = trx.Expiration.Time
+}
+
+// add add the waiter, if the trx is in storer, the waiter may be done at once
+func (t *trxWaits) add(w *trxWaiter) {
+	if res, ok := t.findInStorer(w.id); ok {
+		if w.level == InBlock || res.BlockNum <= t.cli.stream.rootNum {
+			w.done(res, nil)
+			return
+		}
+		w.included = &res
+	}
+
+	key := string(w.id)
+	t.waiters[key] = append(t.waiters[key], w)
+}
+
+// findInStorer find the trx by the trx index of storer
+func (t *trxWaits) findInStorer(id Checksum256) (TrxResult, bool) {
+	ts, ok := t.cli.blkStorer.(store.TransactionStorer)
+	if !ok {
+		return TrxResult{}, false
+	}
+
+	loc, ok, err := ts.GetTransaction(id)
+	if err != nil || !ok {
+		return TrxResult{}, false
+	}
+
+	blk, ok := t.cli.blkStorer.GetBlockByNum(loc.BlockNum)
+	if !ok || int(loc.Index) >= len(blk.Transactions) {
+		return TrxResult{}, false
+	}
+
+	return TrxResult{
+		BlockNum: loc.BlockNum,
+		BlockID:  loc.BlockID,
+		Index:    loc.Index,
+		Status:   blk.Transactions[loc.Index].Status,
+	}, true
+}
+
+// WaitForTransaction wait the trx until it is included in a block or the block become irreversible by level,
+// it fails by ErrTrxExpired if a block after the expiration of trx comes before the trx included,
+// the expiration is known if the trx had been relayed by peers, use WaitForTransactionWithExpiration
+// or WaitForPackedTransaction for the trx not relayed
+func (c *Client) WaitForTransaction(ctx context.Context, trxID Checksum256, level TrxWaitLevel) (TrxResult, error) {
+	return c.WaitForTransactionWithExpiration(ctx, trxID, time.Time{}, level)
+}
+
+// WaitForTransactionWithExpiration wait the trx like WaitForTransaction by the expiration given,
+// if expiration is zero the one from peers is used
+func (c *Client) WaitForTransactionWithExpiration(ctx context.Context,
+	trxID Checksum256, expiration time.Time, level TrxWaitLevel) (TrxResult, error) {
+	w := &trxWaiter{
+		ctx:        ctx,
+		id:         trxID,
+		level:      level,
+		resp:       make(chan trxWaitResp, 1),
+		expiration: expiration,
+	}
+
+	if !c.postEnvelopMsg(envelopMsg{
+		typ:       envelopMsgWaitTrx,
+		trxWaiter: w,
+	}) {
+		return TrxResult{}, errors.New("client stopped")
+	}
+
+	select {
+	case resp := <-w.resp:
+		return resp.res, resp.err
+	case <-ctx.Done():
+		return TrxResult{}, ctx.Err()
+	case <-c.loopDone:
+		return TrxResult{}, errors.New("client stopped")
+	}
+}
+
+// WaitForPackedTransaction wait the trx like WaitForTransaction, the id and expiration are from the trx
+func (c *Client) WaitForPackedTransaction(ctx context.Context,
+	trx *PackedTransaction, level TrxWaitLevel) (TrxResult, error) {
+	id, err := types.TransactionID(trx)
+	if err != nil {
+		return TrxResult{}, errors.Wrap(err, "trx id")
+	}
+
+	unpacked, err := types.DecodeTransaction(trx)
+	if err != nil {
+		return TrxResult{}, errors.Wrap(err, "unpack trx")
+	}
+
+	return c.WaitForTransactionWithExpiration(ctx, id, unpacked.Expiration.Time, level)
+}
@@ -0,0 +1,175 @@
+package p2p_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	eos "github.com/eoscanada/eos-go"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/p2p"
+	"github.com/fanyang1988/eos-p2p/p2ptest"
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+func newTransferForTest(memo byte) []*types.Action {
+	return []*types.Action{{
+		Account:       types.AccountName("eosio.token"),
+		Name:          types.ActionName("transfer"),
+		Authorization: []types.PermissionLevel{{Actor: types.AccountName("alice"), Permission: eos.PermissionName("active")}},
+		ActionData:    eos.NewActionDataFromHexData([]byte{memo}),
+	}}
+}
+
+// newPackedTrxForTest a trx expired at the time
+func newPackedTrxForTest(t *testing.T, expiration time.Time) *types.PackedTransactionMessage {
+	trx := eos.NewTransaction(newTransferForTest(0xff), &eos.TxOptions{
+		HeadBlockID: make(types.Checksum256, 32),
+	})
+	trx.Expiration = eos.JSONTime{Time: expiration}
+
+	packed, err := eos.NewSignedTransaction(trx).Pack(eos.CompressionNone)
+	if err != nil {
+		t.Fatalf("pack trx error %s", err.Error())
+	}
+
+	return &types.PackedTransactionMessage{PackedTransaction: *packed}
+}
+
+type trxWaitResult struct {
+	res p2p.TrxResult
+	err error
+}
+
+func waitTrxForTest(client *p2p.Client, id types.Checksum256, level p2p.TrxWaitLevel) <-chan trxWaitResult {
+	res := make(chan trxWaitResult, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		r, err := client.WaitForTransaction(ctx, id, level)
+		res <- trxWaitResult{res: r, err: err}
+	}()
+	return res
+}
+
+// TestWaitForTransaction test wait a trx included, irreversible and expired, relayed by peers or not
+func TestWaitForTransaction(t *testing.T) {
+	logger := zap.NewNop()
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForTest), producersForTest(),
+		types.WithGenTransactions(func(blockNum uint32) [][]*types.Action {
+			if blockNum != 125 {
+				return nil
+			}
+			return [][]*types.Action{newTransferForTest(1)}
+		}))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	blocks, err := gen.GenerateTo(130)
+	if err != nil {
+		t.Fatalf("generate error %s", err.Error())
+	}
+
+	chain, err := p2ptest.NewChain(blocks[:120])
+	if err != nil {
+		t.Fatalf("new chain error %s", err.Error())
+	}
+
+	srv := newServerForTest(t, chain)
+	storer := newStorerForTest(t, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := p2p.NewClient(ctx, chainIDForTest,
+		[]*p2p.PeerCfg{{Address: srv.Addr()}},
+		p2p.WithLogger(logger),
+		p2p.WithNeedSync(1),
+		p2p.WithStorer(storer))
+	if err != nil {
+		t.Fatalf("new client error %s", err.Error())
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return client.SyncStatus().Phase == "live"
+	})
+
+	// the trx never included, expired after block 122
+	expired := newPackedTrxForTest(t, blocks[121].Timestamp.Time)
+	expiredID, _ := expired.ID()
+
+	// the trx never relayed by peers, expired after block 123
+	notRelayed := newPackedTrxForTest(t, blocks[122].Timestamp.Time)
+	notRelayedExpiring := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		_, err := client.WaitForPackedTransaction(ctx, &notRelayed.PackedTransaction, p2p.InBlock)
+		notRelayedExpiring <- err
+	}()
+
+	trxID := blocks[124].Transactions[0].Transaction.ID
+	inBlock := waitTrxForTest(client, trxID, p2p.InBlock)
+	irreversible := waitTrxForTest(client, trxID, p2p.Irreversible)
+	expiring := waitTrxForTest(client, expiredID, p2p.InBlock)
+
+	// wait the waiters registered
+	time.Sleep(100 * time.Millisecond)
+
+	if err := srv.Broadcast(expired); err != nil {
+		t.Fatalf("broadcast trx error %s", err.Error())
+	}
+
+	for _, blk := range blocks[120:] {
+		if err := srv.Broadcast(blk); err != nil {
+			t.Fatalf("broadcast block error %s", err.Error())
+		}
+	}
+
+	r := <-inBlock
+	if r.err != nil || r.res.BlockNum != 125 || r.res.Status != eos.TransactionStatusExecuted {
+		t.Fatalf("trx should in block 125, got %d %v", r.res.BlockNum, r.err)
+	}
+
+	r = <-expiring
+	if errors.Cause(r.err) != p2p.ErrTrxExpired {
+		t.Fatalf("trx should expired, got %v", r.err)
+	}
+
+	if err := <-notRelayedExpiring; errors.Cause(err) != p2p.ErrTrxExpired {
+		t.Fatalf("trx not relayed should expired, got %v", err)
+	}
+
+	select {
+	case r = <-irreversible:
+		t.Fatalf("trx should not be irreversible before lib, got %d %v", r.res.BlockNum, r.err)
+	default:
+	}
+
+	// the sync peer handshake again with lib 130
+	full, err := p2ptest.NewChain(blocks)
+	if err != nil {
+		t.Fatalf("new chain error %s", err.Error())
+	}
+
+	srv.SetChain(full)
+	if err := srv.Broadcast(srv.Handshake()); err != nil {
+		t.Fatalf("broadcast handshake error %s", err.Error())
+	}
+
+	r = <-irreversible
+	if r.err != nil || r.res.BlockNum != 125 {
+		t.Fatalf("trx should irreversible in block 125, got %d %v", r.res.BlockNum, r.err)
+	}
+
+	id, _ := blocks[124].BlockID()
+	if !types.IsChecksumEq(r.res.BlockID, id) {
+		t.Errorf("block id diff")
+	}
+
+	cancel()
+	client.Wait()
+}
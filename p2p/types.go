@@ -31,9 +31,27 @@ type SignedBlock = types.SignedBlock
 // PackedTransactionMessage eos msg type
 type PackedTransactionMessage = types.PackedTransactionMessage
 
+// PackedTransaction eos type
+type PackedTransaction = types.PackedTransaction
+
 // Packet eos msg type
 type Packet = types.Packet
 
+// TransactionStatus eos type
+type TransactionStatus = types.TransactionStatus
+
+// Action eos type
+type Action = types.Action
+
+// AccountName eos type
+type AccountName = types.AccountName
+
+// ActionName eos type
+type ActionName = types.ActionName
+
+// ABI eos type
+type ABI = types.ABI
+
 // Checksum256 checksum256 from eos
 type Checksum256 = types.Checksum256
 
@@ -63,6 +81,18 @@ const (
 	goAwayBenignOther    = types.GoAwayBenignOther
 )
 
+// IDListMode eos type
+type IDListMode = types.IDListMode
+
+const (
+	// id list modes in notice and request msg
+
+	idListNone           = types.IDListModeNone
+	idListCatchUp        = types.IDListModeCatchUp
+	idListLastIrrCatchUp = types.IDListModeLastIrrCatchUp
+	idListNormal         = types.IDListModeNormal
+)
+
 // CurveK1 ecc types
 const CurveK1 = types.CurveK1
 
@@ -0,0 +1,133 @@
+package p2ptest
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// Chain a linked list of blocks served by the mock peer, blocks[0] is block 1
+type Chain struct {
+	blocks []*types.SignedBlock
+	gen    *types.ChainGenerator
+}
+
+// NewChain create a chain by blocks, blocks should be linked and start from block 1
+func NewChain(blocks []*types.SignedBlock) (*Chain, error) {
+	for idx, blk := range blocks {
+		if blk.BlockNumber() != uint32(idx+1) {
+			return nil, errors.Errorf("block at %d has num %d", idx, blk.BlockNumber())
+		}
+
+		if idx == 0 {
+			continue
+		}
+
+		prevID, err := blocks[idx-1].BlockID()
+		if err != nil {
+			return nil, errors.Wrapf(err, "block id %d", idx)
+		}
+
+		if !types.IsChecksumEq(prevID, blk.Previous) {
+			return nil, errors.Errorf("block %d is not linked to previous", blk.BlockNumber())
+		}
+	}
+
+	return &Chain{
+		blocks: blocks,
+	}, nil
+}
+
+// NewChainByGenerator create a chain by the blocks generated, the chain can be forked by generator
+func NewChainByGenerator(gen *types.ChainGenerator) *Chain {
+	return &Chain{
+		blocks: gen.Blocks(),
+		gen:    gen,
+	}
+}
+
+// GenerateChain generate a linked chain from block 1 to headNum with empty blocks
+func GenerateChain(chainID types.Checksum256, headNum uint32) (*Chain, error) {
+	gen, err := types.NewChainGenerator(chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := gen.GenerateTo(headNum); err != nil {
+		return nil, errors.Wrap(err, "generate")
+	}
+
+	return NewChainByGenerator(gen), nil
+}
+
+// HeadBlockNum the num of head block in chain
+func (c *Chain) HeadBlockNum() uint32 {
+	return uint32(len(c.blocks))
+}
+
+// HeadBlock the head block in chain, nil if chain is empty
+func (c *Chain) HeadBlock() *types.SignedBlock {
+	if len(c.blocks) == 0 {
+		return nil
+	}
+	return c.blocks[len(c.blocks)-1]
+}
+
+// GetBlockByNum get block by num
+func (c *Chain) GetBlockByNum(num uint32) (*types.SignedBlock, bool) {
+	if num == 0 || num > uint32(len(c.blocks)) {
+		return nil, false
+	}
+	return c.blocks[num-1], true
+}
+
+// GetBlockByID get block by id
+func (c *Chain) GetBlockByID(id types.Checksum256) (*types.SignedBlock, bool) {
+	if len(id) < 4 {
+		return nil, false
+	}
+
+	blk, ok := c.GetBlockByNum(binary.BigEndian.Uint32(id[:4]))
+	if !ok {
+		return nil, false
+	}
+
+	blkID, _ := blk.BlockID()
+	if !types.IsChecksumEq(blkID, id) {
+		return nil, false
+	}
+
+	return blk, true
+}
+
+// Blocks all blocks in chain
+func (c *Chain) Blocks() []*types.SignedBlock {
+	return c.blocks
+}
+
+// Fork create a new chain which shares blocks before forkNum with c and has
+// different blocks from forkNum to headNum, only chain by generator can fork
+func (c *Chain) Fork(forkNum uint32, headNum uint32) (*Chain, error) {
+	if c.gen == nil {
+		return nil, errors.New("chain is not made by generator")
+	}
+
+	gen, err := c.gen.Fork(forkNum)
+	if err != nil {
+		return nil, errors.Wrap(err, "fork")
+	}
+
+	if _, err := gen.GenerateTo(headNum); err != nil {
+		return nil, errors.Wrap(err, "generate")
+	}
+
+	return NewChainByGenerator(gen), nil
+}
+
+// Extend create a new chain which has all blocks of c and new blocks to headNum,
+// only chain by generator can extend
+func (c *Chain) Extend(headNum uint32) (*Chain, error) {
+	return c.Fork(c.HeadBlockNum()+1, headNum)
+}
@@ -0,0 +1,479 @@
+package p2ptest
+
+import (
+	"bufio"
+	"crypto/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// Behavior how the mock peer behaves to requests
+type Behavior uint8
+
+const (
+	// BehaviorNormal answers handshakes and sync requests like nodeos
+	BehaviorNormal = Behavior(iota)
+	// BehaviorStall reads all messages but never answers them
+	BehaviorStall
+	// BehaviorGoAway answers the first handshake by a go away message then closes
+	BehaviorGoAway
+)
+
+// Server a in-process mock nodeos peer which speaks the net protocol,
+// it answers handshakes, serves sync requests from a chain and can be
+// scripted to misbehave, so the client can be tested with no network.
+type Server struct {
+	chainID  types.Checksum256
+	nodeID   types.Checksum256
+	listener net.Listener
+	logger   *zap.Logger
+
+	mutex        sync.RWMutex
+	chain        *Chain
+	behavior     Behavior
+	goAwayReason types.GoAwayReason
+	conns        map[*Conn]struct{}
+	received     []types.Message
+	onMessage    func(conn *Conn, msg types.Message) bool
+	p2pAddress   string
+	libLag       uint32
+
+	wg sync.WaitGroup
+}
+
+// ServerOption option for new server
+type ServerOption func(*Server)
+
+// WithLogger set logger for server
+func WithLogger(logger *zap.Logger) ServerOption {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithBehavior set the behavior of server when start
+func WithBehavior(behavior Behavior) ServerOption {
+	return func(s *Server) {
+		s.behavior = behavior
+	}
+}
+
+// WithGoAwayReason set the reason of go away message when BehaviorGoAway
+func WithGoAwayReason(reason types.GoAwayReason) ServerOption {
+	return func(s *Server) {
+		s.goAwayReason = reason
+	}
+}
+
+// WithP2PAddress set the p2p address in handshake, default is the listen address
+func WithP2PAddress(address string) ServerOption {
+	return func(s *Server) {
+		s.p2pAddress = address
+	}
+}
+
+// WithMessageHook set a hook called for each message received, if it returns true
+// the server will not process the message by itself
+func WithMessageHook(hook func(conn *Conn, msg types.Message) bool) ServerOption {
+	return func(s *Server) {
+		s.onMessage = hook
+	}
+}
+
+// WithLIBLag set the lib in handshake to the block lag behind head, default lib is the head
+func WithLIBLag(lag uint32) ServerOption {
+	return func(s *Server) {
+		s.libLag = lag
+	}
+}
+
+// WithListener serve on the listener, such as a in-memory one, instead of a random local port
+func WithListener(listener net.Listener) ServerOption {
+	return func(s *Server) {
+		s.listener = listener
+	}
+}
+
+// NewServer create a mock peer server listen on a random local port
+func NewServer(chainID types.Checksum256, chain *Chain, opts ...ServerOption) (*Server, error) {
+	nodeID := make([]byte, 32)
+	if _, err := rand.Read(nodeID); err != nil {
+		return nil, errors.Wrap(err, "generating random node id error")
+	}
+
+	res := &Server{
+		chainID:      chainID,
+		nodeID:       nodeID,
+		logger:       zap.NewNop(),
+		chain:        chain,
+		goAwayReason: types.GoAwayNoReason,
+		conns:        make(map[*Conn]struct{}, 8),
+		received:     make([]types.Message, 0, 256),
+	}
+
+	for _, o := range opts {
+		o(res)
+	}
+
+	if res.listener == nil {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, errors.Wrap(err, "listen")
+		}
+		res.listener = listener
+	}
+
+	res.wg.Add(1)
+	go func() {
+		defer res.wg.Done()
+		res.acceptLoop()
+	}()
+
+	return res, nil
+}
+
+// Addr the address for client to connect
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Chain current chain served by server
+func (s *Server) Chain() *Chain {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.chain
+}
+
+// SetChain change the chain served by server, use it with Chain.Fork to make a fork
+func (s *Server) SetChain(chain *Chain) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.chain = chain
+}
+
+// SetBehavior change the behavior for next messages
+func (s *Server) SetBehavior(behavior Behavior) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.behavior = behavior
+}
+
+// Received all messages received from clients
+func (s *Server) Received() []types.Message {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	res := make([]types.Message, len(s.received))
+	copy(res, s.received)
+	return res
+}
+
+// Conns all connections current
+func (s *Server) Conns() []*Conn {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	res := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		res = append(res, c)
+	}
+	return res
+}
+
+// Broadcast send msg to all connections
+func (s *Server) Broadcast(msg types.Message) error {
+	for _, c := range s.Conns() {
+		if err := c.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendNotice send a notice with head and lib num to all connections, like nodeos when it is ahead of the lib of peer
+func (s *Server) SendNotice(headNum, libNum uint32) error {
+	return s.Broadcast(types.NewLastIrrCatchupNotice(libNum, headNum))
+}
+
+// SendCatchupNotice send a notice with head num and id of chain to all connections,
+// like nodeos when it is ahead of peer, the client should request the blocks it need
+func (s *Server) SendCatchupNotice() error {
+	head := s.Chain().HeadBlock()
+	if head == nil {
+		return errors.New("no head block")
+	}
+
+	headID, err := head.BlockID()
+	if err != nil {
+		return errors.Wrap(err, "head id")
+	}
+
+	return s.Broadcast(types.NewCatchupNotice(head.BlockNumber(), headID))
+}
+
+// GoAway send go away to all connections then close them
+func (s *Server) GoAway(reason types.GoAwayReason) {
+	for _, c := range s.Conns() {
+		c.Send(&types.GoAwayMessage{
+			Reason: reason,
+			NodeID: s.nodeID,
+		})
+		c.Close()
+	}
+}
+
+// Close stop listen and close all connections
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	for _, c := range s.Conns() {
+		c.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) acceptLoop() {
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			s.logger.Debug("mock peer accept stop", zap.Error(err))
+			return
+		}
+
+		c := &Conn{
+			srv:  s,
+			conn: netConn,
+		}
+
+		s.mutex.Lock()
+		s.conns[c] = struct{}{}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.readLoop()
+		}()
+	}
+}
+
+// Handshake the handshake message the server sends, head and lib are from its chain
+func (s *Server) Handshake() *types.HandshakeMessage {
+	chain := s.Chain()
+	res := &types.HandshakeMessage{
+		NetworkVersion:          1206,
+		ChainID:                 s.chainID,
+		NodeID:                  s.nodeID,
+		Key:                     types.MustNewPublicKey("EOS1111111111111111111111111111111114T1Anm"),
+		Time:                    types.Tstamp{Time: time.Now()},
+		Token:                   make([]byte, 32),
+		Signature:               types.NewEmptySignature(),
+		P2PAddress:              s.Addr() + " - p2ptest",
+		LastIrreversibleBlockID: make([]byte, 32),
+		HeadID:                  make([]byte, 32),
+		OS:                      "linux",
+		Agent:                   "p2ptest",
+		Generation:              1,
+	}
+
+	if s.p2pAddress != "" {
+		res.P2PAddress = s.p2pAddress
+	}
+
+	if head := chain.HeadBlock(); head != nil {
+		res.HeadNum = head.BlockNumber()
+		res.HeadID, _ = head.BlockID()
+		res.LastIrreversibleBlockNum = res.HeadNum
+		res.LastIrreversibleBlockID = res.HeadID
+
+		if lib, ok := chain.GetBlockByNum(s.libNum(chain)); ok {
+			res.LastIrreversibleBlockNum = lib.BlockNumber()
+			res.LastIrreversibleBlockID, _ = lib.BlockID()
+		}
+	}
+
+	return res
+}
+
+// libNum the lib of chain, it is behind the head by lib lag
+func (s *Server) libNum(chain *Chain) uint32 {
+	headNum := chain.HeadBlockNum()
+	if s.libLag > 0 && headNum > s.libLag {
+		return headNum - s.libLag
+	}
+	return headNum
+}
+
+func (s *Server) onMsg(c *Conn, msg types.Message) error {
+	s.mutex.Lock()
+	s.received = append(s.received, msg)
+	behavior := s.behavior
+	hook := s.onMessage
+	s.mutex.Unlock()
+
+	if hook != nil && hook(c, msg) {
+		return nil
+	}
+
+	if behavior == BehaviorStall {
+		return nil
+	}
+
+	switch m := msg.(type) {
+	case *types.HandshakeMessage:
+		return c.onHandshake(m, behavior)
+	case *types.TimeMessage:
+		// like nodeos, only answer the time msg which is a original request
+		if m.Origin.UnixNano() > 0 {
+			return nil
+		}
+		return c.Send(&types.TimeMessage{
+			Origin:   m.Transmit,
+			Receive:  types.Tstamp{Time: time.Now()},
+			Transmit: types.Tstamp{Time: time.Now()},
+		})
+	case *types.SyncRequestMessage:
+		return c.onSyncRequest(m)
+	case *types.RequestMessage:
+		return c.onRequest(m)
+	case *types.GoAwayMessage:
+		return errors.Errorf("go away by %s", m.Reason)
+	}
+
+	return nil
+}
+
+// Conn a connection from client to the server
+type Conn struct {
+	srv  *Server
+	conn net.Conn
+
+	writeMutex    sync.Mutex
+	handshakeSent bool
+}
+
+// Send send a msg to client
+func (c *Conn) Send(msg types.Message) error {
+	data, err := types.EncodePacket(msg)
+	if err != nil {
+		return err
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if _, err := c.conn.Write(data); err != nil {
+		return errors.Wrap(err, "write msg")
+	}
+
+	return nil
+}
+
+// Close close the connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) readLoop() {
+	defer func() {
+		c.conn.Close()
+		c.srv.mutex.Lock()
+		delete(c.srv.conns, c)
+		c.srv.mutex.Unlock()
+	}()
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		packet, err := types.ReadChainPacket(reader, c.conn)
+		if err != nil {
+			c.srv.logger.Debug("mock peer read stop", zap.Error(err))
+			return
+		}
+
+		if err := c.srv.onMsg(c, packet.P2PMessage); err != nil {
+			c.srv.logger.Debug("mock peer process stop", zap.Error(err))
+			return
+		}
+	}
+}
+
+func (c *Conn) onHandshake(msg *types.HandshakeMessage, behavior Behavior) error {
+	if !types.IsChecksumEq(msg.ChainID, c.srv.chainID) {
+		c.Send(&types.GoAwayMessage{
+			Reason: types.GoAwayWrongChain,
+			NodeID: c.srv.nodeID,
+		})
+		return errors.New("wrong chain")
+	}
+
+	if behavior == BehaviorGoAway {
+		c.Send(&types.GoAwayMessage{
+			Reason: c.srv.goAwayReason,
+			NodeID: c.srv.nodeID,
+		})
+		return errors.New("go away")
+	}
+
+	// like nodeos, only answer the first handshake from peer
+	if c.handshakeSent {
+		return nil
+	}
+	c.handshakeSent = true
+
+	return c.Send(c.srv.Handshake())
+}
+
+func (c *Conn) onSyncRequest(msg *types.SyncRequestMessage) error {
+	chain := c.srv.Chain()
+	for num := msg.StartBlock; num <= msg.EndBlock; num++ {
+		blk, ok := chain.GetBlockByNum(num)
+		if !ok {
+			return nil
+		}
+
+		if err := c.Send(blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) onRequest(msg *types.RequestMessage) error {
+	chain := c.srv.Chain()
+
+	// catch up mode, like nodeos send the blocks after the last id to head
+	if types.GetIDListMode(&msg.ReqBlocks) == types.IDListModeCatchUp {
+		if len(msg.ReqBlocks.IDs) == 0 {
+			return nil
+		}
+
+		// like nodeos, the peer is on another fork if the id is unknown, send from the lib
+		startNum := c.srv.libNum(chain) + 1
+		if from, ok := chain.GetBlockByID(msg.ReqBlocks.IDs[len(msg.ReqBlocks.IDs)-1]); ok {
+			startNum = from.BlockNumber() + 1
+		}
+
+		return c.onSyncRequest(&types.SyncRequestMessage{
+			StartBlock: startNum,
+			EndBlock:   chain.HeadBlockNum(),
+		})
+	}
+
+	for _, id := range msg.ReqBlocks.IDs {
+		blk, ok := chain.GetBlockByID(id)
+		if !ok {
+			continue
+		}
+
+		if err := c.Send(blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
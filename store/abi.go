@@ -0,0 +1,111 @@
+package store
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// abisBucket bucket for the abis set by setabi actions
+const abisBucket = "abis"
+
+// ABIRecord a abi of account set in a trx of block, ABI is the binary abi in setabi, empty if abi cleared
+type ABIRecord struct {
+	Account  string            `json:"account"`
+	BlockNum uint32            `json:"blockNum"`
+	TrxIndex uint32            `json:"trxIndex"`
+	BlockID  types.Checksum256 `json:"blockID"`
+	ABI      []byte            `json:"abi"`
+}
+
+// key key by the position in chain, so records are ordered by it
+func (r *ABIRecord) key() []byte {
+	res := make([]byte, 8, 8+len(r.Account))
+	binary.BigEndian.PutUint32(res, r.BlockNum)
+	binary.BigEndian.PutUint32(res[4:], r.TrxIndex)
+	return append(res, []byte(r.Account)...)
+}
+
+// ABIStorer storer which can persist the abis of accounts
+type ABIStorer interface {
+	PutABI(record *ABIRecord) error
+	DeleteABIs(blockNum uint32, blockID types.Checksum256) error
+	LoadABIs() ([]*ABIRecord, error)
+}
+
+// PutABI persist the abi record
+func (s *BBoltStorer) PutABI(record *ABIRecord) error {
+	data, err := types.EncodeToEOS(record)
+	if err != nil {
+		return errors.Wrap(err, "encode abi record")
+	}
+
+	return errors.Wrapf(s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(abisBucket))
+		if err != nil {
+			return errors.Wrap(err, "create bucket")
+		}
+
+		return bucket.Put(record.key(), data)
+	}), "put abi of %s in %d", record.Account, record.BlockNum)
+}
+
+// DeleteABIs delete the abi records set in the block, such as it is undone by switching fork
+func (s *BBoltStorer) DeleteABIs(blockNum uint32, blockID types.Checksum256) error {
+	prefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(prefix, blockNum)
+
+	return errors.Wrapf(s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(abisBucket))
+		if bucket == nil {
+			return nil
+		}
+
+		keys := make([][]byte, 0, 1)
+		c := bucket.Cursor()
+		for k, v := c.Seek(prefix); k != nil && binary.BigEndian.Uint32(k) == blockNum; k, v = c.Next() {
+			var record ABIRecord
+			if err := types.NewDecoder(v).Decode(&record); err != nil {
+				return errors.Wrap(err, "decode abi record")
+			}
+
+			if types.IsChecksumEq(record.BlockID, blockID) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+		}
+
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}), "delete abis in %d", blockNum)
+}
+
+// LoadABIs load all abi records by the order of position in chain
+func (s *BBoltStorer) LoadABIs() ([]*ABIRecord, error) {
+	res := make([]*ABIRecord, 0, 16)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(abisBucket))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			record := &ABIRecord{}
+			if err := types.NewDecoder(v).Decode(record); err != nil {
+				return errors.Wrap(err, "decode abi record")
+			}
+
+			res = append(res, record)
+			return nil
+		})
+	})
+
+	return res, errors.Wrap(err, "load abis")
+}
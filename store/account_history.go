@@ -0,0 +1,255 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// accountHistoryBucket bucket for the actions of accounts, only used if account history enabled
+const accountHistoryBucket = "accountHistory"
+
+// AccountActionPos the position of an action in chain
+type AccountActionPos struct {
+	BlockNum    uint32 `json:"blockNum"`
+	TrxIndex    uint32 `json:"trxIndex"`
+	ActionIndex uint32 `json:"actionIndex"`
+}
+
+// AccountAction an action the account appears as contract or authorizer
+type AccountAction struct {
+	Account string            `json:"account"`
+	Pos     AccountActionPos  `json:"pos"`
+	TrxID   types.Checksum256 `json:"trxID"`
+	BlockID types.Checksum256 `json:"blockID"`
+}
+
+// AccountHistoryQuery query for a page of the actions of account, ordered by the position in chain
+type AccountHistoryQuery struct {
+	Account string
+	// Cursor the actions after it (before it if IsDesc) are returned, use the pos of last action to get next page,
+	// zero value means from the first action (or the last if IsDesc)
+	Cursor AccountActionPos
+	Limit  int
+	IsDesc bool
+}
+
+// AccountHistoryStorer storer which index the actions of accounts in blocks committed
+type AccountHistoryStorer interface {
+	GetAccountActions(query AccountHistoryQuery) ([]*AccountAction, error)
+	UndoBlockActions(blk *types.SignedBlock) error
+}
+
+// accountKeyPrefix the prefix of keys of account, the len of account make the prefix not overlapped
+func accountKeyPrefix(account string) []byte {
+	res := make([]byte, 0, 1+len(account)+12)
+	res = append(res, byte(len(account)))
+	return append(res, account...)
+}
+
+// accountActionKey key by account and the position, so the actions of account are ordered
+func accountActionKey(account string, pos AccountActionPos) []byte {
+	res := accountKeyPrefix(account)
+	var buf [12]byte
+	binary.BigEndian.PutUint32(buf[:], pos.BlockNum)
+	binary.BigEndian.PutUint32(buf[4:], pos.TrxIndex)
+	binary.BigEndian.PutUint32(buf[8:], pos.ActionIndex)
+	return append(res, buf[:]...)
+}
+
+// blockAccountActions the actions in executed trxs of block for each account appears as contract or authorizer
+func blockAccountActions(logger *zap.Logger, blk *types.SignedBlock, blockID types.Checksum256) []*AccountAction {
+	res := make([]*AccountAction, 0, len(blk.Transactions)*2)
+	for trxIdx, receipt := range blk.Transactions {
+		if receipt.Transaction.Packed == nil || receipt.Status != types.TransactionStatusExecuted {
+			continue
+		}
+
+		trx, err := types.DecodeTransaction(receipt.Transaction.Packed)
+		if err != nil {
+			logger.Debug("decode trx error", zap.Uint32("block", blk.BlockNumber()), zap.Error(err))
+			continue
+		}
+
+		for actIdx, act := range trx.Actions {
+			accounts := make(map[string]bool, 1+len(act.Authorization))
+			accounts[string(act.Account)] = true
+			for _, auth := range act.Authorization {
+				accounts[string(auth.Actor)] = true
+			}
+
+			for account := range accounts {
+				res = append(res, &AccountAction{
+					Account: account,
+					Pos: AccountActionPos{
+						BlockNum:    blk.BlockNumber(),
+						TrxIndex:    uint32(trxIdx),
+						ActionIndex: uint32(actIdx),
+					},
+					TrxID:   receipt.Transaction.ID,
+					BlockID: blockID,
+				})
+			}
+		}
+	}
+
+	return res
+}
+
+// indexBlockActions index the actions in the block by accounts
+func (s *BBoltStorer) indexBlockActions(tx *bolt.Tx, blk *types.SignedBlock, blockID types.Checksum256) error {
+	actions := blockAccountActions(s.logger, blk, blockID)
+	if len(actions) == 0 {
+		return nil
+	}
+
+	bucket, err := tx.CreateBucketIfNotExists([]byte(accountHistoryBucket))
+	if err != nil {
+		return errors.Wrap(err, "create account history bucket")
+	}
+
+	for _, act := range actions {
+		data, err := types.EncodeToEOS(act)
+		if err != nil {
+			return errors.Wrap(err, "encode account action")
+		}
+
+		if err := bucket.Put(accountActionKey(act.Account, act.Pos), data); err != nil {
+			return errors.Wrapf(err, "put action of %s", act.Account)
+		}
+	}
+
+	return nil
+}
+
+// unindexBlockActions remove the actions in the block, if the position is indexed to other block, keep it
+func (s *BBoltStorer) unindexBlockActions(tx *bolt.Tx, blk *types.SignedBlock, blockID types.Checksum256) error {
+	bucket := tx.Bucket([]byte(accountHistoryBucket))
+	if bucket == nil {
+		return nil
+	}
+
+	for _, act := range blockAccountActions(s.logger, blk, blockID) {
+		key := accountActionKey(act.Account, act.Pos)
+		data := bucket.Get(key)
+		if len(data) == 0 {
+			continue
+		}
+
+		var old AccountAction
+		if err := types.NewDecoder(data).Decode(&old); err != nil {
+			return errors.Wrapf(err, "decode action of %s", act.Account)
+		}
+
+		if !types.IsChecksumEq(old.BlockID, blockID) {
+			continue
+		}
+
+		if err := bucket.Delete(key); err != nil {
+			return errors.Wrapf(err, "delete action of %s", act.Account)
+		}
+	}
+
+	return nil
+}
+
+// EnableAccountHistory index the actions of accounts in the blocks committed after, need store all blocks
+func (s *BBoltStorer) EnableAccountHistory() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.isStoreAllBlocks {
+		return errors.Wrap(ErrIndexDisabled, "account history need store all blocks")
+	}
+
+	s.isIndexAccounts = true
+	return nil
+}
+
+// GetAccountActions get a page of the actions of account, return ErrIndexDisabled if account history not enabled
+func (s *BBoltStorer) GetAccountActions(query AccountHistoryQuery) ([]*AccountAction, error) {
+	s.mutex.RLock()
+	isIndexAccounts := s.isIndexAccounts
+	s.mutex.RUnlock()
+
+	if !isIndexAccounts {
+		return nil, errors.Wrapf(ErrIndexDisabled, "get actions of %s: account history not enabled", query.Account)
+	}
+
+	if query.Limit <= 0 {
+		return nil, errors.New("limit should be positive")
+	}
+
+	res := make([]*AccountAction, 0, query.Limit)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(accountHistoryBucket))
+		if bucket == nil {
+			return nil
+		}
+
+		prefix := accountKeyPrefix(query.Account)
+		cursorKey := accountActionKey(query.Account, query.Cursor)
+		isFromEdge := query.Cursor == AccountActionPos{}
+
+		c := bucket.Cursor()
+		var k, v []byte
+		switch {
+		case !query.IsDesc:
+			k, v = c.Seek(cursorKey)
+			if k != nil && !isFromEdge && bytes.Equal(k, cursorKey) {
+				k, v = c.Next()
+			}
+		case isFromEdge:
+			// seek to the first key after all keys of account
+			end := append(append([]byte(nil), prefix...), bytes.Repeat([]byte{0xff}, 12)...)
+			k, v = c.Seek(end)
+			if k == nil {
+				k, v = c.Last()
+			} else if !bytes.Equal(k, end) {
+				k, v = c.Prev()
+			}
+		default:
+			k, v = c.Seek(cursorKey)
+			if k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+
+		for k != nil && bytes.HasPrefix(k, prefix) && len(res) < query.Limit {
+			act := &AccountAction{}
+			if err := types.NewDecoder(v).Decode(act); err != nil {
+				return errors.Wrap(err, "decode account action")
+			}
+			res = append(res, act)
+
+			if query.IsDesc {
+				k, v = c.Prev()
+			} else {
+				k, v = c.Next()
+			}
+		}
+
+		return nil
+	})
+
+	return res, errors.Wrapf(err, "get actions of %s", query.Account)
+}
+
+// UndoBlockActions remove the actions in the block undone by a fork switch
+func (s *BBoltStorer) UndoBlockActions(blk *types.SignedBlock) error {
+	blockID, err := blk.BlockID()
+	if err != nil {
+		return errors.Wrap(err, "block id")
+	}
+
+	return errors.Wrapf(s.db.Update(func(tx *bolt.Tx) error {
+		return s.unindexBlockActions(tx, blk, blockID)
+	}), "undo actions in block %d", blk.BlockNumber())
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	eos "github.com/eoscanada/eos-go"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// allAccountActions get all actions of account by pages
+func allAccountActions(t *testing.T, s *BBoltStorer, account string, isDesc bool) []*AccountAction {
+	res := make([]*AccountAction, 0, 64)
+	query := AccountHistoryQuery{Account: account, Limit: 7, IsDesc: isDesc}
+	for {
+		page, err := s.GetAccountActions(query)
+		if err != nil {
+			t.Fatalf("get actions error %s", err.Error())
+		}
+
+		if len(page) == 0 {
+			return res
+		}
+
+		res = append(res, page...)
+		query.Cursor = page[len(page)-1].Pos
+	}
+}
+
+func posLess(a, b AccountActionPos) bool {
+	if a.BlockNum != b.BlockNum {
+		return a.BlockNum < b.BlockNum
+	}
+	if a.TrxIndex != b.TrxIndex {
+		return a.TrxIndex < b.TrxIndex
+	}
+	return a.ActionIndex < b.ActionIndex
+}
+
+func TestAccountHistory(t *testing.T) {
+	newAct := func(blockNum uint32, actors ...string) *types.Action {
+		act := &types.Action{
+			Account:    types.AccountName("eosio.token"),
+			Name:       types.ActionName("transfer"),
+			ActionData: eos.NewActionDataFromHexData([]byte{byte(blockNum)}),
+		}
+		for _, actor := range actors {
+			act.Authorization = append(act.Authorization,
+				types.PermissionLevel{Actor: types.AccountName(actor), Permission: eos.PermissionName("active")})
+		}
+		return act
+	}
+
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForTest),
+		types.WithGenTransactions(func(blockNum uint32) [][]*types.Action {
+			return [][]*types.Action{
+				{newAct(blockNum, "alice")},
+				{newAct(blockNum, "bob"), newAct(blockNum, "alice", "bob")},
+			}
+		}))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	if _, err := gen.Generate(30); err != nil {
+		t.Fatalf("generate blocks error %s", err.Error())
+	}
+
+	s, err := NewBBoltStorer(zap.NewNop(), chainIDForTest, filepath.Join(t.TempDir(), "blocks.db"), true)
+	if err != nil {
+		t.Fatalf("error by new %s", err.Error())
+	}
+	defer s.Close()
+
+	if _, err := s.GetAccountActions(AccountHistoryQuery{Account: "alice", Limit: 1}); errors.Cause(err) != ErrIndexDisabled {
+		t.Fatalf("get actions before enabled should be index disabled, got %v", err)
+	}
+
+	if err := s.EnableAccountHistory(); err != nil {
+		t.Fatalf("enable account history error %s", err.Error())
+	}
+
+	for _, blk := range gen.Blocks() {
+		if err := s.CommitBlock(blk); err != nil {
+			t.Fatalf("commit block error %s", err.Error())
+		}
+	}
+
+	for account, expect := range map[string]int{"alice": 60, "bob": 60, "eosio.token": 90, "carol": 0} {
+		if got := len(allAccountActions(t, s, account, false)); got != expect {
+			t.Errorf("actions of %s should be %d, got %d", account, expect, got)
+		}
+	}
+
+	asc := allAccountActions(t, s, "alice", false)
+	desc := allAccountActions(t, s, "alice", true)
+	if len(asc) != len(desc) {
+		t.Fatalf("actions by desc diff %d %d", len(asc), len(desc))
+	}
+
+	for idx, act := range asc {
+		if idx > 0 && !posLess(asc[idx-1].Pos, act.Pos) {
+			t.Fatalf("actions not ordered at %d", idx)
+		}
+
+		if desc[len(desc)-1-idx].Pos != act.Pos {
+			t.Fatalf("actions by desc diff at %d", idx)
+		}
+
+		blk := gen.Blocks()[act.Pos.BlockNum-1]
+		blockID, _ := blk.BlockID()
+		if !types.IsChecksumEq(act.BlockID, blockID) ||
+			!types.IsChecksumEq(act.TrxID, blk.Transactions[act.Pos.TrxIndex].Transaction.ID) {
+			t.Errorf("action %d of block %d diff", act.Pos.ActionIndex, act.Pos.BlockNum)
+		}
+	}
+
+	// the first action of alice in trx 1 is the second one
+	if asc[1].Pos != (AccountActionPos{BlockNum: 1, TrxIndex: 1, ActionIndex: 1}) {
+		t.Errorf("second action of alice diff %v", asc[1].Pos)
+	}
+
+	// switch to a fork from 21, the actions in the blocks replaced are reindexed to fork blocks
+	fork, err := gen.Fork(21)
+	if err != nil {
+		t.Fatalf("fork error %s", err.Error())
+	}
+
+	if _, err := fork.GenerateTo(35); err != nil {
+		t.Fatalf("generate fork error %s", err.Error())
+	}
+
+	for _, blk := range fork.Blocks()[20:] {
+		if err := s.CommitBlock(blk); err != nil {
+			t.Fatalf("commit fork block error %s", err.Error())
+		}
+	}
+
+	asc = allAccountActions(t, s, "alice", false)
+	if len(asc) != 70 {
+		t.Fatalf("actions of alice after fork should be 70, got %d", len(asc))
+	}
+
+	for _, act := range asc {
+		blockID, _ := fork.Blocks()[act.Pos.BlockNum-1].BlockID()
+		if !types.IsChecksumEq(act.BlockID, blockID) {
+			t.Fatalf("action in block %d should be in fork", act.Pos.BlockNum)
+		}
+	}
+
+	// undo the head of fork
+	if err := s.UndoBlockActions(fork.HeadBlock()); err != nil {
+		t.Fatalf("undo block error %s", err.Error())
+	}
+
+	desc, err = s.GetAccountActions(AccountHistoryQuery{Account: "alice", Limit: 1, IsDesc: true})
+	if err != nil {
+		t.Fatalf("get actions error %s", err.Error())
+	}
+
+	if len(desc) != 1 || desc[0].Pos.BlockNum != 34 {
+		t.Errorf("last action of alice after undo diff %v", desc)
+	}
+}
+
+// TestAccountHistoryDisabled test account history cannot be enabled if the storer not store all blocks
+func TestAccountHistoryDisabled(t *testing.T) {
+	s, err := NewBBoltStorer(zap.NewNop(), chainIDForTest, filepath.Join(t.TempDir(), "blocks.db"), false)
+	if err != nil {
+		t.Fatalf("error by new %s", err.Error())
+	}
+	defer s.Close()
+
+	if err := s.EnableAccountHistory(); errors.Cause(err) != ErrIndexDisabled {
+		t.Errorf("enable account history should be index disabled, got %v", err)
+	}
+
+	if _, err := s.GetAccountActions(AccountHistoryQuery{Account: "alice", Limit: 1}); errors.Cause(err) != ErrIndexDisabled {
+		t.Errorf("get actions should be index disabled, got %v", err)
+	}
+}
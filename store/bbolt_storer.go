@@ -1,6 +1,7 @@
 package store
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"sync"
@@ -12,6 +13,9 @@ import (
 	"github.com/fanyang1988/eos-p2p/types"
 )
 
+// blocksByNumBucket bucket for blocks by num, only used if store all blocks
+const blocksByNumBucket = "blocks"
+
 // BBoltStorer a very simple storer imp for test imp by storer
 type BBoltStorer struct {
 	chainID types.Checksum256
@@ -20,6 +24,7 @@ type BBoltStorer struct {
 	mutex   sync.RWMutex
 
 	isStoreAllBlocks bool
+	isIndexAccounts  bool
 
 	// current store state
 	state *BlockDBState
@@ -84,6 +89,8 @@ func (s *BBoltStorer) initState(chainID types.Checksum256) error {
 			}
 		}
 
+		s.loadLastIrreversible(stateBucket)
+
 		return nil
 	}), "init state")
 }
@@ -101,11 +108,14 @@ func (s *BBoltStorer) HeadBlockNum() uint32 {
 	return s.state.HeadBlockNum
 }
 
-// State get state data
+// State get state data, the last blocks is copied as it is changed in place by commits
 func (s *BBoltStorer) State() BlockDBState {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	return *s.state
+
+	res := *s.state
+	res.LastBlocks = append([]*types.SignedBlock(nil), s.state.LastBlocks...)
+	return res
 }
 
 // HeadBlock get head block
@@ -177,10 +187,91 @@ func (s *BBoltStorer) setBlock(blk *types.SignedBlock) error {
 			return errors.Wrap(err, "put block")
 		}
 
+		numBucket, err := tx.CreateBucketIfNotExists([]byte(blocksByNumBucket))
+		if err != nil {
+			return errors.Wrap(err, "create num bucket")
+		}
+
+		// the block in the num is replaced by a block in another fork
+		if oldData := numBucket.Get(blockNumKey(blk.BlockNumber())); len(oldData) > 0 {
+			old, err := decodeBlock(oldData)
+			if err != nil {
+				return err
+			}
+
+			oldID, _ := old.BlockID()
+			if !types.IsChecksumEq(oldID, bID) {
+				if err := unindexBlockTrxs(tx, old, oldID); err != nil {
+					return errors.Wrap(err, "undo trxs in block replaced")
+				}
+
+				if s.isIndexAccounts {
+					if err := s.unindexBlockActions(tx, old, oldID); err != nil {
+						return errors.Wrap(err, "undo actions in block replaced")
+					}
+				}
+			}
+		}
+
+		blkBytes, err := types.EncodeBlock(blk)
+		if err != nil {
+			return errors.Wrap(err, "encode block")
+		}
+
+		if err := numBucket.Put(blockNumKey(blk.BlockNumber()), blkBytes); err != nil {
+			return errors.Wrap(err, "put block by num")
+		}
+
+		if err := indexBlockTrxs(tx, blk, bID); err != nil {
+			return err
+		}
+
+		if s.isIndexAccounts {
+			return s.indexBlockActions(tx, blk, bID)
+		}
+
 		return nil
 	}), "set block %d", blk.BlockNumber())
 }
 
+// getBlock get block by num from db, the block is the last one committed in the num
+func (s *BBoltStorer) getBlock(blockNum uint32) (*types.SignedBlock, error) {
+	var res *types.SignedBlock
+	err := s.db.View(func(tx *bolt.Tx) error {
+		numBucket := tx.Bucket([]byte(blocksByNumBucket))
+		if numBucket == nil {
+			return nil
+		}
+
+		data := numBucket.Get(blockNumKey(blockNum))
+		if len(data) == 0 {
+			return nil
+		}
+
+		blk, err := decodeBlock(data)
+		if err != nil {
+			return err
+		}
+
+		res = blk
+		return nil
+	})
+
+	return res, errors.Wrapf(err, "get block %d", blockNum)
+}
+
+// decodeBlock decode the block stored in db
+func decodeBlock(data []byte) (*types.SignedBlock, error) {
+	blk := &types.SignedBlock{}
+	decoder := types.NewDecoder(data)
+	decoder.DecodeActions(false)
+	if err := decoder.Decode(blk); err != nil {
+		return nil, errors.Wrap(err, "decode block")
+	}
+
+	return blk, nil
+}
+
 // CommitBlock commit block from p2p
 func (s *BBoltStorer) CommitBlock(blk *types.SignedBlock) error {
 	s.mutex.Lock()
@@ -197,14 +288,23 @@ func (s *BBoltStorer) CommitBlock(blk *types.SignedBlock) error {
 	return nil
 }
 
-// GetBlockByNum get block by num, if not store all blocks, try to find in state cache
+// GetBlockByNum get block by num, try to find in state cache, then in db if store all blocks
 func (s *BBoltStorer) GetBlockByNum(blockNum uint32) (*types.SignedBlock, bool) {
-	// TODO: find in blocks
-	if !s.isStoreAllBlocks {
-		return s.state.getBlockByNum(blockNum)
+	s.mutex.RLock()
+	blk, ok := s.state.getBlockByNum(blockNum)
+	s.mutex.RUnlock()
+
+	if ok || !s.isStoreAllBlocks {
+		return blk, ok
 	}
 
-	return nil, false
+	blk, err := s.getBlock(blockNum)
+	if err != nil {
+		s.logger.Error("get block from db error", zap.Error(err))
+		return nil, false
+	}
+
+	return blk, blk != nil
 }
 
 // CommitTrx commit trx
@@ -254,3 +354,10 @@ func (s *BBoltStorer) Close() {
 func (s *BBoltStorer) Wait() {
 	return // no need wait
 }
+
+// blockNumKey key by block num, big endian to keep the order in bucket
+func blockNumKey(blockNum uint32) []byte {
+	res := make([]byte, 4)
+	binary.BigEndian.PutUint32(res, blockNum)
+	return res
+}
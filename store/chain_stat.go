@@ -17,6 +17,10 @@ type BlockDBState struct {
 	HeadBlockTime time.Time            `json:"headTime"`
 	HeadBlock     *types.SignedBlock   `json:"headBlk"`
 	LastBlocks    []*types.SignedBlock `json:"blks"`
+
+	// LastIrreversibleBlockNum lib known by storer, it is the checkpoint if started from one
+	LastIrreversibleBlockNum uint32            `json:"libNum" eos:"binary_extension"`
+	LastIrreversibleBlockID  types.Checksum256 `json:"libID" eos:"binary_extension"`
 }
 
 // ToHandshakeInfo make a handshake info for handshake message
@@ -37,6 +41,11 @@ func (b *BlockDBState) ToHandshakeInfo() *types.HandshakeInfo {
 		res.HeadBlockNum = head.BlockNumber()
 		res.HeadBlockID, _ = head.BlockID()
 		res.HeadBlockTime = head.Timestamp.Time
+	} else if b.HeadBlockNum > 1 && len(b.HeadBlockID) > 0 {
+		// started from a checkpoint without header
+		res.HeadBlockNum = b.HeadBlockNum
+		res.HeadBlockID = b.HeadBlockID
+		res.HeadBlockTime = b.HeadBlockTime
 	}
 
 	irr, ok := b.getBlockByNum(irrNum)
@@ -45,6 +54,12 @@ func (b *BlockDBState) ToHandshakeInfo() *types.HandshakeInfo {
 		res.LastIrreversibleBlockID, _ = irr.BlockID()
 	}
 
+	if b.LastIrreversibleBlockNum > res.LastIrreversibleBlockNum &&
+		b.LastIrreversibleBlockNum <= res.HeadBlockNum {
+		res.LastIrreversibleBlockNum = b.LastIrreversibleBlockNum
+		res.LastIrreversibleBlockID = b.LastIrreversibleBlockID
+	}
+
 	return res
 }
 
@@ -74,7 +89,16 @@ func (b *BlockDBState) Bytes() ([]byte, error) {
 func (b *BlockDBState) FromBytes(data []byte) error {
 	decoder := types.NewDecoder(data)
 	decoder.DecodeActions(false)
-	return decoder.Decode(b)
+	if err := decoder.Decode(b); err != nil {
+		return err
+	}
+
+	// the state flushed before any block committed has no head id, it is decoded to zero
+	if bytes.Equal(b.HeadBlockID, make([]byte, len(b.HeadBlockID))) {
+		b.HeadBlockID = nil
+	}
+
+	return nil
 }
 
 // getBlockByNum get block by num, if not store all blocks, try to find in state cache
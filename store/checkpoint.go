@@ -0,0 +1,90 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// Checkpoint a trusted block to start sync from, no need sync the blocks before it
+type Checkpoint struct {
+	BlockNum uint32            `json:"num"`
+	BlockID  types.Checksum256 `json:"id"`
+	// Header optional header of the block, if set the block id will be checked by it
+	Header *types.BlockHeader `json:"header,omitempty"`
+}
+
+// Validate check the checkpoint is valid
+func (c *Checkpoint) Validate() error {
+	if c.BlockNum == 0 {
+		return errors.New("checkpoint block num cannot be 0")
+	}
+
+	if c.Header == nil {
+		return nil
+	}
+
+	if c.Header.BlockNumber() != c.BlockNum {
+		return errors.Errorf("checkpoint header num %d diff from %d", c.Header.BlockNumber(), c.BlockNum)
+	}
+
+	id, err := c.Header.BlockID()
+	if err != nil {
+		return errors.Wrap(err, "checkpoint header id")
+	}
+
+	if len(c.BlockID) != 0 && !types.IsChecksumEq(id, c.BlockID) {
+		return errors.Errorf("checkpoint header id %s diff from %s", id.String(), c.BlockID.String())
+	}
+
+	c.BlockID = id
+	return nil
+}
+
+// CheckpointSeeder storer which can start from a checkpoint
+type CheckpointSeeder interface {
+	SeedCheckpoint(cp Checkpoint) error
+}
+
+// SeedCheckpoint set the checkpoint as the head and the lib of storer,
+// if the storer had synced to or beyond the checkpoint, do nothing
+func (s *BBoltStorer) SeedCheckpoint(cp Checkpoint) error {
+	if err := cp.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	if s.state.HeadBlockNum >= cp.BlockNum {
+		s.mutex.Unlock()
+		s.logger.Info("storer is beyond checkpoint, no need seed",
+			zap.Uint32("head", s.HeadBlockNum()), zap.Uint32("checkpoint", cp.BlockNum))
+		return nil
+	}
+
+	s.state.seedCheckpoint(&cp)
+	s.mutex.Unlock()
+
+	s.logger.Info("seed checkpoint",
+		zap.Uint32("num", cp.BlockNum), zap.String("id", cp.BlockID.String()))
+
+	return errors.Wrap(s.Flush(), "flush checkpoint")
+}
+
+// seedCheckpoint reset state to the checkpoint
+func (b *BlockDBState) seedCheckpoint(cp *Checkpoint) {
+	b.HeadBlockNum = cp.BlockNum
+	b.HeadBlockID = types.CopyChecksum256(cp.BlockID)
+	b.HeadBlock = types.NewEmptyBlock()
+	b.HeadBlockTime = b.HeadBlock.Timestamp.Time
+	b.LastBlocks = b.LastBlocks[:0]
+
+	if cp.Header != nil {
+		b.HeadBlock.BlockHeader = *cp.Header
+		b.HeadBlockTime = cp.Header.Timestamp.Time
+		b.LastBlocks = append(b.LastBlocks, b.HeadBlock)
+	}
+
+	b.LastIrreversibleBlockNum = cp.BlockNum
+	b.LastIrreversibleBlockID = types.CopyChecksum256(cp.BlockID)
+}
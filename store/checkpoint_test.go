@@ -0,0 +1,74 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+const chainIDForTest = "76eab2b704733e933d0e4eb6cc24d260d9fbbe5d93d760392e97398f4e301448"
+
+func TestSeedCheckpoint(t *testing.T) {
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForTest))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	if _, err := gen.Generate(20); err != nil {
+		t.Fatalf("generate blocks error %s", err.Error())
+	}
+
+	blk := gen.Blocks()[9]
+	id, _ := blk.BlockID()
+
+	cp := Checkpoint{
+		BlockNum: blk.BlockNumber(),
+		BlockID:  id,
+		Header:   &blk.BlockHeader,
+	}
+
+	bad := cp
+	bad.BlockNum++
+	if err := bad.Validate(); err == nil {
+		t.Errorf("checkpoint with diff num should be invalid")
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "blocks.db")
+	s, err := NewBBoltStorer(zap.NewNop(), chainIDForTest, dbPath, false)
+	if err != nil {
+		t.Fatalf("error by new %s", err.Error())
+	}
+
+	if err := s.SeedCheckpoint(cp); err != nil {
+		t.Fatalf("seed checkpoint error %s", err.Error())
+	}
+	s.Close()
+
+	s, err = NewBBoltStorer(zap.NewNop(), chainIDForTest, dbPath, false)
+	if err != nil {
+		t.Fatalf("error by reopen %s", err.Error())
+	}
+	defer s.Close()
+
+	stat := s.State()
+	hs := stat.ToHandshakeInfo()
+	if hs.HeadBlockNum != cp.BlockNum || !types.IsChecksumEq(hs.HeadBlockID, id) {
+		t.Errorf("head diff from checkpoint %d %s", hs.HeadBlockNum, hs.HeadBlockID)
+	}
+
+	if hs.LastIrreversibleBlockNum != cp.BlockNum || !types.IsChecksumEq(hs.LastIrreversibleBlockID, id) {
+		t.Errorf("lib diff from checkpoint %d %s", hs.LastIrreversibleBlockNum, hs.LastIrreversibleBlockID)
+	}
+
+	if err := s.CommitBlock(gen.Blocks()[10]); err != nil {
+		t.Fatalf("commit block error %s", err.Error())
+	}
+
+	// storer is beyond it, no change
+	if err := s.SeedCheckpoint(Checkpoint{BlockNum: 5}); err != nil || s.HeadBlockNum() != cp.BlockNum+1 {
+		t.Errorf("seed old checkpoint should do nothing %v %d", err, s.HeadBlockNum())
+	}
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// cursorsBucket bucket for the cursors of handlers
+const cursorsBucket = "cursors"
+
+// Cursor the last block acknowledged by a handler
+type Cursor struct {
+	BlockNum uint32            `json:"num"`
+	BlockID  types.Checksum256 `json:"id"`
+}
+
+// CursorStorer storer which can persist the cursors of handlers by name
+type CursorStorer interface {
+	GetCursor(name string) (Cursor, bool, error)
+	SetCursor(name string, cursor Cursor) error
+}
+
+// GetCursor get the cursor of the handler, return false if the handler had not acknowledged any block
+func (s *BBoltStorer) GetCursor(name string) (Cursor, bool, error) {
+	var (
+		res   Cursor
+		found bool
+	)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(cursorsBucket))
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get([]byte(name))
+		if len(data) == 0 {
+			return nil
+		}
+
+		if err := types.NewDecoder(data).Decode(&res); err != nil {
+			return errors.Wrap(err, "decode cursor")
+		}
+
+		found = true
+		return nil
+	})
+
+	return res, found, errors.Wrapf(err, "get cursor %s", name)
+}
+
+// SetCursor persist the cursor of the handler to db at once
+func (s *BBoltStorer) SetCursor(name string, cursor Cursor) error {
+	data, err := types.EncodeToEOS(&cursor)
+	if err != nil {
+		return errors.Wrap(err, "encode cursor")
+	}
+
+	return errors.Wrapf(s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(cursorsBucket))
+		if err != nil {
+			return errors.Wrap(err, "create bucket")
+		}
+
+		return bucket.Put([]byte(name), data)
+	}), "set cursor %s", name)
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+func TestCursorAndBlocksByNum(t *testing.T) {
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForTest))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	if _, err := gen.Generate(100); err != nil {
+		t.Fatalf("generate blocks error %s", err.Error())
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "blocks.db")
+	s, err := NewBBoltStorer(zap.NewNop(), chainIDForTest, dbPath, true)
+	if err != nil {
+		t.Fatalf("error by new %s", err.Error())
+	}
+
+	for _, blk := range gen.Blocks() {
+		if err := s.CommitBlock(blk); err != nil {
+			t.Fatalf("commit block error %s", err.Error())
+		}
+	}
+
+	if _, ok, err := s.GetCursor("indexer"); ok || err != nil {
+		t.Fatalf("cursor should not found %v %v", ok, err)
+	}
+
+	cursorID, _ := gen.Blocks()[9].BlockID()
+	if err := s.SetCursor("indexer", Cursor{BlockNum: 10, BlockID: cursorID}); err != nil {
+		t.Fatalf("set cursor error %s", err.Error())
+	}
+	s.Close()
+
+	s, err = NewBBoltStorer(zap.NewNop(), chainIDForTest, dbPath, true)
+	if err != nil {
+		t.Fatalf("error by reopen %s", err.Error())
+	}
+	defer s.Close()
+
+	cursor, ok, err := s.GetCursor("indexer")
+	if err != nil || !ok {
+		t.Fatalf("cursor should found %v %v", ok, err)
+	}
+
+	if cursor.BlockNum != 10 || !types.IsChecksumEq(cursor.BlockID, cursorID) {
+		t.Errorf("cursor diff after reopen %d %s", cursor.BlockNum, cursor.BlockID)
+	}
+
+	// the block out of the state cache is from db
+	for _, num := range []uint32{10, 100} {
+		blk, ok := s.GetBlockByNum(num)
+		if !ok {
+			t.Fatalf("block %d should found", num)
+		}
+
+		id, _ := blk.BlockID()
+		expectID, _ := gen.Blocks()[num-1].BlockID()
+		if !types.IsChecksumEq(id, expectID) {
+			t.Errorf("block %d id diff", num)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// libKey key in state bucket for the lib, it is persisted once set, not waiting for Flush
+const libKey = "lib"
+
+// IrreversibleSetter storer which can record the last irreversible block
+type IrreversibleSetter interface {
+	SetLastIrreversible(blockNum uint32, blockID types.Checksum256) error
+}
+
+// SetLastIrreversible set the lib in state and persist it at once, lib never go back
+func (s *BBoltStorer) SetLastIrreversible(blockNum uint32, blockID types.Checksum256) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if blockNum <= s.state.LastIrreversibleBlockNum {
+		return nil
+	}
+
+	data := make([]byte, 4, 4+len(blockID))
+	binary.BigEndian.PutUint32(data, blockNum)
+	data = append(data, blockID...)
+
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("state"))
+		if err != nil {
+			return errors.Wrap(err, "create bucket")
+		}
+
+		return bucket.Put([]byte(libKey), data)
+	}); err != nil {
+		return errors.Wrapf(err, "put lib %d", blockNum)
+	}
+
+	s.state.LastIrreversibleBlockNum = blockNum
+	s.state.LastIrreversibleBlockID = types.CopyChecksum256(blockID)
+
+	return nil
+}
+
+// loadLastIrreversible load the lib persisted, it may be newer than the lib in state not flushed
+func (s *BBoltStorer) loadLastIrreversible(bucket *bolt.Bucket) {
+	data := bucket.Get([]byte(libKey))
+	if len(data) < 4 {
+		return
+	}
+
+	blockNum := binary.BigEndian.Uint32(data)
+	if blockNum <= s.state.LastIrreversibleBlockNum {
+		return
+	}
+
+	s.state.LastIrreversibleBlockNum = blockNum
+	s.state.LastIrreversibleBlockID = types.CopyChecksum256(data[4:])
+}
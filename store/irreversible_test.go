@@ -0,0 +1,103 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+func TestSetLastIrreversible(t *testing.T) {
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForTest))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	if _, err := gen.Generate(20); err != nil {
+		t.Fatalf("generate blocks error %s", err.Error())
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "blocks.db")
+	s, err := NewBBoltStorer(zap.NewNop(), chainIDForTest, dbPath, false)
+	if err != nil {
+		t.Fatalf("error by new %s", err.Error())
+	}
+
+	for _, blk := range gen.Blocks() {
+		if err := s.CommitBlock(blk); err != nil {
+			t.Fatalf("commit block error %s", err.Error())
+		}
+	}
+
+	libID, _ := gen.Blocks()[14].BlockID()
+	if err := s.SetLastIrreversible(15, libID); err != nil {
+		t.Fatalf("set lib error %s", err.Error())
+	}
+
+	// lib never go back
+	oldID, _ := gen.Blocks()[9].BlockID()
+	if err := s.SetLastIrreversible(10, oldID); err != nil {
+		t.Fatalf("set lib error %s", err.Error())
+	}
+	s.Close()
+
+	s, err = NewBBoltStorer(zap.NewNop(), chainIDForTest, dbPath, false)
+	if err != nil {
+		t.Fatalf("error by reopen %s", err.Error())
+	}
+	defer s.Close()
+
+	stat := s.State()
+	if stat.LastIrreversibleBlockNum != 15 || !types.IsChecksumEq(stat.LastIrreversibleBlockID, libID) {
+		t.Errorf("lib diff after reopen %d %s", stat.LastIrreversibleBlockNum, stat.LastIrreversibleBlockID)
+	}
+}
+
+// TestLastIrreversibleWithoutFlush test the lib is persisted when set, even if the state not flushed
+func TestLastIrreversibleWithoutFlush(t *testing.T) {
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForTest))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	if _, err := gen.Generate(20); err != nil {
+		t.Fatalf("generate blocks error %s", err.Error())
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "blocks.db")
+	s, err := NewBBoltStorer(zap.NewNop(), chainIDForTest, dbPath, false)
+	if err != nil {
+		t.Fatalf("error by new %s", err.Error())
+	}
+
+	for _, blk := range gen.Blocks() {
+		if err := s.CommitBlock(blk); err != nil {
+			t.Fatalf("commit block error %s", err.Error())
+		}
+	}
+
+	libID, _ := gen.Blocks()[14].BlockID()
+	if err := s.SetLastIrreversible(15, libID); err != nil {
+		t.Fatalf("set lib error %s", err.Error())
+	}
+
+	// close db without flush, as the process crashed
+	s.db.Close()
+
+	s, err = NewBBoltStorer(zap.NewNop(), chainIDForTest, dbPath, false)
+	if err != nil {
+		t.Fatalf("error by reopen %s", err.Error())
+	}
+	defer s.Close()
+
+	stat := s.State()
+	if stat.LastIrreversibleBlockNum != 15 || !types.IsChecksumEq(stat.LastIrreversibleBlockID, libID) {
+		t.Errorf("lib diff after reopen %d %s", stat.LastIrreversibleBlockNum, stat.LastIrreversibleBlockID)
+	}
+
+	if stat.HeadBlockNum >= 15 {
+		t.Errorf("head should not be flushed, got %d", stat.HeadBlockNum)
+	}
+}
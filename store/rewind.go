@@ -0,0 +1,65 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// HeadRewinder storer which can rewind the head to switch to another fork
+type HeadRewinder interface {
+	RewindHead(blockNum uint32) error
+}
+
+// RewindHead set the head back to the block num, the block must be in the last blocks of state
+// and not before the lib, the blocks of new fork after it can be committed then
+func (s *BBoltStorer) RewindHead(blockNum uint32) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.state.rewindHead(blockNum)
+}
+
+// rewindHead set the head back to the block num in last blocks
+func (b *BlockDBState) rewindHead(blockNum uint32) error {
+	if blockNum >= b.HeadBlockNum {
+		return nil
+	}
+
+	if blockNum < b.LastIrreversibleBlockNum {
+		return errors.Errorf("cannot rewind head to %d before lib %d", blockNum, b.LastIrreversibleBlockNum)
+	}
+
+	blk, ok := b.getBlockByNum(blockNum)
+	if !ok {
+		return errors.Errorf("block %d to rewind not in last blocks", blockNum)
+	}
+
+	id, err := blk.BlockID()
+	if err != nil {
+		return errors.Wrapf(err, "block id %d", blockNum)
+	}
+
+	b.HeadBlockNum = blockNum
+	b.HeadBlockID = id
+	b.HeadBlockTime = blk.Timestamp.Time
+	b.HeadBlock = blk
+	b.LastBlocks = b.LastBlocks[:blockNum-b.LastBlocks[0].BlockNumber()+1]
+
+	return nil
+}
+
+// BlockIDByNum the id of block in the num of current chain, only the last blocks are kept
+func (b *BlockDBState) BlockIDByNum(blockNum uint32) (types.Checksum256, bool) {
+	if blockNum == b.HeadBlockNum && len(b.HeadBlockID) > 0 {
+		return b.HeadBlockID, true
+	}
+
+	blk, ok := b.getBlockByNum(blockNum)
+	if !ok {
+		return nil, false
+	}
+
+	id, err := blk.BlockID()
+	return id, err == nil
+}
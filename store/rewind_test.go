@@ -0,0 +1,85 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// TestRewindHead test the head can rewind to the fork point then commit the blocks of fork
+func TestRewindHead(t *testing.T) {
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForTest))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	if _, err := gen.Generate(30); err != nil {
+		t.Fatalf("generate blocks error %s", err.Error())
+	}
+
+	s, err := NewBBoltStorer(zap.NewNop(), chainIDForTest, filepath.Join(t.TempDir(), "blocks.db"), true)
+	if err != nil {
+		t.Fatalf("error by new %s", err.Error())
+	}
+	defer s.Close()
+
+	for _, blk := range gen.Blocks() {
+		if err := s.CommitBlock(blk); err != nil {
+			t.Fatalf("commit block error %s", err.Error())
+		}
+	}
+
+	libID, _ := gen.Blocks()[9].BlockID()
+	if err := s.SetLastIrreversible(10, libID); err != nil {
+		t.Fatalf("set lib error %s", err.Error())
+	}
+
+	if err := s.RewindHead(5); err == nil {
+		t.Errorf("rewind before lib should fail")
+	}
+
+	fork, err := gen.Fork(21)
+	if err != nil {
+		t.Fatalf("fork error %s", err.Error())
+	}
+
+	if _, err := fork.GenerateTo(35); err != nil {
+		t.Fatalf("generate fork error %s", err.Error())
+	}
+
+	if err := s.RewindHead(20); err != nil {
+		t.Fatalf("rewind head error %s", err.Error())
+	}
+
+	stat := s.State()
+	forkPointID, _ := gen.Blocks()[19].BlockID()
+	if stat.HeadBlockNum != 20 || !types.IsChecksumEq(stat.HeadBlockID, forkPointID) {
+		t.Fatalf("head diff after rewind %d %s", stat.HeadBlockNum, stat.HeadBlockID)
+	}
+
+	for _, blk := range fork.Blocks()[20:] {
+		if err := s.CommitBlock(blk); err != nil {
+			t.Fatalf("commit fork block error %s", err.Error())
+		}
+	}
+
+	stat = s.State()
+	for num := uint32(11); num <= 35; num++ {
+		id, ok := stat.BlockIDByNum(num)
+		expected, _ := fork.Blocks()[num-1].BlockID()
+		if !ok || !types.IsChecksumEq(id, expected) {
+			t.Errorf("block %d id diff after switch fork", num)
+		}
+	}
+
+	blk, ok := s.GetBlockByNum(25)
+	if !ok {
+		t.Fatalf("no block 25 after switch fork")
+	}
+	if blk.ProducerSignature.String() != fork.Blocks()[24].ProducerSignature.String() {
+		t.Errorf("block 25 not from fork")
+	}
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// trxIndexBucket bucket for the location of trxs by trx id, only used if store all blocks
+const trxIndexBucket = "trxs"
+
+// ErrIndexDisabled the index is not kept by the storer, such as the storer not store all blocks
+var ErrIndexDisabled = errors.New("index disabled")
+
+// TrxLocation the block contains the trx and the index of the trx receipt in block
+type TrxLocation struct {
+	BlockNum uint32            `json:"blockNum"`
+	Index    uint32            `json:"index"`
+	BlockID  types.Checksum256 `json:"blockID"`
+}
+
+// TransactionStorer storer which index the trxs in blocks committed
+type TransactionStorer interface {
+	GetTransaction(id types.Checksum256) (TrxLocation, bool, error)
+	UndoBlockTransactions(blk *types.SignedBlock) error
+}
+
+// indexBlockTrxs index the trxs in the block
+func indexBlockTrxs(tx *bolt.Tx, blk *types.SignedBlock, blockID types.Checksum256) error {
+	if len(blk.Transactions) == 0 {
+		return nil
+	}
+
+	bucket, err := tx.CreateBucketIfNotExists([]byte(trxIndexBucket))
+	if err != nil {
+		return errors.Wrap(err, "create trx bucket")
+	}
+
+	for idx, receipt := range blk.Transactions {
+		trxID := receipt.Transaction.ID
+		if len(trxID) == 0 {
+			continue
+		}
+
+		data, err := types.EncodeToEOS(&TrxLocation{
+			BlockNum: blk.BlockNumber(),
+			Index:    uint32(idx),
+			BlockID:  blockID,
+		})
+		if err != nil {
+			return errors.Wrap(err, "encode trx location")
+		}
+
+		if err := bucket.Put([]byte(trxID), data); err != nil {
+			return errors.Wrapf(err, "put trx %s", trxID)
+		}
+	}
+
+	return nil
+}
+
+// unindexBlockTrxs remove the index of the trxs in the block, if the trx is index to other block, keep it
+func unindexBlockTrxs(tx *bolt.Tx, blk *types.SignedBlock, blockID types.Checksum256) error {
+	bucket := tx.Bucket([]byte(trxIndexBucket))
+	if bucket == nil {
+		return nil
+	}
+
+	for _, receipt := range blk.Transactions {
+		key := []byte(receipt.Transaction.ID)
+		data := bucket.Get(key)
+		if len(data) == 0 {
+			continue
+		}
+
+		var loc TrxLocation
+		if err := types.NewDecoder(data).Decode(&loc); err != nil {
+			return errors.Wrapf(err, "decode trx location %s", receipt.Transaction.ID)
+		}
+
+		if !types.IsChecksumEq(loc.BlockID, blockID) {
+			continue
+		}
+
+		if err := bucket.Delete(key); err != nil {
+			return errors.Wrapf(err, "delete trx %s", receipt.Transaction.ID)
+		}
+	}
+
+	return nil
+}
+
+// GetTransaction get the location of the trx by id, the index is only kept if store all blocks,
+// else return ErrIndexDisabled
+func (s *BBoltStorer) GetTransaction(id types.Checksum256) (TrxLocation, bool, error) {
+	if !s.isStoreAllBlocks {
+		return TrxLocation{}, false, errors.Wrapf(ErrIndexDisabled, "get trx %s: storer not store all blocks", id)
+	}
+
+	var (
+		res   TrxLocation
+		found bool
+	)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(trxIndexBucket))
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get([]byte(id))
+		if len(data) == 0 {
+			return nil
+		}
+
+		if err := types.NewDecoder(data).Decode(&res); err != nil {
+			return errors.Wrap(err, "decode trx location")
+		}
+
+		found = true
+		return nil
+	})
+
+	return res, found, errors.Wrapf(err, "get trx %s", id)
+}
+
+// UndoBlockTransactions remove the index of the trxs in the block undone by a fork switch
+func (s *BBoltStorer) UndoBlockTransactions(blk *types.SignedBlock) error {
+	blockID, err := blk.BlockID()
+	if err != nil {
+		return errors.Wrap(err, "block id")
+	}
+
+	return errors.Wrapf(s.db.Update(func(tx *bolt.Tx) error {
+		return unindexBlockTrxs(tx, blk, blockID)
+	}), "undo trxs in block %d", blk.BlockNumber())
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	eos "github.com/eoscanada/eos-go"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fanyang1988/eos-p2p/types"
+)
+
+// checkTrxLocation check the trxs in block are indexed to the block, or not indexed to it if not isFound
+func checkTrxLocation(t *testing.T, s *BBoltStorer, blk *types.SignedBlock, isFound bool) {
+	blockID, _ := blk.BlockID()
+	for idx, receipt := range blk.Transactions {
+		loc, ok, err := s.GetTransaction(receipt.Transaction.ID)
+		if err != nil {
+			t.Fatalf("get trx error %s", err.Error())
+		}
+
+		if !isFound {
+			// the same trx may be in the block of other fork
+			if ok && types.IsChecksumEq(loc.BlockID, blockID) {
+				t.Fatalf("trx %d in block %d should not be found", idx, blk.BlockNumber())
+			}
+			continue
+		}
+
+		if !ok {
+			t.Fatalf("trx %d in block %d should be found", idx, blk.BlockNumber())
+		}
+
+		if ok && (loc.BlockNum != blk.BlockNumber() || loc.Index != uint32(idx) ||
+			!types.IsChecksumEq(loc.BlockID, blockID)) {
+			t.Errorf("trx %d in block %d location diff %d %d", idx, blk.BlockNumber(), loc.BlockNum, loc.Index)
+		}
+	}
+}
+
+func TestTransactionIndex(t *testing.T) {
+	gen, err := types.NewChainGenerator(types.MustNewChecksum256(chainIDForTest),
+		types.WithGenTransactions(func(blockNum uint32) [][]*types.Action {
+			act := &types.Action{
+				Account:       types.AccountName("eosio.token"),
+				Name:          types.ActionName("transfer"),
+				Authorization: []types.PermissionLevel{{Actor: types.AccountName("alice"), Permission: eos.PermissionName("active")}},
+				ActionData:    eos.NewActionDataFromHexData([]byte{byte(blockNum)}),
+			}
+			return [][]*types.Action{{act}, {act, act}}
+		}))
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+
+	if _, err := gen.Generate(30); err != nil {
+		t.Fatalf("generate blocks error %s", err.Error())
+	}
+
+	s, err := NewBBoltStorer(zap.NewNop(), chainIDForTest, filepath.Join(t.TempDir(), "blocks.db"), true)
+	if err != nil {
+		t.Fatalf("error by new %s", err.Error())
+	}
+	defer s.Close()
+
+	for _, blk := range gen.Blocks() {
+		if err := s.CommitBlock(blk); err != nil {
+			t.Fatalf("commit block error %s", err.Error())
+		}
+	}
+
+	for _, blk := range gen.Blocks() {
+		checkTrxLocation(t, s, blk, true)
+	}
+
+	// switch to a fork from 21, the trxs in the blocks replaced are removed
+	fork, err := gen.Fork(21)
+	if err != nil {
+		t.Fatalf("fork error %s", err.Error())
+	}
+
+	if _, err := fork.GenerateTo(35); err != nil {
+		t.Fatalf("generate fork error %s", err.Error())
+	}
+
+	for _, blk := range fork.Blocks()[20:] {
+		if err := s.CommitBlock(blk); err != nil {
+			t.Fatalf("commit fork block error %s", err.Error())
+		}
+	}
+
+	for num := 21; num <= 30; num++ {
+		checkTrxLocation(t, s, gen.Blocks()[num-1], false)
+	}
+
+	for _, blk := range fork.Blocks() {
+		checkTrxLocation(t, s, blk, true)
+	}
+
+	// undo the head of fork
+	if err := s.UndoBlockTransactions(fork.HeadBlock()); err != nil {
+		t.Fatalf("undo block error %s", err.Error())
+	}
+	checkTrxLocation(t, s, fork.HeadBlock(), false)
+}
+
+// TestTransactionIndexDisabled test get trx return error if the storer not store all blocks
+func TestTransactionIndexDisabled(t *testing.T) {
+	s, err := NewBBoltStorer(zap.NewNop(), chainIDForTest, filepath.Join(t.TempDir(), "blocks.db"), false)
+	if err != nil {
+		t.Fatalf("error by new %s", err.Error())
+	}
+	defer s.Close()
+
+	if _, _, err := s.GetTransaction(types.Checksum256(make([]byte, 32))); errors.Cause(err) != ErrIndexDisabled {
+		t.Errorf("get trx should be index disabled, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package types
+
+import (
+	"io"
+
+	eos "github.com/eoscanada/eos-go"
+	"github.com/pkg/errors"
+)
+
+// ABI eos type
+type ABI = eos.ABI
+
+var (
+	// SystemAccount the account of system contract
+	SystemAccount = AccountName("eosio")
+	// SetABIAction the action to set abi of account
+	SetABIAction = ActionName("setabi")
+)
+
+// SetABI the data of setabi action
+type SetABI struct {
+	Account AccountName  `json:"account"`
+	ABI     eos.HexBytes `json:"abi"`
+}
+
+// NewABIFromJSON read abi from json
+func NewABIFromJSON(r io.Reader) (*ABI, error) {
+	return eos.NewABI(r)
+}
+
+// DecodeABI decode the binary abi, as the data in setabi action
+func DecodeABI(data []byte) (*ABI, error) {
+	abi := &ABI{}
+	if err := NewDecoder(data).Decode(abi); err != nil {
+		return nil, errors.Wrap(err, "decode abi")
+	}
+
+	return abi, nil
+}
+
+// DecodeSetABI decode the data of setabi action, return false if the action is not setabi
+func DecodeSetABI(act *Action) (*SetABI, bool, error) {
+	if act.Account != SystemAccount || act.Name != SetABIAction {
+		return nil, false, nil
+	}
+
+	res := &SetABI{}
+	if err := NewDecoder(act.ActionData.HexData).Decode(res); err != nil {
+		return nil, true, errors.Wrap(err, "decode setabi")
+	}
+
+	return res, true, nil
+}
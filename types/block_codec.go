@@ -0,0 +1,60 @@
+package types
+
+import (
+	"bytes"
+
+	eos "github.com/eoscanada/eos-go"
+	"github.com/pkg/errors"
+)
+
+// trx variant types in TransactionWithID, see transaction_receipt in nodeos
+const (
+	trxVariantID     = uint8(0)
+	trxVariantPacked = uint8(1)
+)
+
+// EncodeBlock encode the block as the eos binary format, eos-go encodes TransactionWithID
+// without the variant type, so the blocks with trxs cannot be decoded by nodeos or eos-go
+func EncodeBlock(blk *SignedBlock) ([]byte, error) {
+	buff := bytes.NewBuffer(make([]byte, 0, 512))
+	encoder := eos.NewEncoder(buff)
+
+	if err := encoder.Encode(&blk.SignedBlockHeader); err != nil {
+		return nil, errors.Wrap(err, "encode block header")
+	}
+
+	if err := encoder.Encode(eos.Varuint32(len(blk.Transactions))); err != nil {
+		return nil, errors.Wrap(err, "encode trxs len")
+	}
+
+	for idx := range blk.Transactions {
+		if err := encodeTrxReceipt(encoder, &blk.Transactions[idx]); err != nil {
+			return nil, errors.Wrapf(err, "encode trx %d", idx)
+		}
+	}
+
+	if err := encoder.Encode(blk.BlockExtensions); err != nil {
+		return nil, errors.Wrap(err, "encode block extensions")
+	}
+
+	return buff.Bytes(), nil
+}
+
+func encodeTrxReceipt(encoder *eos.Encoder, receipt *TransactionReceipt) error {
+	if err := encoder.Encode(&receipt.TransactionReceiptHeader); err != nil {
+		return err
+	}
+
+	trx := &receipt.Transaction
+	if trx.Packed == nil {
+		if err := encoder.Encode(trxVariantID); err != nil {
+			return err
+		}
+		return encoder.Encode(trx.ID)
+	}
+
+	if err := encoder.Encode(trxVariantPacked); err != nil {
+		return err
+	}
+	return encoder.Encode(trx.Packed)
+}
@@ -0,0 +1,46 @@
+package types
+
+import (
+	"testing"
+
+	eos "github.com/eoscanada/eos-go"
+)
+
+// TestEncodeBlockWithTrxs test the block with trxs encoded can be decoded
+func TestEncodeBlockWithTrxs(t *testing.T) {
+	g := newGeneratorForTest(t, WithGenTransactions(func(blockNum uint32) [][]*Action {
+		return [][]*Action{{{
+			Account:       AccountName("eosio.token"),
+			Name:          ActionName("transfer"),
+			Authorization: []PermissionLevel{{Actor: AccountName("alice"), Permission: eos.PermissionName("active")}},
+			ActionData:    eos.NewActionDataFromHexData([]byte{1, 2, 3}),
+		}}}
+	}))
+
+	blk, err := g.Next()
+	if err != nil {
+		t.Fatalf("generate error %s", err.Error())
+	}
+
+	data, err := EncodeBlock(blk)
+	if err != nil {
+		t.Fatalf("encode block error %s", err.Error())
+	}
+
+	got := &SignedBlock{}
+	decoder := NewDecoder(data)
+	decoder.DecodeActions(false)
+	if err := decoder.Decode(got); err != nil {
+		t.Fatalf("decode block error %s", err.Error())
+	}
+
+	id, _ := blk.BlockID()
+	gotID, _ := got.BlockID()
+	if !IsChecksumEq(id, gotID) {
+		t.Errorf("block id diff")
+	}
+
+	if len(got.Transactions) != 1 || !IsChecksumEq(got.Transactions[0].Transaction.ID, blk.Transactions[0].Transaction.ID) {
+		t.Errorf("trx diff after decode")
+	}
+}
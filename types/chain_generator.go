@@ -0,0 +1,491 @@
+package types
+
+import (
+	"crypto/sha256"
+	"time"
+
+	eos "github.com/eoscanada/eos-go"
+	"github.com/eoscanada/eos-go/ecc"
+	"github.com/pkg/errors"
+)
+
+// PrivateKey ecc types
+type PrivateKey = ecc.PrivateKey
+
+// Action eos type
+type Action = eos.Action
+
+// PermissionLevel eos type
+type PermissionLevel = eos.PermissionLevel
+
+// Transaction eos type
+type Transaction = eos.Transaction
+
+// SignedTransaction eos type
+type SignedTransaction = eos.SignedTransaction
+
+// PackedTransaction eos type
+type PackedTransaction = eos.PackedTransaction
+
+// TransactionReceipt eos type
+type TransactionReceipt = eos.TransactionReceipt
+
+// TransactionStatus eos type
+type TransactionStatus = eos.TransactionStatus
+
+// TransactionStatusExecuted the trx succeed and no error handler executed
+const TransactionStatusExecuted = eos.TransactionStatusExecuted
+
+const (
+	// BlockIntervalMs the interval of block slots in eos
+	BlockIntervalMs = 500
+	// ProducerRepetitions the number of blocks one producer makes in a round
+	ProducerRepetitions = 12
+)
+
+// FixtureBlockSigDigest the digest signed by producer for a block in chains made by ChainGenerator,
+// like nodeos it is sha256(sha256(header) + pending schedule hash), but the block merkle root
+// is not included, so the signatures of fixture blocks are only for tests, nodeos not accept them
+func FixtureBlockSigDigest(header *eos.BlockHeader, pendingScheduleHash Checksum256) ([]byte, error) {
+	data, err := eos.MarshalBinary(header)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal header")
+	}
+
+	headerDigest := sha256.Sum256(data)
+
+	h := sha256.New()
+	h.Write(headerDigest[:])
+	h.Write(pendingScheduleHash)
+	return h.Sum(nil), nil
+}
+
+// ScheduleHash the sha256 of the producer schedule
+func ScheduleHash(schedule *eos.ProducerSchedule) (Checksum256, error) {
+	data, err := eos.MarshalBinary(schedule)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal schedule")
+	}
+
+	h := sha256.Sum256(data)
+	return h[:], nil
+}
+
+// NewKeyFromSeed create a K1 private key by a seed, the same seed will get the same key
+func NewKeyFromSeed(seed string) (*PrivateKey, error) {
+	return ecc.NewPrivateKeyFromSeed(seed)
+}
+
+// TrxFactory create actions for transactions in a block, each []*Action will be a transaction
+type TrxFactory func(blockNum uint32) [][]*Action
+
+// ChainGenerator generate linked signed blocks for tests, each block is signed
+// by the producer key in current schedule over FixtureBlockSigDigest, keys are generated from producer names,
+// the pending schedule of a block is the last schedule proposed before it, or the first schedule
+type ChainGenerator struct {
+	chainID    Checksum256
+	startTime  time.Time
+	repetition uint32
+	trxFactory TrxFactory
+
+	keys            map[AccountName]*PrivateKey
+	initProducers   []AccountName
+	producers       []AccountName
+	scheduleVersion uint32
+	pendingSchedule []AccountName
+	// pendingScheduleHash the hash of the last schedule proposed, signed with the headers
+	pendingScheduleHash Checksum256
+
+	// skipSlots slots skipped by forks, so blocks in fork is diff from origin
+	skipSlots uint32
+	blocks    []*SignedBlock
+}
+
+// ChainGenOption option for ChainGenerator
+type ChainGenOption func(*ChainGenerator)
+
+// WithGenProducers set the producers in first schedule
+func WithGenProducers(producers ...string) ChainGenOption {
+	return func(g *ChainGenerator) {
+		g.producers = make([]AccountName, 0, len(producers))
+		for _, p := range producers {
+			g.producers = append(g.producers, AccountName(p))
+		}
+	}
+}
+
+// WithGenStartTime set the timestamp of block 1
+func WithGenStartTime(t time.Time) ChainGenOption {
+	return func(g *ChainGenerator) {
+		g.startTime = t
+	}
+}
+
+// WithGenRepetition set the number of blocks one producer makes in a round
+func WithGenRepetition(repetition uint32) ChainGenOption {
+	return func(g *ChainGenerator) {
+		g.repetition = repetition
+	}
+}
+
+// WithGenTransactions set the factory to create transactions for blocks
+func WithGenTransactions(f TrxFactory) ChainGenOption {
+	return func(g *ChainGenerator) {
+		g.trxFactory = f
+	}
+}
+
+// NewChainGenerator create a chain generator
+func NewChainGenerator(chainID Checksum256, opts ...ChainGenOption) (*ChainGenerator, error) {
+	res := &ChainGenerator{
+		chainID:    chainID,
+		startTime:  time.Unix(time.Now().Unix(), 0).UTC().Add(-24 * time.Hour),
+		repetition: ProducerRepetitions,
+		keys:       make(map[AccountName]*PrivateKey, 32),
+		producers:  []AccountName{AccountName("eosio")},
+		blocks:     make([]*SignedBlock, 0, 1024),
+	}
+
+	for _, o := range opts {
+		o(res)
+	}
+
+	if len(res.producers) == 0 {
+		return nil, errors.New("no producers")
+	}
+
+	if res.repetition == 0 {
+		return nil, errors.New("repetition should not be zero")
+	}
+
+	res.initProducers = res.producers
+
+	genesis, err := res.newSchedule(0, res.initProducers)
+	if err != nil {
+		return nil, err
+	}
+
+	res.pendingScheduleHash, err = ScheduleHash(genesis)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Key get the private key for an account or producer
+func (g *ChainGenerator) Key(account AccountName) (*PrivateKey, error) {
+	if k, ok := g.keys[account]; ok {
+		return k, nil
+	}
+
+	k, err := NewKeyFromSeed(string(account))
+	if err != nil {
+		return nil, errors.Wrapf(err, "new key for %s", account)
+	}
+
+	g.keys[account] = k
+	return k, nil
+}
+
+// ChainID the chain id used to sign transactions
+func (g *ChainGenerator) ChainID() Checksum256 {
+	return g.chainID
+}
+
+// Producers current producer schedule
+func (g *ChainGenerator) Producers() []AccountName {
+	return g.producers
+}
+
+// PendingScheduleHash the hash of the pending schedule of the block in num, it is the last schedule
+// proposed by the blocks until the num, or the first schedule if no one proposed
+func (g *ChainGenerator) PendingScheduleHash(blockNum uint32) (Checksum256, error) {
+	if blockNum > g.HeadBlockNum() {
+		return nil, errors.Errorf("block %d after head %d", blockNum, g.HeadBlockNum())
+	}
+
+	for idx := int(blockNum) - 1; idx >= 0; idx-- {
+		if schedule := g.blocks[idx].NewProducersV1; schedule != nil {
+			return ScheduleHash(schedule)
+		}
+	}
+
+	genesis, err := g.newSchedule(0, g.initProducers)
+	if err != nil {
+		return nil, err
+	}
+	return ScheduleHash(genesis)
+}
+
+// SetProducers propose a new producer schedule, the next block will carry the new
+// producers and the blocks after it will be produced by the new schedule
+func (g *ChainGenerator) SetProducers(producers ...string) error {
+	if len(producers) == 0 {
+		return errors.New("no producers")
+	}
+
+	g.pendingSchedule = make([]AccountName, 0, len(producers))
+	for _, p := range producers {
+		g.pendingSchedule = append(g.pendingSchedule, AccountName(p))
+	}
+
+	return nil
+}
+
+// Blocks all blocks generated
+func (g *ChainGenerator) Blocks() []*SignedBlock {
+	return g.blocks
+}
+
+// HeadBlockNum the num of the last block generated, 0 if no block
+func (g *ChainGenerator) HeadBlockNum() uint32 {
+	return uint32(len(g.blocks))
+}
+
+// HeadBlock the last block generated
+func (g *ChainGenerator) HeadBlock() *SignedBlock {
+	if len(g.blocks) == 0 {
+		return nil
+	}
+	return g.blocks[len(g.blocks)-1]
+}
+
+// Generate generate n blocks then return them
+func (g *ChainGenerator) Generate(n int) ([]*SignedBlock, error) {
+	res := make([]*SignedBlock, 0, n)
+	for i := 0; i < n; i++ {
+		blk, err := g.Next()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, blk)
+	}
+	return res, nil
+}
+
+// GenerateTo generate blocks until head block num is headNum
+func (g *ChainGenerator) GenerateTo(headNum uint32) ([]*SignedBlock, error) {
+	if headNum <= g.HeadBlockNum() {
+		return nil, nil
+	}
+	return g.Generate(int(headNum - g.HeadBlockNum()))
+}
+
+// Fork create a new generator which has the same blocks before forkNum,
+// the blocks after forkNum in new generator will be diff from this one
+func (g *ChainGenerator) Fork(forkNum uint32) (*ChainGenerator, error) {
+	if forkNum == 0 || forkNum > g.HeadBlockNum()+1 {
+		return nil, errors.Errorf("cannot fork at %d, head is %d", forkNum, g.HeadBlockNum())
+	}
+
+	res := &ChainGenerator{
+		chainID:       g.chainID,
+		startTime:     g.startTime,
+		repetition:    g.repetition,
+		trxFactory:    g.trxFactory,
+		keys:          make(map[AccountName]*PrivateKey, len(g.keys)),
+		initProducers: g.initProducers,
+		producers:     g.initProducers,
+		skipSlots:     g.skipSlots + 1,
+		blocks:        make([]*SignedBlock, 0, len(g.blocks)+1024),
+	}
+
+	for account, k := range g.keys {
+		res.keys[account] = k
+	}
+
+	res.blocks = append(res.blocks, g.blocks[:forkNum-1]...)
+
+	hash, err := g.PendingScheduleHash(forkNum - 1)
+	if err != nil {
+		return nil, err
+	}
+	res.pendingScheduleHash = hash
+
+	// rebuild schedule state at fork point
+	for _, blk := range res.blocks {
+		if blk.NewProducersV1 != nil {
+			res.producers = make([]AccountName, 0, len(blk.NewProducersV1.Producers))
+			for _, p := range blk.NewProducersV1.Producers {
+				res.producers = append(res.producers, p.AccountName)
+			}
+			res.scheduleVersion = blk.NewProducersV1.Version
+		}
+	}
+
+	return res, nil
+}
+
+// Next generate next block
+func (g *ChainGenerator) Next() (*SignedBlock, error) {
+	num := g.HeadBlockNum() + 1
+	slot := num - 1 + g.skipSlots
+
+	blk := NewEmptyBlock()
+	blk.Timestamp = BlockTimestamp{Time: g.startTime.Add(time.Duration(slot) * BlockIntervalMs * time.Millisecond)}
+	blk.Producer = g.producers[(slot/g.repetition)%uint32(len(g.producers))]
+	blk.Previous = make(Checksum256, 32)
+	blk.TransactionMRoot = make(Checksum256, 32)
+	blk.ActionMRoot = make(Checksum256, 32)
+	blk.ScheduleVersion = g.scheduleVersion
+	blk.NewProducersV1 = nil
+
+	if head := g.HeadBlock(); head != nil {
+		prevID, err := head.BlockID()
+		if err != nil {
+			return nil, errors.Wrapf(err, "block id %d", head.BlockNumber())
+		}
+		blk.Previous = prevID
+	}
+
+	if g.pendingSchedule != nil {
+		schedule, err := g.newSchedule(g.scheduleVersion+1, g.pendingSchedule)
+		if err != nil {
+			return nil, err
+		}
+		blk.NewProducersV1 = schedule
+	}
+
+	if g.trxFactory != nil {
+		for _, actions := range g.trxFactory(num) {
+			receipt, err := g.newTrxReceipt(blk, actions)
+			if err != nil {
+				return nil, errors.Wrapf(err, "new trx in block %d", num)
+			}
+			blk.Transactions = append(blk.Transactions, *receipt)
+		}
+	}
+
+	if blk.NewProducersV1 != nil {
+		hash, err := ScheduleHash(blk.NewProducersV1)
+		if err != nil {
+			return nil, err
+		}
+		g.pendingScheduleHash = hash
+	}
+
+	if err := g.sign(blk); err != nil {
+		return nil, err
+	}
+
+	g.blocks = append(g.blocks, blk)
+
+	// new schedule active after the block which carry it
+	if blk.NewProducersV1 != nil {
+		g.producers = g.pendingSchedule
+		g.scheduleVersion = blk.NewProducersV1.Version
+		g.pendingSchedule = nil
+	}
+
+	return blk, nil
+}
+
+func (g *ChainGenerator) newSchedule(version uint32, producers []AccountName) (*eos.ProducerSchedule, error) {
+	res := &eos.ProducerSchedule{
+		Version:   version,
+		Producers: make([]eos.ProducerKey, 0, len(producers)),
+	}
+
+	for _, p := range producers {
+		k, err := g.Key(p)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Producers = append(res.Producers, eos.ProducerKey{
+			AccountName:     p,
+			BlockSigningKey: k.PublicKey(),
+		})
+	}
+
+	return res, nil
+}
+
+func (g *ChainGenerator) sign(blk *SignedBlock) error {
+	k, err := g.Key(blk.Producer)
+	if err != nil {
+		return err
+	}
+
+	digest, err := FixtureBlockSigDigest(&blk.BlockHeader, g.pendingScheduleHash)
+	if err != nil {
+		return err
+	}
+
+	sig, err := k.Sign(digest)
+	if err != nil {
+		return errors.Wrapf(err, "sign block %d", blk.BlockNumber())
+	}
+
+	blk.ProducerSignature = sig
+	return nil
+}
+
+// newTrxReceipt create a executed trx receipt, the trx is signed by all actors in authorization
+func (g *ChainGenerator) newTrxReceipt(blk *SignedBlock, actions []*Action) (*TransactionReceipt, error) {
+	trx := eos.NewTransaction(actions, &eos.TxOptions{
+		HeadBlockID: blk.Previous,
+	})
+	trx.Expiration = eos.JSONTime{Time: blk.Timestamp.Add(30 * time.Second)}
+
+	signed := eos.NewSignedTransaction(trx)
+	payload, cfd, err := signed.PackedTransactionAndCFD()
+	if err != nil {
+		return nil, errors.Wrap(err, "pack trx")
+	}
+
+	signedBy := make(map[AccountName]bool, 4)
+	for _, act := range actions {
+		for _, auth := range act.Authorization {
+			if signedBy[auth.Actor] {
+				continue
+			}
+			signedBy[auth.Actor] = true
+
+			k, err := g.Key(auth.Actor)
+			if err != nil {
+				return nil, err
+			}
+
+			sig, err := k.Sign(eos.SigDigest(g.chainID, payload, cfd))
+			if err != nil {
+				return nil, errors.Wrapf(err, "sign by %s", auth.Actor)
+			}
+			signed.Signatures = append(signed.Signatures, sig)
+		}
+	}
+
+	packed, err := signed.Pack(eos.CompressionNone)
+	if err != nil {
+		return nil, errors.Wrap(err, "pack signed trx")
+	}
+
+	id, err := packed.ID()
+	if err != nil {
+		return nil, errors.Wrap(err, "trx id")
+	}
+
+	return &TransactionReceipt{
+		TransactionReceiptHeader: eos.TransactionReceiptHeader{
+			Status:               eos.TransactionStatusExecuted,
+			CPUUsageMicroSeconds: 100,
+			NetUsageWords:        eos.Varuint32((len(packed.PackedTransaction) + 7) / 8),
+		},
+		Transaction: eos.TransactionWithID{
+			ID:     id,
+			Packed: packed,
+		},
+	}, nil
+}
+
+// VerifyFixtureBlockSignature check the block made by ChainGenerator is signed by the key with the pending
+// schedule hash of the block, it cannot verify the blocks from nodeos, see FixtureBlockSigDigest
+func VerifyFixtureBlockSignature(blk *SignedBlock, key PublicKey, pendingScheduleHash Checksum256) (bool, error) {
+	digest, err := FixtureBlockSigDigest(&blk.BlockHeader, pendingScheduleHash)
+	if err != nil {
+		return false, err
+	}
+
+	return blk.ProducerSignature.Verify(digest, key), nil
+}
@@ -0,0 +1,132 @@
+package types
+
+import (
+	"testing"
+
+	eos "github.com/eoscanada/eos-go"
+)
+
+func newGeneratorForTest(t *testing.T, opts ...ChainGenOption) *ChainGenerator {
+	g, err := NewChainGenerator(MustNewChecksum256("76eab2b704733e933d0e4eb6cc24d260d9fbbe5d93d760392e97398f4e301448"), opts...)
+	if err != nil {
+		t.Fatalf("new generator error %s", err.Error())
+	}
+	return g
+}
+
+func checkLinked(t *testing.T, blocks []*SignedBlock) {
+	for idx, blk := range blocks {
+		if blk.BlockNumber() != uint32(idx+1) {
+			t.Fatalf("block num error %d %d", idx+1, blk.BlockNumber())
+		}
+
+		if idx == 0 {
+			continue
+		}
+
+		prevID, _ := blocks[idx-1].BlockID()
+		if !IsChecksumEq(prevID, blk.Previous) {
+			t.Fatalf("block %d not linked", blk.BlockNumber())
+		}
+	}
+}
+
+// TestChainGeneratorLinkAndSign test blocks are linked and signed by producers in schedule
+func TestChainGeneratorLinkAndSign(t *testing.T) {
+	g := newGeneratorForTest(t, WithGenProducers("prod.a", "prod.b"), WithGenRepetition(4))
+
+	if _, err := g.Generate(10); err != nil {
+		t.Fatalf("generate error %s", err.Error())
+	}
+
+	if err := g.SetProducers("prod.c"); err != nil {
+		t.Fatalf("set producers error %s", err.Error())
+	}
+
+	if _, err := g.GenerateTo(20); err != nil {
+		t.Fatalf("generate error %s", err.Error())
+	}
+
+	checkLinked(t, g.Blocks())
+
+	for _, blk := range g.Blocks() {
+		k, _ := g.Key(blk.Producer)
+		hash, err := g.PendingScheduleHash(blk.BlockNumber())
+		if err != nil {
+			t.Fatalf("pending schedule hash %d error %s", blk.BlockNumber(), err.Error())
+		}
+
+		ok, err := VerifyFixtureBlockSignature(blk, k.PublicKey(), hash)
+		if err != nil || !ok {
+			t.Fatalf("block %d signature error %v", blk.BlockNumber(), err)
+		}
+
+		num := blk.BlockNumber()
+		if num > 11 {
+			// signed with the schedule proposed by block 11
+			genesis, _ := g.PendingScheduleHash(10)
+			if ok, _ := VerifyFixtureBlockSignature(blk, k.PublicKey(), genesis); ok {
+				t.Errorf("block %d should not verified by the first schedule", num)
+			}
+		}
+
+		switch {
+		case num == 11 && blk.NewProducersV1 == nil:
+			t.Errorf("block 11 should carry new producers")
+		case num > 11 && (blk.Producer != AccountName("prod.c") || blk.ScheduleVersion != 1):
+			t.Errorf("block %d should by new schedule, got %s v%d", num, blk.Producer, blk.ScheduleVersion)
+		case num <= 4 && blk.Producer != AccountName("prod.a"):
+			t.Errorf("block %d should by prod.a, got %s", num, blk.Producer)
+		case num > 4 && num <= 8 && blk.Producer != AccountName("prod.b"):
+			t.Errorf("block %d should by prod.b, got %s", num, blk.Producer)
+		}
+	}
+}
+
+// TestChainGeneratorFork test fork shares blocks before fork num
+func TestChainGeneratorFork(t *testing.T) {
+	g := newGeneratorForTest(t, WithGenTransactions(func(blockNum uint32) [][]*Action {
+		return [][]*Action{{{
+			Account:       AccountName("eosio.token"),
+			Name:          ActionName("transfer"),
+			Authorization: []PermissionLevel{{Actor: AccountName("alice"), Permission: eos.PermissionName("active")}},
+			ActionData:    eos.NewActionDataFromHexData([]byte{1, 2, 3}),
+		}}}
+	}))
+
+	if _, err := g.Generate(30); err != nil {
+		t.Fatalf("generate error %s", err.Error())
+	}
+
+	fork, err := g.Fork(21)
+	if err != nil {
+		t.Fatalf("fork error %s", err.Error())
+	}
+
+	if _, err := fork.GenerateTo(35); err != nil {
+		t.Fatalf("generate fork error %s", err.Error())
+	}
+
+	checkLinked(t, fork.Blocks())
+
+	for num := uint32(1); num <= 30; num++ {
+		id1, _ := g.Blocks()[num-1].BlockID()
+		id2, _ := fork.Blocks()[num-1].BlockID()
+		if (num < 21) != IsChecksumEq(id1, id2) {
+			t.Errorf("block %d fork state error", num)
+		}
+	}
+
+	// the keys of fork not shared with origin
+	if _, err := fork.Key(AccountName("bob")); err != nil {
+		t.Fatalf("key error %s", err.Error())
+	}
+	if _, ok := g.keys[AccountName("bob")]; ok {
+		t.Errorf("keys of fork should not be shared")
+	}
+
+	trx := fork.HeadBlock().Transactions[0]
+	if len(trx.Transaction.Packed.Signatures) != 1 {
+		t.Fatalf("trx should signed by alice")
+	}
+}
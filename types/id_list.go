@@ -0,0 +1,110 @@
+package types
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// IDListMode mode of the id list in notice and request msg, see id_list_modes in nodeos
+type IDListMode uint32
+
+const (
+	// IDListModeNone no ids
+	IDListModeNone = IDListMode(iota)
+	// IDListModeCatchUp peer is catching up, pending is the head num, ids may have the head id
+	IDListModeCatchUp
+	// IDListModeLastIrrCatchUp peer is catching up to lib, pending is the lib or head num
+	IDListModeLastIrrCatchUp
+	// IDListModeNormal ids are the blocks or trxs
+	IDListModeNormal
+)
+
+var idListModeNames = map[IDListMode]string{
+	IDListModeNone:           "none",
+	IDListModeCatchUp:        "catch_up",
+	IDListModeLastIrrCatchUp: "last_irr_catch_up",
+	IDListModeNormal:         "normal",
+}
+
+func (m IDListMode) String() string {
+	if name, ok := idListModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint32(m))
+}
+
+// Bytes the mode encoded in OrderedBlockIDs
+func (m IDListMode) Bytes() [4]byte {
+	var res [4]byte
+	binary.LittleEndian.PutUint32(res[:], uint32(m))
+	return res
+}
+
+// GetIDListMode get the mode of id list
+func GetIDListMode(ids *OrderedBlockIDs) IDListMode {
+	return IDListMode(binary.LittleEndian.Uint32(ids.Mode[:]))
+}
+
+// NewIDList create an id list
+func NewIDList(mode IDListMode, pending uint32, ids []Checksum256) OrderedBlockIDs {
+	return OrderedBlockIDs{
+		Mode:    mode.Bytes(),
+		Pending: pending,
+		IDs:     ids,
+	}
+}
+
+// NewLastIrrCatchupNotice notice peer we are ahead of its lib, like nodeos when peer lib behind ours
+func NewLastIrrCatchupNotice(libNum, headNum uint32) *NoticeMessage {
+	return &NoticeMessage{
+		KnownTrx:    NewIDList(IDListModeLastIrrCatchUp, libNum, nil),
+		KnownBlocks: NewIDList(IDListModeLastIrrCatchUp, headNum, nil),
+	}
+}
+
+// NewCatchupNotice notice peer our head, like nodeos when peer head behind ours
+func NewCatchupNotice(headNum uint32, headID Checksum256) *NoticeMessage {
+	return &NoticeMessage{
+		KnownTrx:    NewIDList(IDListModeNone, 0, nil),
+		KnownBlocks: NewIDList(IDListModeCatchUp, headNum, []Checksum256{headID}),
+	}
+}
+
+// NewBlocksNotice notice peer the blocks we have
+func NewBlocksNotice(ids []Checksum256) *NoticeMessage {
+	return &NoticeMessage{
+		KnownTrx:    NewIDList(IDListModeNone, 0, nil),
+		KnownBlocks: NewIDList(IDListModeNormal, 0, ids),
+	}
+}
+
+// NewTrxNotice notice peer the trxs we have
+func NewTrxNotice(ids []Checksum256) *NoticeMessage {
+	return &NoticeMessage{
+		KnownTrx:    NewIDList(IDListModeNormal, 0, ids),
+		KnownBlocks: NewIDList(IDListModeNone, 0, nil),
+	}
+}
+
+// NewCatchupRequest request the blocks after headID to the head of peer
+func NewCatchupRequest(headID Checksum256) *RequestMessage {
+	return &RequestMessage{
+		ReqTrx:    NewIDList(IDListModeNone, 0, nil),
+		ReqBlocks: NewIDList(IDListModeCatchUp, 0, []Checksum256{headID}),
+	}
+}
+
+// NewRequest request blocks and trxs by ids, the list is none mode if no ids
+func NewRequest(blockIDs, trxIDs []Checksum256) *RequestMessage {
+	return &RequestMessage{
+		ReqTrx:    newRequestIDList(trxIDs),
+		ReqBlocks: newRequestIDList(blockIDs),
+	}
+}
+
+func newRequestIDList(ids []Checksum256) OrderedBlockIDs {
+	if len(ids) == 0 {
+		return NewIDList(IDListModeNone, 0, nil)
+	}
+	return NewIDList(IDListModeNormal, 0, ids)
+}
@@ -0,0 +1,67 @@
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	eos "github.com/eoscanada/eos-go"
+)
+
+// TestIDListMode test modes encoded same as nodeos
+func TestIDListMode(t *testing.T) {
+	cases := []struct {
+		mode IDListMode
+		data [4]byte
+		name string
+	}{
+		{IDListModeNone, [4]byte{0, 0, 0, 0}, "none"},
+		{IDListModeCatchUp, [4]byte{1, 0, 0, 0}, "catch_up"},
+		{IDListModeLastIrrCatchUp, [4]byte{2, 0, 0, 0}, "last_irr_catch_up"},
+		{IDListModeNormal, [4]byte{3, 0, 0, 0}, "normal"},
+	}
+
+	for _, c := range cases {
+		if c.mode.Bytes() != c.data {
+			t.Errorf("mode %s bytes %v, expect %v", c.name, c.mode.Bytes(), c.data)
+		}
+
+		if c.mode.String() != c.name {
+			t.Errorf("mode name %s, expect %s", c.mode.String(), c.name)
+		}
+
+		ids := OrderedBlockIDs{Mode: c.data}
+		if GetIDListMode(&ids) != c.mode {
+			t.Errorf("get mode %s, expect %s", GetIDListMode(&ids), c.name)
+		}
+	}
+}
+
+// TestIDListMsgs test the msgs can encode and decode with modes
+func TestIDListMsgs(t *testing.T) {
+	id := MustNewChecksum256("00000064a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c")
+
+	req := NewRequest([]Checksum256{id}, nil)
+	if GetIDListMode(&req.ReqBlocks) != IDListModeNormal || GetIDListMode(&req.ReqTrx) != IDListModeNone {
+		t.Errorf("request modes error %s %s", req.ReqBlocks.String(), req.ReqTrx.String())
+	}
+
+	notice := NewCatchupNotice(100, id)
+	data, err := eos.MarshalBinary(notice)
+	if err != nil {
+		t.Fatalf("marshal notice error %s", err.Error())
+	}
+
+	res := &NoticeMessage{}
+	if err := eos.UnmarshalBinary(data, res); err != nil {
+		t.Fatalf("unmarshal notice error %s", err.Error())
+	}
+
+	if GetIDListMode(&res.KnownBlocks) != IDListModeCatchUp || res.KnownBlocks.Pending != 100 ||
+		len(res.KnownBlocks.IDs) != 1 || !bytes.Equal(res.KnownBlocks.IDs[0], id) {
+		t.Errorf("notice decoded error %s", res.KnownBlocks.String())
+	}
+
+	if GetIDListMode(&res.KnownTrx) != IDListModeNone {
+		t.Errorf("notice trx mode should be none, got %s", GetIDListMode(&res.KnownTrx))
+	}
+}
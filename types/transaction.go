@@ -0,0 +1,148 @@
+package types
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+
+	eos "github.com/eoscanada/eos-go"
+	"github.com/pkg/errors"
+)
+
+// maxUnpackedTrxSize max size of a trx or its context free data after decompressed, to avoid zip bombs
+const maxUnpackedTrxSize = 1024 * 1024
+
+// Extension eos type
+type Extension = eos.Extension
+
+// CompressionType eos type
+type CompressionType = eos.CompressionType
+
+const (
+	// CompressionNone the trx is not compressed
+	CompressionNone = eos.CompressionNone
+	// CompressionZlib the trx is compressed by zlib
+	CompressionZlib = eos.CompressionZlib
+)
+
+// decompress decompress data by compression type
+func decompress(compression CompressionType, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionZlib:
+		if len(data) == 0 {
+			return data, nil
+		}
+
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "new zlib reader")
+		}
+		defer r.Close()
+
+		res, err := ioutil.ReadAll(io.LimitReader(r, maxUnpackedTrxSize+1))
+		if err != nil {
+			return nil, errors.Wrap(err, "zlib decompress")
+		}
+
+		if len(res) > maxUnpackedTrxSize {
+			return nil, errors.Errorf("unpacked size is larger than %d", maxUnpackedTrxSize)
+		}
+
+		return res, nil
+	default:
+		return nil, errors.Errorf("unknown compression %d", compression)
+	}
+}
+
+// DecompressTransaction get the trx bytes and context free data bytes before compressed
+func DecompressTransaction(packed *PackedTransaction) (trx []byte, cfd []byte, err error) {
+	trx, err = decompress(packed.Compression, packed.PackedTransaction)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "trx")
+	}
+
+	cfd, err = decompress(packed.Compression, packed.PackedContextFreeData)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "context free data")
+	}
+
+	return trx, cfd, nil
+}
+
+// DecodeTransaction decode the trx in packed, the data of actions are not decoded by abi
+func DecodeTransaction(packed *PackedTransaction) (*Transaction, error) {
+	data, err := decompress(packed.Compression, packed.PackedTransaction)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress trx")
+	}
+
+	decoder := NewDecoder(data)
+	decoder.DecodeActions(false)
+
+	trx := &Transaction{}
+	if err := decoder.Decode(trx); err != nil {
+		return nil, errors.Wrap(err, "decode trx")
+	}
+
+	return trx, nil
+}
+
+// DecodeContextFreeData decode the context free data in packed
+func DecodeContextFreeData(packed *PackedTransaction) ([][]byte, error) {
+	data, err := decompress(packed.Compression, packed.PackedContextFreeData)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress context free data")
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var cfd []eos.HexBytes
+	if err := NewDecoder(data).Decode(&cfd); err != nil {
+		return nil, errors.Wrap(err, "decode context free data")
+	}
+
+	res := make([][]byte, 0, len(cfd))
+	for _, d := range cfd {
+		res = append(res, CopyBytes(d))
+	}
+
+	return res, nil
+}
+
+// TransactionID the id of trx, it is the sha256 of the trx bytes before compressed
+func TransactionID(packed *PackedTransaction) (Checksum256, error) {
+	data, err := decompress(packed.Compression, packed.PackedTransaction)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress trx")
+	}
+
+	h := sha256.Sum256(data)
+	return Checksum256(h[:]), nil
+}
+
+// SigningKeys recover the public keys signed the trx from signatures, by the order of signatures
+func SigningKeys(packed *PackedTransaction, chainID Checksum256) ([]PublicKey, error) {
+	trx, cfd, err := DecompressTransaction(packed)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := eos.SigDigest(chainID, trx, cfd)
+
+	res := make([]PublicKey, 0, len(packed.Signatures))
+	for idx, sig := range packed.Signatures {
+		key, err := sig.PublicKey(digest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "recover key by signature %d", idx)
+		}
+		res = append(res, key)
+	}
+
+	return res, nil
+}
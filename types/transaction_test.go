@@ -0,0 +1,96 @@
+package types
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	eos "github.com/eoscanada/eos-go"
+)
+
+// TestUnpackTransaction test decode, id and signing keys of zlib compressed trx
+func TestUnpackTransaction(t *testing.T) {
+	chainID := MustNewChecksum256("76eab2b704733e933d0e4eb6cc24d260d9fbbe5d93d760392e97398f4e301448")
+	key, err := NewKeyFromSeed("alice")
+	if err != nil {
+		t.Fatalf("new key error %s", err.Error())
+	}
+
+	act := &Action{
+		Account:       AccountName("eosio.token"),
+		Name:          ActionName("transfer"),
+		Authorization: []PermissionLevel{{Actor: AccountName("alice"), Permission: eos.PermissionName("active")}},
+		ActionData:    eos.NewActionDataFromHexData([]byte{1, 2, 3}),
+	}
+
+	trx := eos.NewTransaction([]*Action{act, act}, &eos.TxOptions{HeadBlockID: make(Checksum256, 32)})
+	trx.Expiration = eos.JSONTime{Time: time.Unix(time.Now().Unix()+30, 0).UTC()}
+	trx.ContextFreeActions = []*Action{{
+		Account:    AccountName("eosio.null"),
+		Name:       ActionName("nonce"),
+		ActionData: eos.NewActionDataFromHexData([]byte{4}),
+	}}
+
+	signed := eos.NewSignedTransaction(trx)
+	signed.ContextFreeData = []eos.HexBytes{{5, 6}}
+
+	payload, cfd, err := signed.PackedTransactionAndCFD()
+	if err != nil {
+		t.Fatalf("pack error %s", err.Error())
+	}
+
+	sig, err := key.Sign(eos.SigDigest(chainID, payload, cfd))
+	if err != nil {
+		t.Fatalf("sign error %s", err.Error())
+	}
+	signed.Signatures = append(signed.Signatures, sig)
+
+	plain, err := signed.Pack(CompressionNone)
+	if err != nil {
+		t.Fatalf("pack error %s", err.Error())
+	}
+
+	packed, err := signed.Pack(CompressionZlib)
+	if err != nil {
+		t.Fatalf("pack zlib error %s", err.Error())
+	}
+
+	decoded, err := DecodeTransaction(packed)
+	if err != nil {
+		t.Fatalf("decode error %s", err.Error())
+	}
+
+	if len(decoded.Actions) != 2 || len(decoded.ContextFreeActions) != 1 ||
+		!decoded.Expiration.Equal(trx.Expiration.Time) ||
+		!bytes.Equal(decoded.Actions[1].ActionData.HexData, []byte{1, 2, 3}) {
+		t.Errorf("trx decoded diff %v", decoded)
+	}
+
+	data, err := DecodeContextFreeData(packed)
+	if err != nil {
+		t.Fatalf("decode cfd error %s", err.Error())
+	}
+
+	if len(data) != 1 || !bytes.Equal(data[0], []byte{5, 6}) {
+		t.Errorf("context free data diff %v", data)
+	}
+
+	id, err := TransactionID(packed)
+	if err != nil {
+		t.Fatalf("trx id error %s", err.Error())
+	}
+
+	expectID, _ := plain.ID()
+	if !IsChecksumEq(id, expectID) {
+		t.Errorf("trx id diff %s %s", id, expectID)
+	}
+
+	keys, err := SigningKeys(packed, chainID)
+	if err != nil {
+		t.Fatalf("recover keys error %s", err.Error())
+	}
+
+	if len(keys) != 1 || keys[0].String() != key.PublicKey().String() {
+		t.Errorf("signing keys diff %v", keys)
+	}
+}
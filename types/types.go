@@ -35,6 +35,9 @@ type SyncRequestMessage = eos.SyncRequestMessage
 // SignedBlock eos msg type
 type SignedBlock = eos.SignedBlock
 
+// BlockHeader eos type
+type BlockHeader = eos.BlockHeader
+
 // PackedTransactionMessage eos msg type
 type PackedTransactionMessage = eos.PackedTransactionMessage
 
@@ -26,7 +26,7 @@ func newBlockForTest(num uint32) *SignedBlock {
 		BlockExtensions: make([]*eos.Extension, 0, 8),
 	}
 
-	res.Timestamp = eos.BlockTimestamp{time.Unix(time.Now().Unix(), 0).UTC()}
+	res.Timestamp = eos.BlockTimestamp{Time: time.Unix(time.Now().Unix(), 0).UTC()}
 
 	return res
 }
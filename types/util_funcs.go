@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"net"
 
@@ -37,6 +38,36 @@ func NewPublicKey(pubKey string) (out PublicKey, err error) {
 	return ecc.NewPublicKey(pubKey)
 }
 
+// MustNewPublicKey create public key, panic if the key is invalid
+func MustNewPublicKey(pubKey string) PublicKey {
+	res, err := NewPublicKey(pubKey)
+	if err != nil {
+		panic(errors.Wrapf(err, "decode public key %s", pubKey))
+	}
+
+	return res
+}
+
+// NewEmptySignature create an all-zero K1 signature, used when there is no key to sign
+func NewEmptySignature() Signature {
+	return ecc.MustNewSignatureFromData(make([]byte, 66))
+}
+
+// PacketDecodeError error when the data read from peer cannot decode to a packet
+type PacketDecodeError struct {
+	msg string
+}
+
+func (e *PacketDecodeError) Error() string {
+	return e.msg
+}
+
+// IsPacketDecodeError is the err caused by data cannot decode
+func IsPacketDecodeError(err error) bool {
+	_, ok := errors.Cause(err).(*PacketDecodeError)
+	return ok
+}
+
 // ReadChainPacket read chain packet for p2p from a conn
 func ReadChainPacket(r io.Reader, conn net.Conn) (packet *Packet, err error) {
 	return readPacket(r, conn)
@@ -61,7 +92,7 @@ func readPacket(r io.Reader, conn net.Conn) (*Packet, error) {
 	size := binary.LittleEndian.Uint32(lengthBytes)
 
 	if size > 16*1024*1024 {
-		return nil, errors.Errorf("packet is too large %d", size)
+		return nil, errors.WithStack(&PacketDecodeError{msg: fmt.Sprintf("packet is too large %d", size)})
 	}
 
 	payloadBytes := make([]byte, size, size)
@@ -83,12 +114,49 @@ func readPacket(r io.Reader, conn net.Conn) (*Packet, error) {
 	decoder.DecodeActions(false)
 	err = decoder.Decode(packet)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failing decode data %s", hex.EncodeToString(data))
+		return nil, errors.WithStack(&PacketDecodeError{
+			msg: fmt.Sprintf("Failing decode data %s: %s", hex.EncodeToString(data), err.Error()),
+		})
 	}
 	packet.Raw = data
 	return packet, nil
 }
 
+// EncodePacket encode a p2p msg to the packet bytes to send to peer
+func EncodePacket(msg Message) ([]byte, error) {
+	packet := &Packet{
+		Type:       msg.GetType(),
+		P2PMessage: msg,
+	}
+
+	// eos-go cannot encode GoAwayReason, so make the payload by self
+	if goAway, ok := msg.(*GoAwayMessage); ok {
+		nodeID := make([]byte, 32)
+		copy(nodeID, goAway.NodeID)
+
+		packet.P2PMessage = nil
+		packet.Payload = append([]byte{byte(goAway.Reason)}, nodeID...)
+	}
+
+	// eos-go encodes the trxs in block without the variant type, which nodeos and readPacket need
+	if blk, ok := msg.(*SignedBlock); ok {
+		payload, err := EncodeBlock(blk)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to encode block %d", blk.BlockNumber())
+		}
+
+		packet.P2PMessage = nil
+		packet.Payload = payload
+	}
+
+	buff := bytes.NewBuffer(make([]byte, 0, 512))
+	if err := eos.NewEncoder(buff).Encode(packet); err != nil {
+		return nil, errors.Wrapf(err, "unable to encode message %s", msg)
+	}
+
+	return buff.Bytes(), nil
+}
+
 // EncodeToEOS encode as the eos binary format
 func EncodeToEOS(obj interface{}) ([]byte, error) {
 	var buffer bytes.Buffer